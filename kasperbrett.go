@@ -3,26 +3,43 @@ package main
 import (
 	"bitbucket.org/kardianos/osext"
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/Unknwon/macaron"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
 	"github.com/boltdb/bolt"
 	"github.com/googollee/go-socket.io"
 	"github.com/macaron-contrib/binding"
 	"github.com/nu7hatch/gouuid"
+	"github.com/oliveagle/jsonpath"
 	"github.com/robertkrimen/otto"
 	"github.com/stretchr/graceful"
 	"github.com/stretchr/pat/stop"
 	"github.com/ttacon/chalk"
 	"gopkg.in/tomb.v2"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -89,12 +106,37 @@ type Config interface {
 	GetPort() int
 	GetDataFilePath() string
 	GetDataFlushInterval() int
+	GetSampleRetentionDays() int
+	GetRetentionCheckInterval() int
+	GetRollupRetentionDays() map[string]int
+	GetSampleBufferSize() int
+	GetSampleBufferOverflowPolicy() string
+	GetAllowCommandDataSources() bool
+	GetMaxResponseBodySize() int64
+	GetProcPath() string
 }
 
 type KasperbrettConfig struct {
 	Port              int
 	DataFilePath      string
 	DataFlushInterval int
+	// SampleRetentionDays applies to all data sources without a retention of their own (0 means forever)
+	SampleRetentionDays    int
+	RetentionCheckInterval int
+	// RollupRetentionDays maps rollup resolutions (1m, 1h, 1d) to the number of days their rollups are kept (0 means forever)
+	RollupRetentionDays map[string]int
+	// SampleBufferSize is the maximum number of samples that are buffered in memory between two flushes
+	SampleBufferSize int
+	// SampleBufferOverflowPolicy decides what happens to new samples while the buffer is full (block, drop-oldest, or spill)
+	SampleBufferOverflowPolicy string
+	// AllowCommandDataSources enables data sources that run local commands (disabled by default since anyone who can
+	// access the REST API could run arbitrary commands with the privileges of Kasperbrett)
+	AllowCommandDataSources bool
+	// MaxResponseBodySize is the number of bytes that are read of HTTP responses at most (0 means 10 MiB)
+	MaxResponseBodySize int64
+	// ProcPath is where system metrics data sources read the proc filesystem from (empty means /proc), e.g. the /proc
+	// of the host mounted into the container of Kasperbrett
+	ProcPath string
 }
 
 func (c *KasperbrettConfig) GetPort() int {
@@ -109,6 +151,38 @@ func (c *KasperbrettConfig) GetDataFlushInterval() int {
 	return c.DataFlushInterval
 }
 
+func (c *KasperbrettConfig) GetSampleRetentionDays() int {
+	return c.SampleRetentionDays
+}
+
+func (c *KasperbrettConfig) GetRetentionCheckInterval() int {
+	return c.RetentionCheckInterval
+}
+
+func (c *KasperbrettConfig) GetRollupRetentionDays() map[string]int {
+	return c.RollupRetentionDays
+}
+
+func (c *KasperbrettConfig) GetSampleBufferSize() int {
+	return c.SampleBufferSize
+}
+
+func (c *KasperbrettConfig) GetSampleBufferOverflowPolicy() string {
+	return c.SampleBufferOverflowPolicy
+}
+
+func (c *KasperbrettConfig) GetAllowCommandDataSources() bool {
+	return c.AllowCommandDataSources
+}
+
+func (c *KasperbrettConfig) GetMaxResponseBodySize() int64 {
+	return c.MaxResponseBodySize
+}
+
+func (c *KasperbrettConfig) GetProcPath() string {
+	return c.ProcPath
+}
+
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
@@ -187,6 +261,55 @@ func processConfig(configFilePath string) (Config, error) {
 		config.DataFilePath = fmt.Sprintf("%s%s", kasperbrettPath, config.DataFilePath)
 	}
 
+	if config.SampleRetentionDays < 0 {
+		return nil, errors.New("The sample retention must not be negative.")
+	}
+
+	if config.RetentionCheckInterval <= 0 {
+		config.RetentionCheckInterval = 3600 // 1 h
+	}
+
+	if config.RollupRetentionDays == nil {
+		config.RollupRetentionDays = map[string]int{}
+	}
+	for _, resolution := range RollupResolutions {
+		days, ok := config.RollupRetentionDays[resolution.Name]
+		if !ok {
+			config.RollupRetentionDays[resolution.Name] = resolution.DefaultRetentionDays
+		} else if days < 0 {
+			return nil, fmt.Errorf("The retention of %s rollups must not be negative.", resolution.Name)
+		}
+	}
+	for resolutionName := range config.RollupRetentionDays {
+		if _, err := GetRollupResolution(resolutionName); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MaxResponseBodySize < 0 {
+		return nil, errors.New("The maximum response body size must not be negative.")
+	} else if config.MaxResponseBodySize == 0 {
+		config.MaxResponseBodySize = DefaultMaxResponseBodySize
+	}
+
+	if len(config.ProcPath) == 0 {
+		config.ProcPath = DefaultProcPath
+	}
+
+	if config.SampleBufferSize < 0 {
+		return nil, errors.New("The sample buffer size must not be negative.")
+	} else if config.SampleBufferSize == 0 {
+		config.SampleBufferSize = 10000
+	}
+
+	switch config.SampleBufferOverflowPolicy {
+	case "":
+		config.SampleBufferOverflowPolicy = BufferOverflowSpill
+	case BufferOverflowBlock, BufferOverflowDropOldest, BufferOverflowSpill:
+	default:
+		return nil, fmt.Errorf("Unsupported sample buffer overflow policy: %s", config.SampleBufferOverflowPolicy)
+	}
+
 	return config, nil
 }
 
@@ -234,13 +357,14 @@ func NewKasperbrett(config Config) *Kasperbrett {
 }
 
 type Kasperbrett struct {
-	config          Config
-	osSignalsChan   chan os.Signal
-	shutdownChan    chan error
-	reportingEngine ReportingEngine
-	scheduler       Scheduler
-	restApi         RestApi
-	socketIOApi     SocketIOApi
+	config           Config
+	osSignalsChan    chan os.Signal
+	shutdownChan     chan error
+	reportingEngine  ReportingEngine
+	scheduler        Scheduler
+	retentionJanitor *RetentionJanitor
+	restApi          RestApi
+	socketIOApi      SocketIOApi
 }
 
 func (kb *Kasperbrett) Prepare() (*Kasperbrett, error) {
@@ -257,17 +381,56 @@ func (kb *Kasperbrett) Prepare() (*Kasperbrett, error) {
 	}
 
 	boltDataStore := NewBoltDataStore(kb.config.GetDataFilePath())
-	persistentDataStoreReporter := NewPersistentDataStoreReporter(boltDataStore, time.Second*time.Duration(kb.config.GetDataFlushInterval()))
+	sampleWriteAheadLog := NewSampleWriteAheadLog(kb.config.GetDataFilePath() + SampleWriteAheadLogFileSuffix)
+	sampleSpillLog := NewSampleWriteAheadLog(kb.config.GetDataFilePath() + SampleSpillLogFileSuffix)
+	persistentDataStoreReporter := NewPersistentDataStoreReporter(
+		boltDataStore,
+		sampleWriteAheadLog,
+		sampleSpillLog,
+		time.Second*time.Duration(kb.config.GetDataFlushInterval()),
+		kb.config.GetSampleBufferSize(),
+		kb.config.GetSampleBufferOverflowPolicy(),
+	)
+
+	// the status reporter relies on the data store, which gets prepared by the persistent data store reporter
+	dataSourceStatusReporter := NewDataSourceStatusReporter(boltDataStore, time.Second*time.Duration(kb.config.GetDataFlushInterval()))
 
 	kb.reportingEngine = NewKasperbrettReportingEngine()
-	kb.reportingEngine.Register(
+	err = kb.reportingEngine.Register(
 		NewConsoleReporter("[ConsoleReporter] "),
 		NewSocketIOReporter(kb.socketIOApi),
 		persistentDataStoreReporter,
+		dataSourceStatusReporter,
 	)
+	if err != nil {
+		return nil, err
+	}
 
 	kb.scheduler = NewKasperbrettScheduler(kb.reportingEngine)
 
+	rollupRetentions := map[string]time.Duration{}
+	for resolutionName, days := range kb.config.GetRollupRetentionDays() {
+		rollupRetentions[resolutionName] = time.Duration(days) * 24 * time.Hour
+	}
+
+	kb.retentionJanitor = NewRetentionJanitor(
+		boltDataStore,
+		time.Duration(kb.config.GetSampleRetentionDays())*24*time.Hour,
+		rollupRetentions,
+		time.Second*time.Duration(kb.config.GetRetentionCheckInterval()),
+	)
+
+	AllowCommandDataSources = kb.config.GetAllowCommandDataSources()
+	MaxResponseBodySize = kb.config.GetMaxResponseBodySize()
+	ProcPath = kb.config.GetProcPath()
+
+	// reschedule all data sources that have been created before the last shutdown
+	reconciliationReport, err := ReconcileDataSources(boltDataStore, kb.scheduler)
+	if err != nil {
+		return nil, err
+	}
+	reconciliationReport.Print()
+
 	/*urlScraperDs, err := NewUrlScraper(
 		"http://angularjs.de",
 		"body > div > div:nth-child(4) > div.col-sm-6.col-md-5 > ul:nth-child(6) > li:nth-child(5) > span",
@@ -277,14 +440,14 @@ func (kb *Kasperbrett) Prepare() (*Kasperbrett, error) {
 		return nil, err
 	}
 
-	kb.scheduler.Schedule(urlScraperDs.Id(), time.Millisecond*10000, func(reportingEngine ReportingEngine) {
-		RetrieveAndDistribute(urlScraperDs, reportingEngine, 3000*time.Millisecond)
+	kb.scheduler.Schedule(urlScraperDs.Id(), time.Millisecond*10000, func(ctx context.Context, reportingEngine ReportingEngine) time.Duration {
+		return RetrieveAndDistribute(ctx, urlScraperDs, reportingEngine, NewCircuitBreaker(urlScraperDs))
 	})
 	*/
 
 	portString := ":" + strconv.Itoa(kb.config.GetPort())
 
-	kb.restApi = NewKasperbrettRestApi(portString, "/realtime/", kb.socketIOApi, boltDataStore, persistentDataStoreReporter, kb.scheduler)
+	kb.restApi = NewKasperbrettRestApi(portString, "/realtime/", kb.socketIOApi, boltDataStore, persistentDataStoreReporter, dataSourceStatusReporter, kb.scheduler, kb.retentionJanitor)
 	bindErrChan := kb.restApi.ListenAndServe()
 	bindErr := <-bindErrChan
 	if bindErr != nil {
@@ -294,10 +457,65 @@ func (kb *Kasperbrett) Prepare() (*Kasperbrett, error) {
 	return kb, nil
 }
 
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+type ReconciliationReport struct {
+	Scheduled []string
+	Paused    []string
+	Failures  map[string]error
+}
+
+func (report *ReconciliationReport) Print() {
+	fmt.Printf("[Reconciliation] Rescheduled %d data source(s), %d paused, %d failure(s)\n", len(report.Scheduled), len(report.Paused), len(report.Failures))
+	for dataSourceId, err := range report.Failures {
+		fmt.Printf("[Reconciliation] Couldn't reschedule data source %s due to: %s\n", dataSourceId, err.Error())
+	}
+}
+
+// ReconcileDataSources schedules every data source that is stored in the given data store, except for paused ones.
+// A data source that can't be decoded, validated, or scheduled is recorded in the report and doesn't affect the others.
+// An error is only returned if the stored data sources can't be read at all.
+func ReconcileDataSources(dataStore DataStore, scheduler Scheduler) (*ReconciliationReport, error) {
+	dataSources, decodeErrors, err := dataStore.GetDataSourcesWithErrors()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconciliationReport{Scheduled: []string{}, Paused: []string{}, Failures: decodeErrors}
+	for _, dataSource := range dataSources {
+		if dataSource.Paused() {
+			report.Paused = append(report.Paused, dataSource.Id())
+			continue
+		}
+
+		err = ValidateDataSource(dataSource)
+		if err == nil {
+			err = ScheduleDataSource(scheduler, dataSource)
+		}
+
+		if err != nil {
+			report.Failures[dataSource.Id()] = err
+		} else {
+			report.Scheduled = append(report.Scheduled, dataSource.Id())
+		}
+	}
+
+	return report, nil
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
 func (kb *Kasperbrett) ShutDown() error {
 	_, schedulerShutDownErrChan := kb.scheduler.ShutDown()
 	schedulerShutDownErr := <-schedulerShutDownErrChan
 
+	// the janitor has to be stopped before the data store gets closed by the reporting engine
+	kb.retentionJanitor.ShutDown()
+
 	reportingEngineShutDownErr := kb.reportingEngine.ShutDown()
 
 	if schedulerShutDownErr != nil {
@@ -350,21 +568,336 @@ type DataSourceDto struct {
 	Name     string `json:"name" binding:"Required"`
 	Interval int64  `json:"interval"`
 	Timeout  int64  `json:"timeout"`
+	// Retention is the number of milliseconds samples are kept (0 means that the global retention applies)
+	Retention int64 `json:"retention"`
+	// Paused is only set for the corresponding GET requests (see pause and resume endpoints)
+	Paused bool `json:"paused"`
+	// Retry and CircuitBreaker are optional, by default failed retrievals aren't retried and the interval never changes
+	Retry          RetryPolicyDto    `json:"retry"`
+	CircuitBreaker CircuitBreakerDto `json:"circuitBreaker"`
+	// ValueType is one of float, int, bool, and text. ValueFormat describes how numbers are written (e.g. {"locale": "de"}).
+	ValueType   string      `json:"valueType"`
+	ValueFormat ValueFormat `json:"valueFormat"`
+	// Status is only set for the corresponding GET requests
+	Status *DataSourceStatusDto `json:"status,omitempty"`
 	// TypeSettings are variable depending on the data source
 	TypeSettings map[string]string `json:"typeSettings"`
-	// Labels and Series are only set if query param `include-data` is set to 1 (GET /datasources)
-	Labels []int64  `json:"labels"` // int64 because it represents the number of milliseconds since Unix Epoch
-	Series []string `json:"series"` // string because Kasperbrett considers sample values as strings
+	// Labels, Series and Numbers are only set if query param `include-data` is set to 1 (GET /datasources)
+	Labels  []int64    `json:"labels"`  // int64 because it represents the number of milliseconds since Unix Epoch
+	Series  []string   `json:"series"`  // string because Kasperbrett considers sample values as strings
+	Numbers []*float64 `json:"numbers"` // parsed according to the value type, null if the value isn't numeric
+}
+
+type RetryPolicyDto struct {
+	MaxAttempts    int     `json:"maxAttempts"`    // including the first attempt, 0 means 1
+	InitialBackoff int64   `json:"initialBackoff"` // milliseconds, 0 means 1000
+	MaxBackoff     int64   `json:"maxBackoff"`     // milliseconds, 0 means 30000
+	Jitter         float64 `json:"jitter"`         // fraction of the backoff (0 - 1) by which it's randomized
+}
+
+type CircuitBreakerDto struct {
+	Threshold int   `json:"threshold"` // consecutive failed retrievals that open the circuit breaker, 0 disables it
+	Interval  int64 `json:"interval"`  // milliseconds between retrievals while the circuit breaker is open, 0 means 10 x interval
+}
+
+// NewDataSourceFromDto validates the DTO and creates the corresponding data source.
+// A new id is generated if dataSourceId is empty. Errors are meant to be reported to the client (status 400).
+func NewDataSourceFromDto(ds DataSourceDto, dataSourceId string) (DataSource, error) {
+	// basic validation
+	dataSourceType, err := GetDataSourceType(ds.Type)
+	if err != nil {
+		return nil, err
+	}
+	if ds.TypeSettings == nil {
+		ds.TypeSettings = map[string]string{}
+	}
+	err = dataSourceType.ValidateTypeSettings(ds.TypeSettings)
+	if err != nil {
+		return nil, err
+	}
+	if ds.Retention < 0 {
+		return nil, errors.New("Please provide a valid retention (>= 0).")
+	}
+	if ds.Interval < 30000 {
+		return nil, errors.New("Please provide a bigger interval (>= 30000) to prevent abuse.")
+	}
+	if ds.Retry.MaxAttempts < 0 || ds.Retry.MaxAttempts > MaxRetrievalAttempts {
+		return nil, fmt.Errorf("Please provide a valid number of retrieval attempts (0 - %d).", MaxRetrievalAttempts)
+	}
+	if ds.Retry.InitialBackoff < 0 || ds.Retry.MaxBackoff < 0 {
+		return nil, errors.New("Please provide a valid backoff (>= 0).")
+	}
+	if ds.Retry.Jitter < 0 || ds.Retry.Jitter > 1 {
+		return nil, errors.New("Please provide a valid jitter (0 - 1).")
+	}
+	if ds.CircuitBreaker.Threshold < 0 {
+		return nil, errors.New("Please provide a valid circuit breaker threshold (>= 0).")
+	}
+	if ds.CircuitBreaker.Interval != 0 && ds.CircuitBreaker.Interval < ds.Interval {
+		return nil, errors.New("The interval of an open circuit breaker must not be shorter than the regular interval.")
+	}
+	err = ValidateValueType(ds.ValueType, ds.ValueFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	// default values
+	if ds.Interval == 0 {
+		ds.Interval = 60000 // 1 min
+	}
+	if ds.Timeout == 0 {
+		ds.Timeout = 10000 // 10 sec
+	}
+	if ds.Retry.MaxAttempts == 0 {
+		ds.Retry.MaxAttempts = 1
+	}
+	if ds.Retry.InitialBackoff == 0 {
+		ds.Retry.InitialBackoff = 1000 // 1 sec
+	}
+	if ds.Retry.MaxBackoff == 0 {
+		ds.Retry.MaxBackoff = 30000 // 30 sec
+	}
+	if ds.CircuitBreaker.Interval == 0 {
+		ds.CircuitBreaker.Interval = 10 * ds.Interval
+	}
+	if ds.Retry.MaxBackoff < ds.Retry.InitialBackoff {
+		return nil, errors.New("The maximum backoff must not be shorter than the initial backoff.")
+	}
+
+	// data source creation
+	var abstractDataSource AbstractDataSource
+	interval := time.Duration(ds.Interval) * time.Millisecond
+	timeout := time.Duration(ds.Timeout) * time.Millisecond
+	if len(dataSourceId) == 0 {
+		abstractDataSource, err = NewAbstractDataSource(ds.Name, interval, timeout)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		abstractDataSource = NewAbstractDataSourceWithId(dataSourceId, ds.Name, interval, timeout)
+	}
+	abstractDataSource.SetRetention(time.Duration(ds.Retention) * time.Millisecond)
+	retryPolicy := RetryPolicy{
+		MaxAttempts:    ds.Retry.MaxAttempts,
+		InitialBackoff: time.Duration(ds.Retry.InitialBackoff) * time.Millisecond,
+		MaxBackoff:     time.Duration(ds.Retry.MaxBackoff) * time.Millisecond,
+		Jitter:         ds.Retry.Jitter,
+	}
+	if maxDuration := retryPolicy.MaxDuration(timeout); maxDuration >= interval {
+		return nil, fmt.Errorf("All retrieval attempts might take up to %s, which has to be shorter than the interval (%s). Please reduce the attempts, the timeout, or the backoff.", maxDuration, interval)
+	}
+	abstractDataSource.SetRetryPolicy(retryPolicy)
+	abstractDataSource.SetCircuitBreaker(CircuitBreakerSettings{
+		Threshold: ds.CircuitBreaker.Threshold,
+		Interval:  time.Duration(ds.CircuitBreaker.Interval) * time.Millisecond,
+	})
+	abstractDataSource.SetValueType(ds.ValueType, ds.ValueFormat)
+
+	return dataSourceType.New(abstractDataSource, ds.TypeSettings)
 }
 
 type DataSourceResponse struct {
-	DataSourceId string `json:"dataSourceId"`
-	Timestamp    int64  `json:"timestamp"`
-	Value        string `json:"value"`
+	DataSourceId string   `json:"dataSourceId"`
+	Timestamp    int64    `json:"timestamp"`
+	Value        string   `json:"value"`
+	Number       *float64 `json:"number"` // null if the value isn't numeric
+}
+
+func NewDataSourceResponse(dataSourceId string, sample *Sample) *DataSourceResponse {
+	return &DataSourceResponse{
+		DataSourceId: dataSourceId,
+		Timestamp:    sample.Timestamp.UnixNano() / 1000000,
+		Value:        sample.Value,
+		Number:       sample.OptionalNumericValue(),
+	}
 }
 
+// DataSourceTestResponse is the response of test retrievals (query param `test-only` set to 1).
+// Error is only set if the retrieval failed, in which case the response status is 400.
 type DataSourceTestResponse struct {
-	Value string `json:"value"`
+	Value     string            `json:"value"`
+	ValueType string            `json:"valueType"`
+	Number    *float64          `json:"number"`  // null if the value isn't numeric
+	Latency   int64             `json:"latency"` // milliseconds
+	Meta      map[string]string `json:"meta,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Trace     RetrievalTraceDto `json:"trace"`
+}
+
+func NewDataSourceTestResponse(sample *Sample, trace *RetrievalTrace) *DataSourceTestResponse {
+	res := &DataSourceTestResponse{
+		Value:     sample.Value,
+		ValueType: sample.ValueType,
+		Latency:   sample.Latency.Nanoseconds() / 1000000,
+		Meta:      sample.Meta,
+		Trace:     trace.Dto(),
+	}
+
+	if sample.Err != nil {
+		res.Error = sample.Err.Error()
+	} else if number, ok := sample.NumericValue(); ok {
+		res.Number = &number
+	}
+
+	return res
+}
+
+// DataSourceStatusDto describes the health of a data source. All times are milliseconds (since Unix Epoch), 0 means never.
+type DataSourceStatusDto struct {
+	DataSourceId        string  `json:"dataSourceId"`
+	LastAttempt         int64   `json:"lastAttempt"`
+	LastSuccess         int64   `json:"lastSuccess"`
+	LastFailure         int64   `json:"lastFailure"`
+	LastError           string  `json:"lastError"`
+	ConsecutiveFailures int     `json:"consecutiveFailures"`
+	Attempts            int     `json:"attempts"`
+	Successes           int     `json:"successes"`
+	SuccessRatio        float64 `json:"successRatio"`   // 0 if there hasn't been an attempt yet
+	AverageLatency      int64   `json:"averageLatency"` // milliseconds
+	TotalRetries        int     `json:"totalRetries"`
+	BreakerOpen         bool    `json:"breakerOpen"`
+	BreakerOpenedAt     int64   `json:"breakerOpenedAt"`
+}
+
+func NewDataSourceStatusDto(status DataSourceStatus) *DataSourceStatusDto {
+	toMillis := func(t time.Time) int64 {
+		if t.IsZero() {
+			return 0
+		}
+		return t.UnixNano() / 1000000
+	}
+
+	return &DataSourceStatusDto{
+		DataSourceId:        status.DataSourceId,
+		LastAttempt:         toMillis(status.LastAttempt),
+		LastSuccess:         toMillis(status.LastSuccess),
+		LastFailure:         toMillis(status.LastFailure),
+		LastError:           status.LastError,
+		ConsecutiveFailures: status.ConsecutiveFailures,
+		Attempts:            status.Attempts,
+		Successes:           status.Successes,
+		SuccessRatio:        status.SuccessRatio(),
+		AverageLatency:      status.AverageLatency().Nanoseconds() / 1000000,
+		TotalRetries:        status.TotalRetries,
+		BreakerOpen:         status.BreakerOpen,
+		BreakerOpenedAt:     toMillis(status.BreakerOpenedAt),
+	}
+}
+
+type DataSourcePauseResponse struct {
+	DataSourceId string `json:"dataSourceId"`
+	Paused       bool   `json:"paused"`
+}
+
+type SamplesResponse struct {
+	DataSourceId string `json:"dataSourceId"`
+	From         int64  `json:"from"`
+	To           int64  `json:"to"`
+	// Timestamps, Values and Errors are parallel arrays (one entry per sample, sorted by time)
+	Timestamps []int64             `json:"timestamps"` // number of milliseconds since Unix Epoch
+	Values     []string            `json:"values"`     // empty if the sample retrieval failed
+	Errors     []string            `json:"errors"`     // empty if the sample retrieval succeeded
+	Numbers    []*float64          `json:"numbers"`    // null if the value isn't numeric
+	Meta       []map[string]string `json:"meta"`       // null unless the data source records details (e.g. HTTP probes)
+}
+
+func NewSamplesResponse(dataSourceId string, from time.Time, to time.Time, samples []*Sample) *SamplesResponse {
+	res := &SamplesResponse{
+		DataSourceId: dataSourceId,
+		From:         from.UnixNano() / 1000000,
+		To:           to.UnixNano() / 1000000,
+		Timestamps:   make([]int64, 0, len(samples)),
+		Values:       make([]string, 0, len(samples)),
+		Errors:       make([]string, 0, len(samples)),
+		Numbers:      make([]*float64, 0, len(samples)),
+		Meta:         make([]map[string]string, 0, len(samples)),
+	}
+
+	for _, sample := range samples {
+		errStr := ""
+		if sample.Err != nil {
+			errStr = sample.Err.Error()
+		}
+
+		res.Timestamps = append(res.Timestamps, sample.Timestamp.UnixNano()/1000000)
+		res.Values = append(res.Values, sample.Value)
+		res.Errors = append(res.Errors, errStr)
+		res.Numbers = append(res.Numbers, sample.OptionalNumericValue())
+		res.Meta = append(res.Meta, sample.Meta)
+	}
+
+	return res
+}
+
+type AggregatedSamplesResponse struct {
+	DataSourceId string `json:"dataSourceId"`
+	From         int64  `json:"from"`
+	To           int64  `json:"to"`
+	Step         int64  `json:"step"` // milliseconds
+	Aggregation  string `json:"aggregation"`
+	// Resolution is either 'raw' or the resolution of the rollups the series has been computed from (e.g. '1h')
+	Resolution string `json:"resolution"`
+	// Timestamps, Values and Counts are parallel arrays (one entry per non-empty bucket, sorted by time)
+	Timestamps []int64   `json:"timestamps"` // start of the bucket in milliseconds since Unix Epoch
+	Values     []float64 `json:"values"`
+	Counts     []int     `json:"counts"` // number of samples that have been aggregated
+	// samples that couldn't be taken into account
+	SkippedErrors     int `json:"skippedErrors"`
+	SkippedNonNumeric int `json:"skippedNonNumeric"`
+}
+
+func NewAggregatedSamplesResponse(dataSourceId string, from time.Time, to time.Time, series *AggregatedSeries) *AggregatedSamplesResponse {
+	res := &AggregatedSamplesResponse{
+		DataSourceId:      dataSourceId,
+		From:              from.UnixNano() / 1000000,
+		To:                to.UnixNano() / 1000000,
+		Step:              series.Step.Nanoseconds() / 1000000,
+		Aggregation:       series.Aggregation,
+		Resolution:        series.Resolution,
+		Timestamps:        make([]int64, 0, len(series.Points)),
+		Values:            make([]float64, 0, len(series.Points)),
+		Counts:            make([]int, 0, len(series.Points)),
+		SkippedErrors:     series.SkippedErrors,
+		SkippedNonNumeric: series.SkippedNonNumeric,
+	}
+
+	for _, point := range series.Points {
+		res.Timestamps = append(res.Timestamps, point.Timestamp.UnixNano()/1000000)
+		res.Values = append(res.Values, point.Value)
+		res.Counts = append(res.Counts, point.Count)
+	}
+
+	return res
+}
+
+type RetentionStatsResponse struct {
+	GlobalRetention      int64  `json:"globalRetention"` // milliseconds, 0 means forever
+	CheckInterval        int64  `json:"checkInterval"`   // milliseconds
+	Runs                 int    `json:"runs"`
+	LastRun              int64  `json:"lastRun"`         // milliseconds since Unix Epoch, 0 if there hasn't been a run yet
+	LastRunDuration      int64  `json:"lastRunDuration"` // milliseconds
+	LastRunPrunedSamples int    `json:"lastRunPrunedSamples"`
+	LastRunPrunedRollups int    `json:"lastRunPrunedRollups"`
+	LastRunPrunedBytes   int64  `json:"lastRunPrunedBytes"` // keys and values of the pruned records, the data file doesn't shrink
+	LastError            string `json:"lastError"`
+	TotalPrunedSamples   int    `json:"totalPrunedSamples"`
+	TotalPrunedRollups   int    `json:"totalPrunedRollups"`
+	TotalPrunedBytes     int64  `json:"totalPrunedBytes"`
+	// RollupRetentions maps rollup resolutions to their retention in milliseconds (0 means forever)
+	RollupRetentions map[string]int64 `json:"rollupRetentions"`
+}
+
+type SampleBufferStatsResponse struct {
+	Capacity       int    `json:"capacity"`
+	OverflowPolicy string `json:"overflowPolicy"`
+	Buffered       int    `json:"buffered"`
+	Spilled        int    `json:"spilled"` // samples that are waiting on disk to be flushed
+	TotalDropped   int    `json:"totalDropped"`
+	TotalRetried   int    `json:"totalRetried"`
+	TotalSpilled   int    `json:"totalSpilled"`
+	FailedFlushes  int    `json:"failedFlushes"`
+	LastFlush      int64  `json:"lastFlush"` // milliseconds since Unix Epoch, 0 if there hasn't been a successful flush yet
+	LastFlushError string `json:"lastFlushError"`
 }
 
 type ErrorResponse struct {
@@ -376,7 +909,7 @@ type ErrorResponse struct {
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
 // TODO: PersistentDataStoreReporter might become an interface.
-func NewKasperbrettRestApi(bindAddr string, socketIOPath string, socketIOApi SocketIOApi, dataStore DataStore, persistentDataStoreReporter *PersistentDataStoreReporter, scheduler Scheduler) *KasperbrettRestApi {
+func NewKasperbrettRestApi(bindAddr string, socketIOPath string, socketIOApi SocketIOApi, dataStore DataStore, persistentDataStoreReporter *PersistentDataStoreReporter, dataSourceStatusReporter *DataSourceStatusReporter, scheduler Scheduler, retentionJanitor *RetentionJanitor) *KasperbrettRestApi {
 	mux := http.NewServeMux()
 
 	m := macaron.Classic()
@@ -384,193 +917,438 @@ func NewKasperbrettRestApi(bindAddr string, socketIOPath string, socketIOApi Soc
 
 	m.Group("/api", func() {
 		m.Post("/datasources", binding.Bind(DataSourceDto{}), func(ds DataSourceDto, ctx *macaron.Context) {
-			// basic validation
-			if ds.Type != DsUrlScraper {
-				ctx.JSON(400, &ErrorResponse{Error: "Unsupported data source type: " + ds.Type})
-				return
-			}
-			if len(ds.TypeSettings["url"]) == 0 {
-				ctx.JSON(400, &ErrorResponse{Error: "Please provide a valid URL."})
-				return
-			}
-			if len(ds.TypeSettings["cssPath"]) == 0 {
-				ctx.JSON(400, &ErrorResponse{Error: "Please provide a valid CSS path."})
-				return
-			}
-			if ds.Interval < 30000 {
-				ctx.JSON(400, &ErrorResponse{Error: "Please provide a bigger interval (>= 30000) to prevent abuse."})
-				return
-			}
-
-			// default values
-			if ds.Interval == 0 {
-				ds.Interval = 60000 // 1 min
-			}
-			if ds.Timeout == 0 {
-				ds.Timeout = 10000 // 10 sec
-			}
-
-			// data source creation
-			abstractDataSource, err := NewAbstractDataSource(
-				ds.Name, time.Duration(ds.Interval)*time.Millisecond, time.Duration(ds.Timeout)*time.Millisecond,
-			)
+			dataSource, err := NewDataSourceFromDto(ds, "")
 			if err != nil {
 				ctx.JSON(400, &ErrorResponse{Error: err.Error()})
 				return
 			}
 
-			urlScraperDs := NewUrlScraper(
-				abstractDataSource, ds.TypeSettings["url"], ds.TypeSettings["cssPath"], ds.TypeSettings["transformationScript"],
-			)
-
 			// retrieval test
-			sample := Retrieve(urlScraperDs, time.Duration(ds.Timeout)*time.Millisecond)
+			retrievalCtx, trace := WithRetrievalTrace(ctx.Req.Context())
+			sample := Retrieve(retrievalCtx, dataSource, dataSource.Timeout())
+			retrievalTestOnly := ctx.Query("test-only")
 			if sample.Err != nil {
+				if retrievalTestOnly == "1" {
+					ctx.JSON(400, NewDataSourceTestResponse(sample, trace))
+					return
+				}
 				ctx.JSON(400, &ErrorResponse{Error: sample.Err.Error()})
 				return
 			}
 
-			retrievalTestOnly := ctx.Query("test-only")
 			if retrievalTestOnly == "1" {
-				ctx.JSON(200, &DataSourceTestResponse{Value: sample.Value})
+				ctx.JSON(200, NewDataSourceTestResponse(sample, trace))
 				return
 			}
 
 			// persist data source
-			err = dataStore.PersistDataSource(urlScraperDs)
+			err = dataStore.PersistDataSource(dataSource)
 			if err != nil {
 				ctx.JSON(400, &ErrorResponse{Error: err.Error()})
 				return
 			}
 
 			// schedule data source job
-			scheduler.Schedule(urlScraperDs.Id(), time.Millisecond*time.Duration(ds.Interval), func(reportingEngine ReportingEngine) {
-				RetrieveAndDistribute(urlScraperDs, reportingEngine, time.Duration(ds.Timeout)*time.Millisecond)
-			})
+			err = ScheduleDataSource(scheduler, dataSource)
+			if err != nil {
+				ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+				return
+			}
 
-			ctx.JSON(200, &DataSourceResponse{DataSourceId: urlScraperDs.Id(), Timestamp: sample.Timestamp.UnixNano() / 1000000, Value: sample.Value})
+			ctx.JSON(200, NewDataSourceResponse(dataSource.Id(), sample))
 		})
 
-		m.Get("/datasources/:dataSourceId/samples/:timeframe", func(ctx *macaron.Context) string {
-			dataSourceId := ctx.Params(":dataSourceId")
-			timeframeStr := ctx.Params(":timeframe")
-
-			if len(dataSourceId) == 0 {
-				return "Please provide a valid dataSourceId."
+		// getDataSource responds with 404 (or 500) and returns nil if the data source can't be read.
+		getDataSource := func(ctx *macaron.Context, dataSourceId string) DataSource {
+			dataSource, err := dataStore.GetDataSource(dataSourceId)
+			if err == ErrDataSourceNotFound {
+				ctx.JSON(404, &ErrorResponse{Error: "There is no data source with id '" + dataSourceId + "'."})
+				return nil
+			} else if err != nil {
+				ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+				return nil
 			}
 
-			if len(timeframeStr) == 0 {
-				return "Please provide a valid timeframe."
-			}
+			return dataSource
+		}
 
-			if !strings.HasPrefix(timeframeStr, "now-") {
-				return "A valid timeframe has to start with 'now-' followed by a duration. (e.g. 'now-1h30m')"
+		// replaces the definition of a data source (except for its id and type) and reschedules it unless it's paused
+		m.Put("/datasources/:dataSourceId", binding.Bind(DataSourceDto{}), func(ds DataSourceDto, ctx *macaron.Context) {
+			existingDataSource := getDataSource(ctx, ctx.Params(":dataSourceId"))
+			if existingDataSource == nil {
+				return
 			}
 
-			timeframeParts := strings.Split(timeframeStr, "-")
-			if len(timeframeParts) != 2 {
-				return "A valid timeframe has to start with 'now-' followed by a duration. (e.g. 'now-2h30m')"
+			if ds.Type != existingDataSource.Type() {
+				ctx.JSON(400, &ErrorResponse{Error: "The type of a data source can't be changed."})
+				return
 			}
 
-			duration, err := time.ParseDuration(timeframeParts[1])
+			dataSource, err := NewDataSourceFromDto(ds, existingDataSource.Id())
 			if err != nil {
-				return "Invalid duration: " + timeframeParts[1]
+				ctx.JSON(400, &ErrorResponse{Error: err.Error()})
+				return
 			}
+			dataSource.SetPaused(existingDataSource.Paused())
 
-			to := time.Now()
-			from := to.Add(-1 * duration)
+			// retrieval test
+			retrievalCtx, trace := WithRetrievalTrace(ctx.Req.Context())
+			sample := Retrieve(retrievalCtx, dataSource, dataSource.Timeout())
+			retrievalTestOnly := ctx.Query("test-only")
+			if sample.Err != nil {
+				if retrievalTestOnly == "1" {
+					ctx.JSON(400, NewDataSourceTestResponse(sample, trace))
+					return
+				}
+				ctx.JSON(400, &ErrorResponse{Error: sample.Err.Error()})
+				return
+			}
 
-			samples, err := dataStore.GetSamples(dataSourceId, from, to)
-			if err != nil {
-				return "An error occurred during sample retrieval: " + err.Error()
-			} else {
-				bufferedSamples := persistentDataStoreReporter.GetSamples(dataSourceId, from, to)
-				samples := append(samples, bufferedSamples...)
-				return fmt.Sprintf("I've found %d matching samples.", len(samples))
+			if retrievalTestOnly == "1" {
+				ctx.JSON(200, NewDataSourceTestResponse(sample, trace))
+				return
 			}
-		})
 
-		m.Get("/datasources", func(ctx *macaron.Context) {
-			dataSources, err := dataStore.GetDataSources()
+			err = dataStore.PersistDataSource(dataSource)
 			if err != nil {
 				ctx.JSON(500, &ErrorResponse{Error: err.Error()})
 				return
 			}
 
-			includeLatestSamples := ctx.Query("include-latest-samples")
-
-			var dataSourceDto DataSourceDto
-			var urlScraperDs *UrlScraper
-			dataSourceList := []DataSourceDto{}
-			for _, dataSource := range dataSources {
-				// TODO: differentiate between multiple data sources in the future
-				urlScraperDs = dataSource.(*UrlScraper)
-
-				dataSourceDto = DataSourceDto{
-					Type:     DsUrlScraper,
-					Id:       dataSource.Id(),
-					Name:     dataSource.Name(),
-					Interval: dataSource.Interval().Nanoseconds() / 1000000,
-					Timeout:  dataSource.Timeout().Nanoseconds() / 1000000,
-					TypeSettings: map[string]string{
-						"url":                  urlScraperDs.url,
-						"cssPath":              urlScraperDs.cssPath,
-						"transformationScript": urlScraperDs.transformationScript,
-					},
+			// the scheduler replaces the job of the previous definition
+			if !dataSource.Paused() {
+				err = ScheduleDataSource(scheduler, dataSource)
+				if err != nil {
+					ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+					return
 				}
+			}
 
-				if includeLatestSamples == "1" {
-					desiredNumOfSamples := 10
-					samples := persistentDataStoreReporter.GetLatestSamples(dataSource.Id(), desiredNumOfSamples)
-					if len(samples) < desiredNumOfSamples {
-						storedSamples, err := dataStore.GetLatestSamples(dataSource.Id(), desiredNumOfSamples-len(samples))
-						if err != nil {
-							ctx.JSON(500, &ErrorResponse{Error: err.Error()})
-							return
-						}
+			ctx.JSON(200, NewDataSourceResponse(dataSource.Id(), sample))
+		})
 
-						samples = append(storedSamples, samples...)
-					}
+		// e.g. DELETE /datasources/ds-123?purge-samples=1 (samples and rollups are kept by default)
+		m.Delete("/datasources/:dataSourceId", func(ctx *macaron.Context) {
+			dataSource := getDataSource(ctx, ctx.Params(":dataSourceId"))
+			if dataSource == nil {
+				return
+			}
 
-					labels := []int64{}
-					series := []string{}
-					for _, sample := range samples {
-						labels = append(labels, sample.Timestamp.UnixNano()/1000000)
-						series = append(series, sample.Value)
-					}
-					dataSourceDto.Labels = labels
-					dataSourceDto.Series = series
+			if !dataSource.Paused() {
+				err := CancelDataSource(scheduler, dataSource)
+				if err != nil {
+					ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+					return
 				}
-
-				dataSourceList = append(dataSourceList, dataSourceDto)
 			}
 
-			ctx.JSON(200, &dataSourceList)
-		})
+			// the status has to be forgotten first, otherwise the status reporter would persist it again
+			dataSourceStatusReporter.Forget(dataSource.Id())
 
-		// just a trigger to test dataStore.GetSamples()
-		m.Get("/trigger-get-samples", func() string {
-			// 2015-01-18T14:36:51.915246044Z
-			from, fromErr := time.Parse(time.RFC3339Nano, "2015-01-18T14:36:51Z")
-			if fromErr != nil {
-				fmt.Println("An error occurred during parsing of from time:", fromErr)
+			err := dataStore.DeleteDataSource(dataSource.Id())
+			if err != nil {
+				ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+				return
 			}
 
-			to, toErr := time.Parse(time.RFC3339Nano, "2015-01-18T14:37:08Z")
-			if toErr != nil {
-				fmt.Println("An error occurred during parsing of to time:", toErr)
-			}
+			if ctx.Query("purge-samples") == "1" {
+				err = persistentDataStoreReporter.DiscardSamples(dataSource.Id())
+				if err != nil {
+					ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+					return
+				}
 
-			samples, err := dataStore.GetSamples("ds-fcb12ecc-b067-46d8-44be-48f3d70ea458", from, to)
-			if err != nil {
-				fmt.Println("An error occurred (GET /datasources) ->", err)
-			} else {
-				for _, sample := range samples {
-					fmt.Println("      [AFTER Sample]      ", sample)
+				err = dataStore.PurgeSamples(dataSource.Id())
+				if err != nil {
+					ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+					return
 				}
 			}
 
-			return "Done!"
+			ctx.Status(204)
+		})
+
+		// setPaused cancels or schedules the job of the data source and persists the paused flag afterwards,
+		// so that a failing scheduler doesn't leave a flag behind that contradicts the actual state
+		setPaused := func(ctx *macaron.Context, paused bool) {
+			dataSource := getDataSource(ctx, ctx.Params(":dataSourceId"))
+			if dataSource == nil {
+				return
+			}
+
+			if dataSource.Paused() != paused {
+				var err error
+				if paused {
+					err = CancelDataSource(scheduler, dataSource)
+				} else {
+					err = ValidateDataSource(dataSource)
+					if err != nil {
+						ctx.JSON(400, &ErrorResponse{Error: err.Error()})
+						return
+					}
+					err = ScheduleDataSource(scheduler, dataSource)
+				}
+				if err != nil {
+					ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+					return
+				}
+
+				dataSource.SetPaused(paused)
+				err = dataStore.PersistDataSource(dataSource)
+				if err != nil {
+					// undo the scheduler change, the persisted flag is still the previous one
+					if paused {
+						ScheduleDataSource(scheduler, dataSource)
+					} else {
+						CancelDataSource(scheduler, dataSource)
+					}
+					ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+					return
+				}
+			}
+
+			ctx.JSON(200, &DataSourcePauseResponse{DataSourceId: dataSource.Id(), Paused: dataSource.Paused()})
+		}
+
+		m.Get("/datasources/:dataSourceId/status", func(ctx *macaron.Context) {
+			dataSource := getDataSource(ctx, ctx.Params(":dataSourceId"))
+			if dataSource == nil {
+				return
+			}
+
+			ctx.JSON(200, NewDataSourceStatusDto(dataSourceStatusReporter.GetStatus(dataSource.Id())))
+		})
+
+		m.Post("/datasources/:dataSourceId/pause", func(ctx *macaron.Context) {
+			setPaused(ctx, true)
+		})
+
+		m.Post("/datasources/:dataSourceId/resume", func(ctx *macaron.Context) {
+			setPaused(ctx, false)
+		})
+
+		// serveSamples responds with all stored and buffered samples of a data source within [from, to].
+		// If the query params `step` (and optionally `agg`) are set, the samples get aggregated into buckets of that size.
+		serveSamples := func(ctx *macaron.Context, dataSourceId string, from time.Time, to time.Time) {
+			step, aggregation, err := ParseAggregationQuery(ctx.Query("step"), ctx.Query("agg"), from, to)
+			if err != nil {
+				ctx.JSON(400, &ErrorResponse{Error: err.Error()})
+				return
+			}
+
+			_, err = dataStore.GetDataSource(dataSourceId)
+			if err == ErrDataSourceNotFound {
+				ctx.JSON(404, &ErrorResponse{Error: "There is no data source with id '" + dataSourceId + "'."})
+				return
+			} else if err != nil {
+				ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+				return
+			}
+
+			bufferedSamples := persistentDataStoreReporter.GetSamples(dataSourceId, from, to)
+
+			// rollups are way cheaper to read than raw samples (e.g. 1440 instead of 2880 values per day for 30s samples)
+			if step > 0 {
+				resolution, ok := SelectRollupResolution(step, aggregation, from, retentionJanitor.RollupRetention, time.Now())
+				if ok {
+					series, err := AggregateStoredRollups(dataStore, dataSourceId, from, to, bufferedSamples, resolution, step, aggregation)
+					if err != nil {
+						ctx.JSON(500, &ErrorResponse{Error: "An error occurred during rollup retrieval: " + err.Error()})
+						return
+					}
+
+					ctx.JSON(200, NewAggregatedSamplesResponse(dataSourceId, from, to, series))
+					return
+				}
+			}
+
+			samples, err := dataStore.GetSamples(dataSourceId, from, to)
+			if err != nil {
+				ctx.JSON(500, &ErrorResponse{Error: "An error occurred during sample retrieval: " + err.Error()})
+				return
+			}
+
+			samples = MergeSamples(samples, bufferedSamples)
+
+			if step > 0 {
+				ctx.JSON(200, NewAggregatedSamplesResponse(dataSourceId, from, to, AggregateSamples(samples, step, aggregation)))
+			} else {
+				ctx.JSON(200, NewSamplesResponse(dataSourceId, from, to, samples))
+			}
+		}
+
+		// e.g. /datasources/ds-123/samples?from=now-1d/d&to=now-1d/d (yesterday)
+		m.Get("/datasources/:dataSourceId/samples", func(ctx *macaron.Context) {
+			from, to, err := ParseTimeRange(ctx.Query("from"), ctx.Query("to"), time.Now())
+			if err != nil {
+				ctx.JSON(400, &ErrorResponse{Error: err.Error()})
+				return
+			}
+
+			serveSamples(ctx, ctx.Params(":dataSourceId"), from, to)
+		})
+
+		// e.g. /datasources/ds-123/samples/now-1h30m
+		m.Get("/datasources/:dataSourceId/samples/:timeframe", func(ctx *macaron.Context) {
+			from, to, err := ParseTimeframe(ctx.Params(":timeframe"), time.Now())
+			if err != nil {
+				ctx.JSON(400, &ErrorResponse{Error: err.Error()})
+				return
+			}
+
+			serveSamples(ctx, ctx.Params(":dataSourceId"), from, to)
+		})
+
+		m.Get("/datasources", func(ctx *macaron.Context) {
+			dataSources, err := dataStore.GetDataSources()
+			if err != nil {
+				ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+				return
+			}
+
+			includeLatestSamples := ctx.Query("include-latest-samples")
+
+			// instead of the latest samples it's also possible to include the samples of a specific time range
+			includeSampleRange := len(ctx.Query("from")) > 0 || len(ctx.Query("to")) > 0
+			from, to, err := ParseTimeRange(ctx.Query("from"), ctx.Query("to"), time.Now())
+			if err != nil {
+				ctx.JSON(400, &ErrorResponse{Error: err.Error()})
+				return
+			}
+
+			statuses := dataSourceStatusReporter.GetStatuses()
+
+			var dataSourceDto DataSourceDto
+			dataSourceList := []DataSourceDto{}
+			for _, dataSource := range dataSources {
+				dataSourceType, err := GetDataSourceType(dataSource.Type())
+				if err != nil {
+					fmt.Printf("[GET /datasources] Skipping data source %s due to: %s\n", dataSource.Id(), err.Error())
+					continue
+				}
+
+				dataSourceDto = DataSourceDto{
+					Type:         dataSource.Type(),
+					Id:           dataSource.Id(),
+					Name:         dataSource.Name(),
+					Interval:     dataSource.Interval().Nanoseconds() / 1000000,
+					Timeout:      dataSource.Timeout().Nanoseconds() / 1000000,
+					Retention:    dataSource.Retention().Nanoseconds() / 1000000,
+					Paused:       dataSource.Paused(),
+					TypeSettings: dataSourceType.ExportTypeSettings(dataSource),
+					Retry: RetryPolicyDto{
+						MaxAttempts:    dataSource.RetryPolicy().MaxAttempts,
+						InitialBackoff: dataSource.RetryPolicy().InitialBackoff.Nanoseconds() / 1000000,
+						MaxBackoff:     dataSource.RetryPolicy().MaxBackoff.Nanoseconds() / 1000000,
+						Jitter:         dataSource.RetryPolicy().Jitter,
+					},
+					CircuitBreaker: CircuitBreakerDto{
+						Threshold: dataSource.CircuitBreaker().Threshold,
+						Interval:  dataSource.CircuitBreaker().Interval.Nanoseconds() / 1000000,
+					},
+					ValueType:   dataSource.ValueType(),
+					ValueFormat: dataSource.ValueFormat(),
+				}
+
+				status, ok := statuses[dataSource.Id()]
+				if !ok {
+					status = DataSourceStatus{DataSourceId: dataSource.Id()}
+				}
+				dataSourceDto.Status = NewDataSourceStatusDto(status)
+
+				if includeLatestSamples == "1" || includeSampleRange {
+					var samples []*Sample
+					if includeSampleRange {
+						storedSamples, err := dataStore.GetSamples(dataSource.Id(), from, to)
+						if err != nil {
+							ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+							return
+						}
+
+						samples = MergeSamples(storedSamples, persistentDataStoreReporter.GetSamples(dataSource.Id(), from, to))
+					} else {
+						desiredNumOfSamples := 10
+						samples = persistentDataStoreReporter.GetLatestSamples(dataSource.Id(), desiredNumOfSamples)
+						if len(samples) < desiredNumOfSamples {
+							storedSamples, err := dataStore.GetLatestSamples(dataSource.Id(), desiredNumOfSamples-len(samples))
+							if err != nil {
+								ctx.JSON(500, &ErrorResponse{Error: err.Error()})
+								return
+							}
+
+							samples = append(storedSamples, samples...)
+						}
+					}
+
+					labels := []int64{}
+					series := []string{}
+					numbers := []*float64{}
+					for _, sample := range samples {
+						labels = append(labels, sample.Timestamp.UnixNano()/1000000)
+						series = append(series, sample.Value)
+						numbers = append(numbers, sample.OptionalNumericValue())
+					}
+					dataSourceDto.Labels = labels
+					dataSourceDto.Series = series
+					dataSourceDto.Numbers = numbers
+				}
+
+				dataSourceList = append(dataSourceList, dataSourceDto)
+			}
+
+			ctx.JSON(200, &dataSourceList)
+		})
+
+		m.Get("/retention", func(ctx *macaron.Context) {
+			stats := retentionJanitor.Stats()
+
+			res := &RetentionStatsResponse{
+				GlobalRetention:      stats.GlobalRetention.Nanoseconds() / 1000000,
+				CheckInterval:        stats.CheckInterval.Nanoseconds() / 1000000,
+				Runs:                 stats.Runs,
+				LastRunDuration:      stats.LastRunDuration.Nanoseconds() / 1000000,
+				LastRunPrunedSamples: stats.LastRunPrunedSamples,
+				LastRunPrunedRollups: stats.LastRunPrunedRollups,
+				LastRunPrunedBytes:   stats.LastRunPrunedBytes,
+				TotalPrunedSamples:   stats.TotalPrunedSamples,
+				TotalPrunedRollups:   stats.TotalPrunedRollups,
+				TotalPrunedBytes:     stats.TotalPrunedBytes,
+				RollupRetentions:     map[string]int64{},
+			}
+			for resolutionName, retention := range stats.RollupRetentions {
+				res.RollupRetentions[resolutionName] = retention.Nanoseconds() / 1000000
+			}
+			if !stats.LastRun.IsZero() {
+				res.LastRun = stats.LastRun.UnixNano() / 1000000
+			}
+			if stats.LastError != nil {
+				res.LastError = stats.LastError.Error()
+			}
+
+			ctx.JSON(200, res)
+		})
+
+		m.Get("/buffer", func(ctx *macaron.Context) {
+			stats := persistentDataStoreReporter.Stats()
+
+			res := &SampleBufferStatsResponse{
+				Capacity:       stats.Capacity,
+				OverflowPolicy: stats.OverflowPolicy,
+				Buffered:       stats.Buffered,
+				Spilled:        stats.Spilled,
+				TotalDropped:   stats.TotalDropped,
+				TotalRetried:   stats.TotalRetried,
+				TotalSpilled:   stats.TotalSpilled,
+				FailedFlushes:  stats.FailedFlushes,
+			}
+			if !stats.LastFlush.IsZero() {
+				res.LastFlush = stats.LastFlush.UnixNano() / 1000000
+			}
+			if stats.LastFlushError != nil {
+				res.LastFlushError = stats.LastFlushError.Error()
+			}
+
+			ctx.JSON(200, res)
 		})
 	})
 
@@ -588,6 +1366,302 @@ func NewKasperbrettRestApi(bindAddr string, socketIOPath string, socketIOApi Soc
 	return &KasperbrettRestApi{macaron: m, httpServer: httpServer, dataStore: dataStore}
 }
 
+// ParseTimeframe converts a timeframe like 'now-1h30m' (or any other relative expression supported by
+// ParseTimeExpression(), e.g. 'now-7d') into the time range [timeframe, now].
+func ParseTimeframe(timeframe string, now time.Time) (time.Time, time.Time, error) {
+	if len(timeframe) == 0 {
+		return time.Time{}, time.Time{}, errors.New("Please provide a valid timeframe.")
+	}
+
+	if !strings.HasPrefix(timeframe, "now-") {
+		return time.Time{}, time.Time{}, errors.New("A valid timeframe has to start with 'now-' followed by a duration. (e.g. 'now-1h30m')")
+	}
+
+	return ParseTimeRange(timeframe, "now", now)
+}
+
+const (
+	MaxAggregationBuckets = 10000
+	MinAggregationStep    = time.Second
+)
+
+// ParseAggregationQuery validates the `step` and `agg` query params of sample queries.
+// A zero step means that the samples shouldn't be aggregated at all.
+func ParseAggregationQuery(stepStr string, aggregation string, from time.Time, to time.Time) (time.Duration, string, error) {
+	if len(stepStr) == 0 {
+		if len(aggregation) > 0 {
+			return 0, "", errors.New("Please provide a `step` (e.g. '5m') in order to aggregate samples.")
+		}
+		return 0, "", nil
+	}
+
+	step, err := ParseStep(stepStr)
+	if err != nil {
+		return 0, "", err
+	}
+	if step < MinAggregationStep {
+		return 0, "", fmt.Errorf("The step has to be at least %s.", MinAggregationStep)
+	}
+	if to.Sub(from)/step > MaxAggregationBuckets {
+		return 0, "", fmt.Errorf("The step is too small for the requested time range (more than %d buckets).", MaxAggregationBuckets)
+	}
+
+	if len(aggregation) == 0 {
+		aggregation = AggAvg
+	}
+	if _, ok := aggregationFunctions[aggregation]; !ok {
+		return 0, "", fmt.Errorf("Unknown aggregation '%s'. Valid aggregations are avg, min, max, sum, count, last and p95.", aggregation)
+	}
+
+	return step, aggregation, nil
+}
+
+// ParseStep accepts Go durations (e.g. '5m', '1h30m') as well as days and weeks (e.g. '1d', '2w').
+func ParseStep(stepStr string) (time.Duration, error) {
+	step, err := time.ParseDuration(stepStr)
+	if err == nil {
+		return step, nil
+	}
+
+	for unit, unitDuration := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(stepStr, unit) {
+			amount, err := strconv.Atoi(strings.TrimSuffix(stepStr, unit))
+			if err == nil && amount > 0 {
+				return time.Duration(amount) * unitDuration, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("Invalid step '%s'. Valid steps look like '30s', '5m', '1h' or '1d'.", stepStr)
+}
+
+const (
+	DefaultTimeRangeFrom = "now-1h"
+	DefaultTimeRangeTo   = "now"
+)
+
+// ParseTimeRange parses the `from` and `to` query params of sample queries.
+// Missing values default to the last hour. Rounding (e.g. 'now-1d/d') snaps `from` to the start and `to` to the end
+// of the given unit, so from=now-1d/d&to=now-1d/d covers yesterday as a whole.
+func ParseTimeRange(fromExpr string, toExpr string, now time.Time) (time.Time, time.Time, error) {
+	if len(fromExpr) == 0 {
+		fromExpr = DefaultTimeRangeFrom
+	}
+	if len(toExpr) == 0 {
+		toExpr = DefaultTimeRangeTo
+	}
+
+	from, err := ParseTimeExpression(fromExpr, now, false)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Invalid 'from' value: %s", err.Error())
+	}
+
+	to, err := ParseTimeExpression(toExpr, now, true)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Invalid 'to' value: %s", err.Error())
+	}
+
+	if from.After(to) {
+		return time.Time{}, time.Time{}, errors.New("The 'from' value has to be before the 'to' value.")
+	}
+
+	return from, to, nil
+}
+
+// ParseTimeExpression understands the following kinds of time expressions:
+//
+//	2015-01-18T14:36:51Z        RFC3339 (with optional fractional seconds)
+//	2015-01-18                  a day (in the local time zone of `now`)
+//	1421591811000               number of milliseconds since Unix Epoch
+//	now-7d, now-2w, now-1h30m   `now` plus/minus offsets with the units ms, s, m, h, d, w, M (months) and y
+//	now-1d/d, now/w             the same, rounded to the given unit (to its start or, if roundUp is set, to its end)
+//	now-1.5h, now-500us         offsets that are understood by time.ParseDuration()
+func ParseTimeExpression(expr string, now time.Time, roundUp bool) (time.Time, error) {
+	expr = strings.TrimSpace(expr)
+
+	if strings.HasPrefix(expr, "now") {
+		return parseRelativeTimeExpression(expr, now, roundUp)
+	}
+
+	if millis, err := strconv.ParseInt(expr, 10, 64); err == nil {
+		return time.Unix(0, millis*int64(time.Millisecond)), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, expr); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", expr, now.Location()); err == nil {
+		if roundUp {
+			return t.AddDate(0, 0, 1).Add(-1), nil
+		}
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("'%s' is neither an RFC3339 time, nor a Unix timestamp in milliseconds, nor a relative time like 'now-7d'.", expr)
+}
+
+func parseRelativeTimeExpression(expr string, now time.Time, roundUp bool) (time.Time, error) {
+	rest := strings.TrimPrefix(expr, "now")
+
+	roundingUnit := ""
+	slashIdx := strings.Index(rest, "/")
+	if slashIdx >= 0 {
+		roundingUnit = rest[slashIdx+1:]
+		rest = rest[:slashIdx]
+	}
+
+	if strings.HasSuffix(rest, "-") || strings.HasSuffix(rest, "+") {
+		return time.Time{}, fmt.Errorf("'%s' is missing an offset after '%c'.", expr, rest[len(rest)-1])
+	}
+
+	t := now
+	sign := 0
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '-':
+			sign = -1
+			rest = rest[1:]
+			continue
+		case '+':
+			sign = 1
+			rest = rest[1:]
+			continue
+		}
+
+		if sign == 0 {
+			return time.Time{}, fmt.Errorf("'%s' is missing a '+' or '-' after 'now'.", expr)
+		}
+
+		offsetEnd := strings.IndexAny(rest, "+-")
+		if offsetEnd < 0 {
+			offsetEnd = len(rest)
+		}
+
+		var err error
+		t, err = addTimeOffset(t, sign, rest[:offsetEnd], expr)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		rest = rest[offsetEnd:]
+	}
+
+	if slashIdx >= 0 {
+		return roundTime(t, roundingUnit, roundUp)
+	}
+
+	return t, nil
+}
+
+// addTimeOffset adds an offset like '7d' or '1h30m' to t. Offsets that don't consist of whole numbers of the units below
+// (e.g. '1.5h' or '500us') are parsed by time.ParseDuration(), which used to be the only supported format.
+func addTimeOffset(t time.Time, sign int, offset string, expr string) (time.Time, error) {
+	offsetTime := t
+	rest := offset
+	for len(rest) > 0 {
+		digitsEnd := 0
+		for digitsEnd < len(rest) && rest[digitsEnd] >= '0' && rest[digitsEnd] <= '9' {
+			digitsEnd++
+		}
+		unitEnd := digitsEnd
+		for unitEnd < len(rest) && (rest[unitEnd] < '0' || rest[unitEnd] > '9') {
+			unitEnd++
+		}
+
+		var err error
+		if digitsEnd == 0 || unitEnd == digitsEnd {
+			err = fmt.Errorf("'%s' contains an invalid offset. Offsets look like '7d' or '1h30m'.", expr)
+		} else {
+			var amount int
+			amount, err = strconv.Atoi(rest[:digitsEnd])
+			if err == nil {
+				offsetTime, err = addTimeUnits(offsetTime, sign*amount, rest[digitsEnd:unitEnd])
+			}
+		}
+
+		if err != nil {
+			duration, durationErr := time.ParseDuration(offset)
+			if durationErr != nil {
+				return time.Time{}, err
+			}
+			return t.Add(time.Duration(sign) * duration), nil
+		}
+
+		rest = rest[unitEnd:]
+	}
+
+	return offsetTime, nil
+}
+
+func addTimeUnits(t time.Time, amount int, unit string) (time.Time, error) {
+	switch unit {
+	case "ms":
+		return t.Add(time.Duration(amount) * time.Millisecond), nil
+	case "s":
+		return t.Add(time.Duration(amount) * time.Second), nil
+	case "m":
+		return t.Add(time.Duration(amount) * time.Minute), nil
+	case "h":
+		return t.Add(time.Duration(amount) * time.Hour), nil
+	case "d":
+		return t.AddDate(0, 0, amount), nil
+	case "w":
+		return t.AddDate(0, 0, 7*amount), nil
+	case "M":
+		return t.AddDate(0, amount, 0), nil
+	case "y":
+		return t.AddDate(amount, 0, 0), nil
+	default:
+		return time.Time{}, fmt.Errorf("Unknown time unit '%s'. Valid units are ms, s, m, h, d, w, M and y.", unit)
+	}
+}
+
+// roundTime returns the start (or, if roundUp is set, the last nanosecond) of the unit t is in.
+// Weeks start on Monday.
+func roundTime(t time.Time, unit string, roundUp bool) (time.Time, error) {
+	var start, next time.Time
+	y, mo, d := t.Date()
+	loc := t.Location()
+
+	switch unit {
+	case "s":
+		start = time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), 0, loc)
+		next = start.Add(time.Second)
+	case "m":
+		start = time.Date(y, mo, d, t.Hour(), t.Minute(), 0, 0, loc)
+		next = start.Add(time.Minute)
+	case "h":
+		start = time.Date(y, mo, d, t.Hour(), 0, 0, 0, loc)
+		next = start.Add(time.Hour)
+	case "d":
+		start = time.Date(y, mo, d, 0, 0, 0, 0, loc)
+		next = start.AddDate(0, 0, 1)
+	case "w":
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		start = time.Date(y, mo, d-daysSinceMonday, 0, 0, 0, 0, loc)
+		next = start.AddDate(0, 0, 7)
+	case "M":
+		start = time.Date(y, mo, 1, 0, 0, 0, 0, loc)
+		next = start.AddDate(0, 1, 0)
+	case "y":
+		start = time.Date(y, time.January, 1, 0, 0, 0, 0, loc)
+		next = start.AddDate(1, 0, 0)
+	default:
+		return time.Time{}, fmt.Errorf("Unknown rounding unit '%s'. Valid units are s, m, h, d, w, M and y.", unit)
+	}
+
+	if roundUp {
+		return next.Add(-1), nil
+	}
+
+	return start, nil
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
 type KasperbrettRestApi struct {
 	macaron    *macaron.Macaron
 	httpServer *graceful.Server
@@ -702,14 +1776,40 @@ func (io *KasperbrettSocketIOApi) Broadcast(sample *Sample) {
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
+var ErrDataSourceNotFound = errors.New("Data source not found.")
+
 type DataStore interface {
 	Prepare() error
 	ShutDown() error
 	PersistDataSource(dataSource DataSource) error
+	GetDataSource(dataSourceId string) (DataSource, error)
 	GetDataSources() ([]DataSource, error)
+	// GetDataSourcesWithErrors is GetDataSources() plus the errors of the stored data sources that can't be decoded (by id),
+	// which GetDataSources() skips.
+	GetDataSourcesWithErrors() ([]DataSource, map[string]error, error)
+	// DeleteDataSource deletes the definition and the status of the data source. Its samples and rollups are kept (see PurgeSamples()).
+	DeleteDataSource(dataSourceId string) error
+	PersistDataSourceStatuses(statuses []*DataSourceStatus) error
+	GetDataSourceStatuses() ([]*DataSourceStatus, error)
 	PersistSamples(samples []*Sample) error
+	// PurgeSamples deletes all samples and rollups of a deleted data source.
+	// Samples of the data source that are persisted afterwards (e.g. by a retrieval that has still been in flight) are skipped.
+	PurgeSamples(dataSourceId string) error
 	GetSamples(dataSourceId string, from time.Time, to time.Time) ([]*Sample, error)
 	GetLatestSamples(dataSourceId string, num int) ([]*Sample, error)
+	// GetStoredDataSourceIds returns the ids of all data sources that have samples or rollups,
+	// including deleted data sources whose samples have been kept.
+	GetStoredDataSourceIds() ([]string, error)
+	// PruneSamples deletes at most maxBatchSize samples of the data source that are older than the given time.
+	// It returns the number of deleted samples and the number of bytes (keys and values) they occupied.
+	PruneSamples(dataSourceId string, olderThan time.Time, maxBatchSize int) (int, int64, error)
+	// GetRollups returns the rollups of the given resolution whose bucket lies entirely within [from, to], sorted by time.
+	// The partial buckets at the edges have to be computed from the raw samples (see RollupRange()).
+	// It also returns those of the unpersisted samples (e.g. buffered or spilled ones) that still aren't persisted when
+	// the rollups are read, the others are already counted by the rollups.
+	GetRollups(dataSourceId string, resolution RollupResolution, from time.Time, to time.Time, unpersistedSamples []*Sample) ([]*Rollup, []*Sample, error)
+	// PruneRollups is the rollup equivalent of PruneSamples().
+	PruneRollups(dataSourceId string, resolution RollupResolution, olderThan time.Time, maxBatchSize int) (int, int64, error)
 }
 
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
@@ -720,11 +1820,31 @@ type DataStore interface {
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
+// Layout of the Bolt data file (schema version 2):
+//
+//	KasperbrettDataSources: dataSourceId -> data source record
+//	KasperbrettSamples:     dataSourceId (bucket) -> time key -> sample record
+//	KasperbrettRollups:     dataSourceId (bucket) -> resolution name (bucket) -> time key -> rollup record
+//	KasperbrettStatus:      dataSourceId -> data source status record
+//	KasperbrettMeta:        schemaVersion -> uint16 (big-endian)
+//	KasperbrettPurged:      dataSourceId -> time key of the purge (see PurgeSamples())
+//	KasperbrettQuarantine:  bucket name (bucket) -> key -> value (keys the migration couldn't read)
+//
+// Schema version 1 kept the samples (and rollups) of all data sources within a single bucket
+// under keys like 'dataSourceId#RFC3339Nano'. Prepare() migrates such data files once.
 const (
 	BoltDataFileName       = "kasperbrett.db"
 	BoltSamplesBucket      = "KasperbrettSamples"
 	BoltDataSourcesBucket  = "KasperbrettDataSources"
+	BoltRollupsBucket      = "KasperbrettRollups"
+	BoltStatusBucket       = "KasperbrettStatus"
+	BoltMetaBucket         = "KasperbrettMeta"
+	BoltQuarantineBucket   = "KasperbrettQuarantine"
+	BoltPurgedBucket       = "KasperbrettPurged"
+	BoltSchemaVersionKey   = "schemaVersion"
+	BoltSchemaVersion      = uint16(2)
 	BoltSampleKeySeparator = "#"
+	BoltMigrationBatchSize = 10000
 )
 
 func NewBoltDataStore(dataFileAbsPath string) *BoltDataStore {
@@ -750,41 +1870,259 @@ func (ds *BoltDataStore) Prepare() error {
 		return err
 	}
 
-	return ds.createBucketIfNotExists(BoltDataSourcesBucket)
-}
-
-func (ds *BoltDataStore) ShutDown() error {
-	fmt.Println("[BoltDataStore] ShutDown()")
-	return ds.db.Close()
-}
+	err = ds.createBucketIfNotExists(BoltDataSourcesBucket)
+	if err != nil {
+		return err
+	}
 
-func (ds *BoltDataStore) PersistDataSource(dataSource DataSource) error {
-	return ds.db.Update(func(tx *bolt.Tx) error {
-		fmt.Printf("[BoltDataStore] Persisting data source %s\n", dataSource.Id())
-		b := tx.Bucket([]byte(BoltDataSourcesBucket))
-		dataSourceBytes, err := dataSource.GobEncode()
-		if err != nil {
-			return err
-		}
+	err = ds.createBucketIfNotExists(BoltStatusBucket)
+	if err != nil {
+		return err
+	}
+
+	err = ds.createBucketIfNotExists(BoltMetaBucket)
+	if err != nil {
+		return err
+	}
+
+	err = ds.createBucketIfNotExists(BoltQuarantineBucket)
+	if err != nil {
+		return err
+	}
+
+	err = ds.createBucketIfNotExists(BoltPurgedBucket)
+	if err != nil {
+		return err
+	}
+
+	err = ds.migrate()
+	if err != nil {
+		return err
+	}
+
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(BoltRollupsBucket)) != nil {
+			return nil
+		}
+
+		// databases that have been created before the introduction of rollups need an initial computation
+		rollupsBucket, err := tx.CreateBucket([]byte(BoltRollupsBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+
+		fmt.Println("[BoltDataStore] Computing rollups of existing samples...")
+		samplesBucket := tx.Bucket([]byte(BoltSamplesBucket))
+		return samplesBucket.ForEach(func(dataSourceId, v []byte) error {
+			return samplesBucket.Bucket(dataSourceId).ForEach(func(k, sampleBytes []byte) error {
+				sample := new(Sample)
+				if sample.GobDecode(sampleBytes) != nil {
+					return nil
+				}
+				return updateRollups(rollupsBucket, sample)
+			})
+		})
+	})
+}
+
+// migrate upgrades data files of older schema versions. It's a no-op if the data file is up to date.
+func (ds *BoltDataStore) migrate() error {
+	schemaVersion := uint16(1)
+	rollupsExist := false
+
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		if versionBytes := tx.Bucket([]byte(BoltMetaBucket)).Get([]byte(BoltSchemaVersionKey)); len(versionBytes) == 2 {
+			schemaVersion = binary.BigEndian.Uint16(versionBytes)
+		}
+		rollupsExist = tx.Bucket([]byte(BoltRollupsBucket)) != nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if schemaVersion > BoltSchemaVersion {
+		return fmt.Errorf("The data file has been written by a newer version of Kasperbrett (schema version %d).", schemaVersion)
+	}
+
+	if schemaVersion == BoltSchemaVersion {
+		return nil
+	}
+
+	fmt.Printf("[BoltDataStore] Migrating data file from schema version %d to %d...\n", schemaVersion, BoltSchemaVersion)
+
+	// 'dataSourceId#RFC3339Nano' -> dataSourceId (bucket) -> time key
+	err = ds.migrateFlatKeys(BoltSamplesBucket, 1)
+	if err != nil {
+		return err
+	}
+
+	// 'dataSourceId#resolution#RFC3339Nano' -> dataSourceId (bucket) -> resolution (bucket) -> time key
+	if rollupsExist {
+		err = ds.migrateFlatKeys(BoltRollupsBucket, 2)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		versionBytes := make([]byte, 2)
+		binary.BigEndian.PutUint16(versionBytes, BoltSchemaVersion)
+		return tx.Bucket([]byte(BoltMetaBucket)).Put([]byte(BoltSchemaVersionKey), versionBytes)
+	})
+}
+
+// migrateFlatKeys moves the schema version 1 keys of a top-level bucket into nested buckets.
+// A key consists of bucketDepth bucket names and a RFC3339Nano timestamp, all separated by BoltSampleKeySeparator.
+// Keys are moved in batches of BoltMigrationBatchSize per transaction to keep the memory footprint of large data files low.
+// Keys that can't be split are moved to a bucket of the same name within BoltQuarantineBucket instead of being dropped.
+func (ds *BoltDataStore) migrateFlatKeys(bucketName string, bucketDepth int) error {
+	migrated := 0
+	quarantined := 0
+
+	for {
+		batchSize := 0
+
+		err := ds.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(bucketName))
+			c := b.Cursor()
+
+			// keys are collected first because modifying the bucket while iterating would move the cursor
+			flatKeys := [][]byte{}
+			for k, v := c.First(); k != nil && len(flatKeys) < BoltMigrationBatchSize; k, v = c.Next() {
+				// nested buckets (v == nil) have already been migrated
+				if v != nil {
+					flatKeys = append(flatKeys, append([]byte{}, k...))
+				}
+			}
+
+			for _, k := range flatKeys {
+				v := append([]byte{}, b.Get(k)...)
+				err := b.Delete(k)
+				if err != nil {
+					return err
+				}
+
+				bucketPath, timestamp, err := splitFlatKey(string(k), bucketDepth)
+				if err != nil {
+					fmt.Printf("[BoltDataStore.migrate()] Quarantining unreadable key %s due to: %s\n", k, err.Error())
+					quarantineBucket, err := createNestedBucketIfNotExists(tx.Bucket([]byte(BoltQuarantineBucket)), bucketName)
+					if err != nil {
+						return err
+					}
+
+					err = quarantineBucket.Put(k, v)
+					if err != nil {
+						return err
+					}
+
+					quarantined++
+					continue
+				}
+
+				nestedBucket, err := createNestedBucketIfNotExists(b, bucketPath...)
+				if err != nil {
+					return err
+				}
+
+				err = nestedBucket.Put(EncodeTimeKey(timestamp), v)
+				if err != nil {
+					return err
+				}
+			}
+
+			batchSize = len(flatKeys)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		migrated += batchSize
+		if batchSize < BoltMigrationBatchSize {
+			fmt.Printf("[BoltDataStore.migrate()] Migrated %d keys of bucket %s (%d quarantined)\n", migrated, bucketName, quarantined)
+			return nil
+		}
+	}
+}
+
+// splitFlatKey splits a schema version 1 key into its bucketDepth leading parts and the trailing timestamp.
+func splitFlatKey(key string, bucketDepth int) ([]string, time.Time, error) {
+	parts := make([]string, bucketDepth+1)
+	for i := bucketDepth; i > 0; i-- {
+		separatorIndex := strings.LastIndex(key, BoltSampleKeySeparator)
+		if separatorIndex <= 0 {
+			return nil, time.Time{}, errors.New("Unexpected key format.")
+		}
+		parts[i] = key[separatorIndex+len(BoltSampleKeySeparator):]
+		key = key[:separatorIndex]
+	}
+	parts[0] = key
+
+	timestamp, err := time.Parse(time.RFC3339Nano, parts[bucketDepth])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return parts[:bucketDepth], timestamp, nil
+}
+
+func (ds *BoltDataStore) ShutDown() error {
+	fmt.Println("[BoltDataStore] ShutDown()")
+	return ds.db.Close()
+}
+
+func (ds *BoltDataStore) PersistDataSource(dataSource DataSource) error {
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		fmt.Printf("[BoltDataStore] Persisting data source %s\n", dataSource.Id())
+		b := tx.Bucket([]byte(BoltDataSourcesBucket))
+		dataSourceBytes, err := EncodeDataSourceRecord(dataSource)
+		if err != nil {
+			return err
+		}
 
 		return b.Put([]byte(dataSource.Id()), dataSourceBytes)
 	})
 }
 
+func (ds *BoltDataStore) GetDataSource(dataSourceId string) (DataSource, error) {
+	var dataSource DataSource
+
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		dataSourceBytes := tx.Bucket([]byte(BoltDataSourcesBucket)).Get([]byte(dataSourceId))
+		if dataSourceBytes == nil {
+			return ErrDataSourceNotFound
+		}
+
+		var err error
+		dataSource, err = DecodeDataSourceRecord(dataSourceBytes)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	} else {
+		return dataSource, nil
+	}
+}
+
 func (ds *BoltDataStore) GetDataSources() ([]DataSource, error) {
+	dataSources, _, err := ds.GetDataSourcesWithErrors()
+	return dataSources, err
+}
+
+func (ds *BoltDataStore) GetDataSourcesWithErrors() ([]DataSource, map[string]error, error) {
 	dataSources := []DataSource{}
+	decodeErrors := map[string]error{}
 
 	err := ds.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(BoltDataSourcesBucket))
 
-		var dataSource DataSource
-		var err error
 		return b.ForEach(func(dataSourceId, dataSourceBytes []byte) error {
-			// TODO: we need to differentiate between different data source types here
-			dataSource = new(UrlScraper)
-			err = dataSource.GobDecode(dataSourceBytes)
+			dataSource, err := DecodeDataSourceRecord(dataSourceBytes)
 			if err != nil {
 				fmt.Printf("[BoltDataStore.GetDataSources()] Couldn't read data source %s due to: %s\n", dataSourceId, err.Error())
+				decodeErrors[string(dataSourceId)] = err
 			} else {
 				dataSources = append(dataSources, dataSource)
 			}
@@ -793,29 +2131,132 @@ func (ds *BoltDataStore) GetDataSources() ([]DataSource, error) {
 		})
 	})
 
+	if err != nil {
+		return nil, nil, err
+	} else {
+		return dataSources, decodeErrors, nil
+	}
+}
+
+func (ds *BoltDataStore) DeleteDataSource(dataSourceId string) error {
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		fmt.Printf("[BoltDataStore] Deleting data source %s\n", dataSourceId)
+		err := tx.Bucket([]byte(BoltStatusBucket)).Delete([]byte(dataSourceId))
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(BoltDataSourcesBucket)).Delete([]byte(dataSourceId))
+	})
+}
+
+func (ds *BoltDataStore) PersistDataSourceStatuses(statuses []*DataSourceStatus) error {
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BoltStatusBucket))
+
+		for _, status := range statuses {
+			statusBytes, err := status.GobEncode()
+			if err != nil {
+				return err
+			}
+
+			err = b.Put([]byte(status.DataSourceId), statusBytes)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (ds *BoltDataStore) GetDataSourceStatuses() ([]*DataSourceStatus, error) {
+	statuses := []*DataSourceStatus{}
+
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BoltStatusBucket)).ForEach(func(dataSourceId, statusBytes []byte) error {
+			status := new(DataSourceStatus)
+			err := status.GobDecode(statusBytes)
+			if err != nil {
+				fmt.Printf("[BoltDataStore.GetDataSourceStatuses()] Couldn't read status of %s due to: %s\n", dataSourceId, err.Error())
+			} else {
+				statuses = append(statuses, status)
+			}
+
+			return nil
+		})
+	})
+
 	if err != nil {
 		return nil, err
 	} else {
-		return dataSources, nil
+		return statuses, nil
 	}
 }
 
+func (ds *BoltDataStore) PurgeSamples(dataSourceId string) error {
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		fmt.Printf("[BoltDataStore] Purging samples and rollups of data source %s\n", dataSourceId)
+		// data source ids aren't reused, so the id can be remembered forever
+		err := tx.Bucket([]byte(BoltPurgedBucket)).Put([]byte(dataSourceId), EncodeTimeKey(time.Now()))
+		if err != nil {
+			return err
+		}
+
+		for _, bucketName := range []string{BoltSamplesBucket, BoltRollupsBucket} {
+			err := tx.Bucket([]byte(bucketName)).DeleteBucket([]byte(dataSourceId))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
 func (ds *BoltDataStore) PersistSamples(samples []*Sample) error {
 	return ds.db.Update(func(tx *bolt.Tx) error {
 		fmt.Printf("[BoltDataStore] Persisting %d samples\n", len(samples))
-		b := tx.Bucket([]byte(BoltSamplesBucket))
+		samplesBucket := tx.Bucket([]byte(BoltSamplesBucket))
 
 		var overallError error = nil
 
+		rollupsBucket := tx.Bucket([]byte(BoltRollupsBucket))
+		purgedBucket := tx.Bucket([]byte(BoltPurgedBucket))
+
 		for _, sample := range samples {
+			if purgedBucket.Get([]byte(sample.DataSourceId)) != nil {
+				fmt.Printf("[BoltDataStore] Skipping sample %s of purged data source\n", sample.Key())
+				continue
+			}
+
 			fmt.Printf("[BoltDataStore] Persisting sample %s (%s)\n", sample.Key(), sample.String())
+			b, err := samplesBucket.CreateBucketIfNotExists([]byte(sample.DataSourceId))
+			if err != nil {
+				if overallError == nil {
+					overallError = err
+				}
+				continue
+			}
+
+			key := EncodeTimeKey(sample.Timestamp)
+			// samples that have already been persisted must not be counted twice by the rollups
+			isNewSample := b.Get(key) == nil
+
 			sampleBytes, err := sample.GobEncode()
 			if err != nil {
 				if overallError == nil {
 					overallError = err
 				}
 			} else {
-				err := b.Put([]byte(sample.Key()), sampleBytes)
+				err := b.Put(key, sampleBytes)
+				if err != nil && overallError == nil {
+					overallError = err
+				}
+			}
+
+			if isNewSample {
+				err = updateRollups(rollupsBucket, sample)
 				if err != nil && overallError == nil {
 					overallError = err
 				}
@@ -830,9 +2271,14 @@ func (ds *BoltDataStore) GetSamples(dataSourceId string, from time.Time, to time
 	samples := []*Sample{}
 
 	err := ds.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(BoltSamplesBucket)).Cursor()
-		min := []byte(GenerateKey(dataSourceId, BoltSampleKeySeparator, from))
-		max := []byte(GenerateKey(dataSourceId, BoltSampleKeySeparator, to))
+		b := tx.Bucket([]byte(BoltSamplesBucket)).Bucket([]byte(dataSourceId))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		min := EncodeTimeKey(from)
+		max := EncodeTimeKey(to)
 
 		fmt.Printf(" [BoltDataStore.GetSamples()] from -> %s ||| to -> %s\n", from.UTC().Format(time.RFC3339Nano), to.UTC().Format(time.RFC3339Nano))
 
@@ -842,7 +2288,7 @@ func (ds *BoltDataStore) GetSamples(dataSourceId string, from time.Time, to time
 			sample = new(Sample)
 			err = sample.GobDecode(sampleBytes)
 			if err != nil {
-				fmt.Printf("[BoltDataStore.GetSamples()] Couldn't read sample %s due to: %s\n", k, err.Error())
+				fmt.Printf("[BoltDataStore.GetSamples()] Couldn't read sample %s due to: %s\n", GenerateKey(dataSourceId, BoltSampleKeySeparator, DecodeTimeKey(k)), err.Error())
 			} else {
 				samples = append(samples, sample)
 			}
@@ -862,20 +2308,22 @@ func (ds *BoltDataStore) GetLatestSamples(dataSourceId string, num int) ([]*Samp
 	samples := []*Sample{}
 
 	err := ds.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(BoltSamplesBucket)).Cursor()
-		dataSourceIdBytes := []byte(dataSourceId)
+		b := tx.Bucket([]byte(BoltSamplesBucket)).Bucket([]byte(dataSourceId))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
 
 		var err error
 		var sample *Sample
 		for k, sampleBytes := c.Last(); k != nil && len(samples) < num; k, sampleBytes = c.Prev() {
-			if bytes.HasPrefix(k, dataSourceIdBytes) {
-				sample = new(Sample)
-				err = sample.GobDecode(sampleBytes)
-				if err != nil {
-					fmt.Printf("[BoltDataStore.GetLatestSamples()] Couldn't read sample %s due to: %s\n", k, err.Error())
-				} else {
-					samples = append(samples, sample)
-				}
+			sample = new(Sample)
+			err = sample.GobDecode(sampleBytes)
+			if err != nil {
+				fmt.Printf("[BoltDataStore.GetLatestSamples()] Couldn't read sample %s due to: %s\n", GenerateKey(dataSourceId, BoltSampleKeySeparator, DecodeTimeKey(k)), err.Error())
+			} else {
+				samples = append(samples, sample)
 			}
 		}
 
@@ -900,621 +2348,5552 @@ func (ds *BoltDataStore) GetLatestSamples(dataSourceId string, num int) ([]*Samp
 	}
 }
 
-func (ds *BoltDataStore) createBucketIfNotExists(bucketName string) error {
-	return ds.db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
+func (ds *BoltDataStore) GetStoredDataSourceIds() ([]string, error) {
+	dataSourceIds := []string{}
+
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		seen := map[string]bool{}
+		for _, bucketName := range []string{BoltSamplesBucket, BoltRollupsBucket} {
+			b := tx.Bucket([]byte(bucketName))
+			if b == nil {
+				continue
+			}
+
+			err := b.ForEach(func(dataSourceId, v []byte) error {
+				// per-source buckets have a nil value
+				if v == nil && !seen[string(dataSourceId)] {
+					seen[string(dataSourceId)] = true
+					dataSourceIds = append(dataSourceIds, string(dataSourceId))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
 		}
+
 		return nil
 	})
+
+	if err != nil {
+		return nil, err
+	} else {
+		return dataSourceIds, nil
+	}
 }
 
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+func (ds *BoltDataStore) PruneSamples(dataSourceId string, olderThan time.Time, maxBatchSize int) (int, int64, error) {
+	return ds.pruneKeysBefore(
+		[]string{BoltSamplesBucket, dataSourceId},
+		EncodeTimeKey(olderThan),
+		maxBatchSize,
+	)
+}
 
-/* ***** ██████╗  █████╗ ████████╗ █████╗     ██████╗ ███████╗████████╗██████╗ ██╗███████╗██╗   ██╗ █████╗ ██╗      ***** */
-/* ***** ██╔══██╗██╔══██╗╚══██╔══╝██╔══██╗    ██╔══██╗██╔════╝╚══██╔══╝██╔══██╗██║██╔════╝██║   ██║██╔══██╗██║      ***** */
-/* ***** ██║  ██║███████║   ██║   ███████║    ██████╔╝█████╗     ██║   ██████╔╝██║█████╗  ██║   ██║███████║██║      ***** */
-/* ***** ██║  ██║██╔══██║   ██║   ██╔══██║    ██╔══██╗██╔══╝     ██║   ██╔══██╗██║██╔══╝  ╚██╗ ██╔╝██╔══██║██║      ***** */
-/* ***** ██████╔╝██║  ██║   ██║   ██║  ██║    ██║  ██║███████╗   ██║   ██║  ██║██║███████╗ ╚████╔╝ ██║  ██║███████╗ ***** */
-/* ***** ╚═════╝ ╚═╝  ╚═╝   ╚═╝   ╚═╝  ╚═╝    ╚═╝  ╚═╝╚══════╝   ╚═╝   ╚═╝  ╚═╝╚═╝╚══════╝  ╚═══╝  ╚═╝  ╚═╝╚══════╝ ***** */
-/* ***** Banner comment generated by http://patorjk.com/software/taag ***** ***** ***** ***** ***** ***** ***** *** ***** */
+func (ds *BoltDataStore) GetRollups(dataSourceId string, resolution RollupResolution, from time.Time, to time.Time, unpersistedSamples []*Sample) ([]*Rollup, []*Sample, error) {
+	rollups := []*Rollup{}
+	remainingSamples := []*Sample{}
 
-// TODO: Refactor this section to a separate package!
-// TODO: Add test cases
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		// a sample might have been persisted (and rolled up) since it has been handed over, e.g. by a flush in the meantime
+		samplesBucket := tx.Bucket([]byte(BoltSamplesBucket)).Bucket([]byte(dataSourceId))
+		for _, sample := range unpersistedSamples {
+			if samplesBucket == nil || samplesBucket.Get(EncodeTimeKey(sample.Timestamp)) == nil {
+				remainingSamples = append(remainingSamples, sample)
+			}
+		}
 
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+		b := getNestedBucket(tx.Bucket([]byte(BoltRollupsBucket)), dataSourceId, resolution.Name)
+		if b == nil {
+			return nil
+		}
 
-type DataSource interface {
-	gob.GobEncoder
-	gob.GobDecoder
-	Retrieve(sampleChan chan *Sample)
-	Id() string
-	Type() string
-	Name() string
-	Interval() time.Duration
-	Timeout() time.Duration
-}
+		c := b.Cursor()
+		rollupsFrom, rollupsTo := RollupRange(resolution, from, to)
+		min := EncodeTimeKey(rollupsFrom)
+		max := EncodeTimeKey(rollupsTo)
 
-const (
-	DsUrlScraper = "DsUrlScraper"
-)
-
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-
-func Retrieve(ds DataSource, timeout time.Duration) *Sample {
-	sampleChan := make(chan *Sample)
-	go ds.Retrieve(sampleChan)
+		for k, rollupBytes := c.Seek(min); k != nil && bytes.Compare(k, max) < 0; k, rollupBytes = c.Next() {
+			rollup := new(Rollup)
+			err := rollup.GobDecode(rollupBytes)
+			if err != nil {
+				fmt.Printf("[BoltDataStore.GetRollups()] Couldn't read rollup %s of %s due to: %s\n", DecodeTimeKey(k).UTC().Format(time.RFC3339Nano), dataSourceId, err.Error())
+			} else {
+				rollups = append(rollups, rollup)
+			}
+		}
 
-	var sample *Sample
+		return nil
+	})
 
-	select {
-	case sample = <-sampleChan:
-	case now := <-time.After(timeout):
-		sample = NewSample("", now, ds.Id(), errors.New("Sample retrieval timed out."))
+	if err != nil {
+		return nil, nil, err
+	} else {
+		return rollups, remainingSamples, nil
 	}
-
-	return sample
 }
 
-func RetrieveAndDistribute(ds DataSource, re ReportingEngine, timeout time.Duration) {
-	sample := Retrieve(ds, timeout)
-	re.Distribute(sample)
+func (ds *BoltDataStore) PruneRollups(dataSourceId string, resolution RollupResolution, olderThan time.Time, maxBatchSize int) (int, int64, error) {
+	return ds.pruneKeysBefore(
+		[]string{BoltRollupsBucket, dataSourceId, resolution.Name},
+		EncodeTimeKey(olderThan),
+		maxBatchSize,
+	)
 }
 
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+// pruneKeysBefore deletes at most maxBatchSize keys lower than max of the (nested) bucket at bucketPath in a single transaction.
+func (ds *BoltDataStore) pruneKeysBefore(bucketPath []string, max []byte, maxBatchSize int) (int, int64, error) {
+	pruned := 0
+	var prunedBytes int64 = 0
+
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		b := getNestedBucket(tx.Bucket([]byte(bucketPath[0])), bucketPath[1:]...)
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		// keys are collected first because deleting while iterating would move the cursor
+		expiredKeys := [][]byte{}
+		for k, v := c.First(); k != nil && bytes.Compare(k, max) < 0 && len(expiredKeys) < maxBatchSize; k, v = c.Next() {
+			expiredKeys = append(expiredKeys, append([]byte{}, k...))
+			prunedBytes += int64(len(k) + len(v))
+		}
+
+		for _, k := range expiredKeys {
+			err := b.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		pruned = len(expiredKeys)
+		return nil
+	})
 
-func NewAbstractDataSource(name string, interval time.Duration, timeout time.Duration) (AbstractDataSource, error) {
-	uuid, err := uuid.NewV4()
 	if err != nil {
-		return AbstractDataSource{}, err
+		return 0, 0, err
+	} else {
+		return pruned, prunedBytes, nil
 	}
+}
 
-	dataSourceId := "ds-" + uuid.String()
-
-	return AbstractDataSource{
-		dataSourceId: dataSourceId,
-		name:         name,
-		interval:     interval,
-		timeout:      timeout,
-	}, nil
+func (ds *BoltDataStore) createBucketIfNotExists(bucketName string) error {
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		return nil
+	})
 }
 
-type AbstractDataSource struct {
-	dataSourceId string
-	name         string
-	interval     time.Duration
-	timeout      time.Duration
+// getNestedBucket returns nil if one of the buckets along the path doesn't exist.
+func getNestedBucket(b *bolt.Bucket, bucketNames ...string) *bolt.Bucket {
+	for _, bucketName := range bucketNames {
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket([]byte(bucketName))
+	}
+
+	return b
 }
 
-func (this AbstractDataSource) Id() string {
-	return this.dataSourceId
+func createNestedBucketIfNotExists(b *bolt.Bucket, bucketNames ...string) (*bolt.Bucket, error) {
+	var err error
+	for _, bucketName := range bucketNames {
+		b, err = b.CreateBucketIfNotExists([]byte(bucketName))
+		if err != nil {
+			return nil, fmt.Errorf("create bucket: %s", err)
+		}
+	}
+
+	return b, nil
 }
 
-func (this AbstractDataSource) Name() string {
-	return this.name
+// EncodeTimeKey converts a timestamp into a Bolt key: the nanoseconds since Unix Epoch as 8 byte big-endian integer.
+// Bolt stores keys in byte-sorted order, so these keys are sorted chronologically.
+// Timestamps outside the range of UnixNano() (before 1970 or after 2262) are clamped.
+func EncodeTimeKey(timestamp time.Time) []byte {
+	var nanos uint64
+	if timestamp.Before(time.Unix(0, 0)) {
+		nanos = 0
+	} else if timestamp.After(time.Unix(0, math.MaxInt64)) {
+		nanos = math.MaxInt64
+	} else {
+		nanos = uint64(timestamp.UnixNano())
+	}
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, nanos)
+	return key
 }
 
-func (this AbstractDataSource) Interval() time.Duration {
-	return this.interval
+func DecodeTimeKey(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key))).UTC()
 }
 
-func (this AbstractDataSource) Timeout() time.Duration {
-	return this.timeout
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// RetentionPruneBatchSize limits the number of samples that are deleted within a single write transaction,
+// so that the janitor never blocks the flushing of new samples for too long.
+const RetentionPruneBatchSize = 1000
+
+type RetentionStats struct {
+	GlobalRetention      time.Duration
+	RollupRetentions     map[string]time.Duration
+	CheckInterval        time.Duration
+	Runs                 int
+	LastRun              time.Time
+	LastRunDuration      time.Duration
+	LastRunPrunedSamples int
+	LastRunPrunedRollups int
+	// LastRunPrunedBytes is the size of the keys and values of the pruned records. Bolt reuses the pages
+	// they occupied, so it isn't the amount of disk space that has been given back to the file system.
+	LastRunPrunedBytes int64
+	LastError          error
+	TotalPrunedSamples int
+	TotalPrunedRollups int
+	TotalPrunedBytes   int64
 }
 
-func (this *AbstractDataSource) GobEncode() ([]byte, error) {
-	// TODO: It might make sense to include a version number in the encoding due to future changes.
+// NewRetentionJanitor starts a background job that deletes expired samples every checkInterval.
+// Samples expire after the retention of their data source or, if the data source doesn't have one, after the global retention.
+// Rollups have a retention per resolution (see RollupResolutions) that applies to all data sources.
+// Samples of deleted data sources that have been kept expire after the global retention.
+// A retention of 0 keeps samples forever. Freed pages are reused by Bolt, but the data file itself doesn't shrink.
+func NewRetentionJanitor(dataStore DataStore, globalRetention time.Duration, rollupRetentions map[string]time.Duration, checkInterval time.Duration) *RetentionJanitor {
+	j := &RetentionJanitor{
+		dataStore:        dataStore,
+		globalRetention:  globalRetention,
+		rollupRetentions: rollupRetentions,
+		checkTicker:      time.NewTicker(checkInterval),
+		statsRequestChan: make(chan chan RetentionStats),
+		shutDownChan:     make(chan chan bool),
+		stats:            RetentionStats{GlobalRetention: globalRetention, RollupRetentions: rollupRetentions, CheckInterval: checkInterval},
+	}
+
+	go func() {
+		for {
+			select {
+			case <-j.checkTicker.C:
+				j.prune(time.Now())
+
+			case responseChan := <-j.statsRequestChan:
+				responseChan <- j.stats
 
-	fmt.Println("   [AbstractDataSource]   GobEncode()")
+			case responseChan := <-j.shutDownChan:
+				j.checkTicker.Stop()
+				responseChan <- true
+				return
+			}
+		}
+	}()
 
-	buff := new(bytes.Buffer)
-	encoder := gob.NewEncoder(buff)
+	return j
+}
 
-	err := encoder.Encode(this.dataSourceId)
+type RetentionJanitor struct {
+	dataStore        DataStore
+	globalRetention  time.Duration
+	rollupRetentions map[string]time.Duration // never modified after construction
+	checkTicker      *time.Ticker
+	statsRequestChan chan chan RetentionStats
+	shutDownChan     chan chan bool
+	stats            RetentionStats
+}
+
+func (j *RetentionJanitor) prune(now time.Time) {
+	j.stats.Runs++
+	j.stats.LastRun = now
+	j.stats.LastRunPrunedSamples = 0
+	j.stats.LastRunPrunedRollups = 0
+	j.stats.LastRunPrunedBytes = 0
+	j.stats.LastError = nil
+
+	dataSources, err := j.dataStore.GetDataSources()
 	if err != nil {
-		return nil, err
+		j.stats.LastError = err
+		fmt.Println("[RetentionJanitor] Couldn't read data sources due to:", err)
+		return
 	}
 
-	err = encoder.Encode(this.name)
-	if err != nil {
-		return nil, err
+	retentions := map[string]time.Duration{}
+	for _, dataSource := range dataSources {
+		retentions[dataSource.Id()] = dataSource.Retention()
 	}
 
-	err = encoder.Encode(this.interval)
+	// the stored samples are walked instead of the data sources, because deleted data sources might have kept theirs
+	dataSourceIds, err := j.dataStore.GetStoredDataSourceIds()
 	if err != nil {
-		return nil, err
+		j.stats.LastError = err
+		fmt.Println("[RetentionJanitor] Couldn't read stored data sources due to:", err)
+		return
 	}
 
-	err = encoder.Encode(this.timeout)
-	if err != nil {
-		return nil, err
+	for _, dataSourceId := range dataSourceIds {
+		dataSourceId := dataSourceId
+		retention := retentions[dataSourceId]
+		if retention == 0 {
+			retention = j.globalRetention
+		}
+		if retention > 0 {
+			j.stats.LastRunPrunedSamples += j.pruneInBatches(dataSourceId, func() (int, int64, error) {
+				return j.dataStore.PruneSamples(dataSourceId, now.Add(-1*retention), RetentionPruneBatchSize)
+			})
+		}
+
+		for _, resolution := range RollupResolutions {
+			rollupRetention := j.RollupRetention(resolution)
+			if rollupRetention > 0 {
+				resolution := resolution
+				j.stats.LastRunPrunedRollups += j.pruneInBatches(dataSourceId, func() (int, int64, error) {
+					return j.dataStore.PruneRollups(dataSourceId, resolution, now.Add(-1*rollupRetention), RetentionPruneBatchSize)
+				})
+			}
+		}
 	}
 
-	return buff.Bytes(), nil
+	j.stats.LastRunDuration = time.Since(now)
+	j.stats.TotalPrunedSamples += j.stats.LastRunPrunedSamples
+	j.stats.TotalPrunedRollups += j.stats.LastRunPrunedRollups
+	j.stats.TotalPrunedBytes += j.stats.LastRunPrunedBytes
+	fmt.Printf("[RetentionJanitor] Pruned %d samples and %d rollups (%d bytes)\n", j.stats.LastRunPrunedSamples, j.stats.LastRunPrunedRollups, j.stats.LastRunPrunedBytes)
 }
 
-func (this *AbstractDataSource) GobDecode(abstractDataSourceBytes []byte) error {
-	// TODO: It might make sense to include a version number in the encoding due to future changes.
-	fmt.Println("   [AbstractDataSource]   GobDecode()")
+// pruneInBatches calls pruneBatch until there is nothing left to prune and returns the overall number of pruned records.
+func (j *RetentionJanitor) pruneInBatches(dataSourceId string, pruneBatch func() (int, int64, error)) int {
+	overallPruned := 0
 
-	buff := bytes.NewBuffer(abstractDataSourceBytes)
-	decoder := gob.NewDecoder(buff)
+	for {
+		pruned, prunedBytes, err := pruneBatch()
+		if err != nil {
+			j.stats.LastError = err
+			fmt.Printf("[RetentionJanitor] Couldn't prune data of data source %s due to: %s\n", dataSourceId, err.Error())
+			return overallPruned
+		}
 
-	err := decoder.Decode(&this.dataSourceId)
-	if err != nil {
-		return err
+		overallPruned += pruned
+		j.stats.LastRunPrunedBytes += prunedBytes
+		if pruned < RetentionPruneBatchSize {
+			return overallPruned
+		}
 	}
+}
 
-	err = decoder.Decode(&this.name)
+// RollupRetention returns how long rollups of the given resolution are kept (0 means forever).
+func (j *RetentionJanitor) RollupRetention(resolution RollupResolution) time.Duration {
+	return j.rollupRetentions[resolution.Name]
+}
+
+func (j *RetentionJanitor) Stats() RetentionStats {
+	responseChan := make(chan RetentionStats)
+	j.statsRequestChan <- responseChan
+	return <-responseChan
+}
+
+func (j *RetentionJanitor) ShutDown() {
+	responseChan := make(chan bool)
+	j.shutDownChan <- responseChan
+	<-responseChan
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func EncodeDataSourceRecord(dataSource DataSource) ([]byte, error) {
+	return dataSource.GobEncode()
+}
+
+func DecodeDataSourceRecord(recordBytes []byte) (DataSource, error) {
+	record, err := DecodeRecord(recordBytes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = decoder.Decode(&this.interval)
-	if err != nil {
-		return err
+	dataSourceTypeName := record.Type
+	if record.Version == LegacyRecordVersion {
+		// data sources that have been stored before the introduction of records are always URL scrapers
+		dataSourceTypeName = DsUrlScraper
 	}
 
-	err = decoder.Decode(&this.timeout)
+	dataSourceType, err := GetDataSourceType(dataSourceTypeName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return dataSourceType.Decode(recordBytes)
 }
 
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
-func GenerateKey(dataSourceId string, keySeparator string, timestamp time.Time) string {
-	// Due to the characteristics of Bolt (it stores keys in byte-sorted order) we use a time format that is sortable (RFC3339).
-	// Additionally, when it comes to time we want to be as precise as possible.
-	// And we need to avoid key collisions in case a data source has a very short retrieval interval (< 1s).
-	// Therefore we use RFC3339Nano instead of simple RFC3339.
-	// Furthermore, bucket keys should be based on reference time instead of local time. That's why we choose UTC.
-	return fmt.Sprintf("%s%s%s", dataSourceId, keySeparator, timestamp.UTC().Format(time.RFC3339Nano))
-}
+/* ***** ██████╗  █████╗ ████████╗ █████╗     ██████╗ ███████╗████████╗██████╗ ██╗███████╗██╗   ██╗ █████╗ ██╗      ***** */
+/* ***** ██╔══██╗██╔══██╗╚══██╔══╝██╔══██╗    ██╔══██╗██╔════╝╚══██╔══╝██╔══██╗██║██╔════╝██║   ██║██╔══██╗██║      ***** */
+/* ***** ██║  ██║███████║   ██║   ███████║    ██████╔╝█████╗     ██║   ██████╔╝██║█████╗  ██║   ██║███████║██║      ***** */
+/* ***** ██║  ██║██╔══██║   ██║   ██╔══██║    ██╔══██╗██╔══╝     ██║   ██╔══██╗██║██╔══╝  ╚██╗ ██╔╝██╔══██║██║      ***** */
+/* ***** ██████╔╝██║  ██║   ██║   ██║  ██║    ██║  ██║███████╗   ██║   ██║  ██║██║███████╗ ╚████╔╝ ██║  ██║███████╗ ***** */
+/* ***** ╚═════╝ ╚═╝  ╚═╝   ╚═╝   ╚═╝  ╚═╝    ╚═╝  ╚═╝╚══════╝   ╚═╝   ╚═╝  ╚═╝╚═╝╚══════╝  ╚═══╝  ╚═╝  ╚═╝╚══════╝ ***** */
+/* ***** Banner comment generated by http://patorjk.com/software/taag ***** ***** ***** ***** ***** ***** ***** *** ***** */
+
+// TODO: Refactor this section to a separate package!
+// TODO: Add test cases
 
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
-func NewSample(value string, timestamp time.Time, dataSourceId string, err error) *Sample {
-	if len(dataSourceId) == 0 {
-		panic("A Sample must have a valid dataSourceId.")
-	}
+type DataSource interface {
+	gob.GobEncoder
+	gob.GobDecoder
+	// Retrieve sends exactly one sample to the (buffered) sampleChan. As soon as ctx is done
+	// the retrieval has to be aborted, e.g. by passing ctx on to HTTP requests and transformation scripts.
+	Retrieve(ctx context.Context, sampleChan chan *Sample)
+	Id() string
+	Type() string
+	Name() string
+	Interval() time.Duration
+	Timeout() time.Duration
+	Retention() time.Duration
+	// Paused data sources are stored but not scheduled.
+	Paused() bool
+	SetPaused(paused bool)
+	RetryPolicy() RetryPolicy
+	CircuitBreaker() CircuitBreakerSettings
+	// ValueType is empty for data sources that have been created before the introduction of value types.
+	ValueType() string
+	ValueFormat() ValueFormat
+}
 
-	if len(value) == 0 && err == nil {
-		panic("A Sample must have a valid value or a specified error.")
-	}
+const (
+	DsUrlScraper    = "DsUrlScraper"
+	DsJsonApi       = "DsJsonApi"
+	DsHttpRegex     = "DsHttpRegex"
+	DsXmlApi        = "DsXmlApi"
+	DsCommand       = "DsCommand"
+	DsSystemMetrics = "DsSystemMetrics"
+	DsHttpProbe     = "DsHttpProbe"
+)
 
-	return &Sample{
-		Value:        value,
-		Timestamp:    timestamp,
-		DataSourceId: dataSourceId,
-		Err:          err,
-	}
-}
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
-type Sample struct {
-	Value        string
-	Timestamp    time.Time
-	DataSourceId string
-	Err          error
+// DataSourceType bundles everything Kasperbrett needs to know about a kind of data source.
+// Every DataSource implementation registers exactly one DataSourceType (see RegisterDataSourceType()).
+type DataSourceType struct {
+	// Name is the type tag that is used in the REST API and in the data store (e.g. DsUrlScraper).
+	Name string
+	// New creates a data source from validated type settings.
+	New func(abstractDataSource AbstractDataSource, typeSettings map[string]string) (DataSource, error)
+	// ValidateTypeSettings checks the type settings of a DataSourceDto before New() is called.
+	ValidateTypeSettings func(typeSettings map[string]string) error
+	// ExportTypeSettings is the counterpart of New() and fills DataSourceDto.TypeSettings.
+	ExportTypeSettings func(dataSource DataSource) map[string]string
+	// Decode restores a data source from the bytes produced by its GobEncode().
+	Decode func(dataSourceBytes []byte) (DataSource, error)
 }
 
-func (this *Sample) JSON() string {
-	/*b, err := json.Marshal(this)
-	if err != nil {
-		// TODO: log this misbehaviour
-		return "{}"
-	}*/
+var dataSourceTypes = make(map[string]*DataSourceType)
 
-	json := fmt.Sprintf("{\"dataSourceId\":\"%s\", \"timestamp\":%d, \"value\":\"%s\"}", this.DataSourceId, this.Timestamp.UnixNano()/1000000, this.Value)
+// RegisterDataSourceType is supposed to be called from init() functions only.
+func RegisterDataSourceType(dataSourceType *DataSourceType) {
+	if _, exists := dataSourceTypes[dataSourceType.Name]; exists {
+		panic("Data source type has already been registered: " + dataSourceType.Name)
+	}
 
-	return json
+	dataSourceTypes[dataSourceType.Name] = dataSourceType
 }
 
-func (this *Sample) Key() string {
-	return GenerateKey(this.DataSourceId, BoltSampleKeySeparator, this.Timestamp)
+func GetDataSourceType(name string) (*DataSourceType, error) {
+	dataSourceType, ok := dataSourceTypes[name]
+	if !ok {
+		return nil, errors.New("Unsupported data source type: " + name)
+	}
+
+	return dataSourceType, nil
 }
 
-func (this *Sample) GobEncode() ([]byte, error) {
-	// TODO: It might make sense to include a version number in the encoding due to future changes.
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
-	buff := new(bytes.Buffer)
-	encoder := gob.NewEncoder(buff)
+// Retrieve retrieves a sample of the data source and aborts the retrieval after the timeout or as soon as ctx is done.
+func Retrieve(ctx context.Context, ds DataSource, timeout time.Duration) *Sample {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	err := encoder.Encode(this.Value)
-	if err != nil {
-		return nil, err
-	}
+	// the buffer allows the data source to send its sample even if nobody is waiting for it anymore
+	sampleChan := make(chan *Sample, 1)
+	start := time.Now()
+	go ds.Retrieve(ctx, sampleChan)
 
-	err = encoder.Encode(this.Timestamp)
-	if err != nil {
-		return nil, err
-	}
+	var sample *Sample
 
-	err = encoder.Encode(this.DataSourceId)
-	if err != nil {
-		return nil, err
+	select {
+	case sample = <-sampleChan:
+		// a data source that has been aborted reports the context error, which isn't meaningful to users
+		if sample.Err != nil && ctx.Err() == context.DeadlineExceeded {
+			meta := sample.Meta
+			sample = NewSample("", sample.Timestamp, ds.Id(), errors.New("Sample retrieval timed out."))
+			sample.Meta = meta
+		}
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			sample = NewSample("", time.Now(), ds.Id(), errors.New("Sample retrieval timed out."))
+		} else {
+			sample = NewSample("", time.Now(), ds.Id(), errors.New("Sample retrieval has been canceled."))
+		}
 	}
+	sample.Latency = time.Since(start)
 
-	errStr := ""
-	if this.Err != nil {
-		errStr = this.Err.Error()
+	if sample.Err == nil {
+		err := ParseSampleValue(sample, ds.ValueType(), ds.ValueFormat())
+		if err != nil {
+			meta := sample.Meta
+			sample = NewSample("", sample.Timestamp, ds.Id(), err)
+			sample.Latency = time.Since(start)
+			sample.Meta = meta
+		}
 	}
 
-	err = encoder.Encode(errStr)
-	if err != nil {
-		return nil, err
+	return sample
+}
+
+// RetrieveWithRetries retries failed retrievals according to the retry policy of the data source.
+// Every attempt has its own timeout. The returned sample is the one of the last attempt.
+// All attempts together are aborted after the interval of the data source, so that they never delay the next retrieval.
+func RetrieveWithRetries(ctx context.Context, ds DataSource) *Sample {
+	ctx, cancel := context.WithTimeout(ctx, ds.Interval())
+	defer cancel()
+
+	retryPolicy := ds.RetryPolicy()
+	sample := Retrieve(ctx, ds, ds.Timeout())
+
+	for retries := 1; sample.Err != nil && retries < retryPolicy.MaxAttempts; retries++ {
+		select {
+		case <-time.After(retryPolicy.Backoff(retries, rand.Float64())):
+		case <-ctx.Done():
+			return sample
+		}
+
+		fmt.Printf("[RetrieveWithRetries] Retrying data source %s (%d/%d) due to: %s\n", ds.Id(), retries+1, retryPolicy.MaxAttempts, sample.Err.Error())
+		sample = Retrieve(ctx, ds, ds.Timeout())
+		sample.Retries = retries
 	}
 
-	return buff.Bytes(), nil
+	return sample
 }
 
-func (this *Sample) GobDecode(sampleBytes []byte) error {
-	// TODO: It might make sense to include a version number in the encoding due to future changes.
-	fmt.Println("   [Sample]   GobDecode()")
+// RetrieveAndDistribute distributes the retrieved sample unless ctx has been canceled in the meantime (e.g. by the scheduler).
+// It returns the interval until the next retrieval, which is lengthened by the circuit breaker after consecutive failures.
+func RetrieveAndDistribute(ctx context.Context, ds DataSource, re ReportingEngine, circuitBreaker *CircuitBreaker) time.Duration {
+	sample := RetrieveWithRetries(ctx, ds)
+	if ctx.Err() != nil {
+		return 0
+	}
 
-	buff := bytes.NewBuffer(sampleBytes)
-	decoder := gob.NewDecoder(buff)
+	interval := circuitBreaker.Record(sample.Err == nil)
+	sample.BreakerOpen = circuitBreaker.IsOpen()
 
-	err := decoder.Decode(&this.Value)
-	if err != nil {
-		return err
-	}
+	re.Distribute(sample)
+	return interval
+}
 
-	err = decoder.Decode(&this.Timestamp)
-	if err != nil {
-		return err
-	}
+// MaxRetrievalAttempts limits the attempts per scheduled retrieval. Additionally, all attempts (including their timeouts
+// and backoffs) have to fit into the interval of the data source (see RetryPolicy.MaxDuration()).
+const MaxRetrievalAttempts = 10
+
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt. Values < 2 disable retries.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the fraction (0 - 1) of the backoff by which it gets randomized in both directions
+	Jitter float64
+}
 
-	err = decoder.Decode(&this.DataSourceId)
-	if err != nil {
-		return err
+// Backoff returns the delay before the given retry (starting at 1). It doubles with every retry up to MaxBackoff.
+// random has to be within [0, 1), e.g. rand.Float64().
+func (p RetryPolicy) Backoff(retry int, random float64) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(2, float64(retry-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
 	}
 
-	var str string
+	return time.Duration(backoff * (1 + p.Jitter*(2*random-1)))
+}
+
+// MaxDuration returns how long all attempts of a retrieval take at most if every attempt runs into the given timeout.
+func (p RetryPolicy) MaxDuration(timeout time.Duration) time.Duration {
+	maxDuration := timeout
+	for retry := 1; retry < p.MaxAttempts; retry++ {
+		// the highest possible jitter
+		maxDuration += p.Backoff(retry, 1) + timeout
+	}
+
+	return maxDuration
+}
+
+type CircuitBreakerSettings struct {
+	// Threshold is the number of consecutive failed retrievals that open the circuit breaker (0 disables it).
+	Threshold int
+	// Interval replaces the interval of the data source while the circuit breaker is open.
+	Interval time.Duration
+}
+
+// NewCircuitBreaker creates a (closed) circuit breaker for the scheduled job of the data source.
+func NewCircuitBreaker(ds DataSource) *CircuitBreaker {
+	return &CircuitBreaker{settings: ds.CircuitBreaker(), interval: ds.Interval()}
+}
+
+// CircuitBreaker lengthens the interval of a data source that keeps failing and restores it as soon as a retrieval succeeds.
+// It's only used by the goroutine of a single scheduler job and therefore doesn't need to be synchronized.
+type CircuitBreaker struct {
+	settings            CircuitBreakerSettings
+	interval            time.Duration
+	consecutiveFailures int
+	open                bool
+}
+
+// Record returns the interval until the next retrieval.
+func (cb *CircuitBreaker) Record(success bool) time.Duration {
+	if success {
+		cb.consecutiveFailures = 0
+		cb.open = false
+	} else {
+		cb.consecutiveFailures++
+		if cb.settings.Threshold > 0 && cb.consecutiveFailures >= cb.settings.Threshold {
+			cb.open = true
+		}
+	}
+
+	if cb.open {
+		return cb.settings.Interval
+	}
+	return cb.interval
+}
+
+func (cb *CircuitBreaker) IsOpen() bool {
+	return cb.open
+}
+
+// ValidateDataSource checks the settings every data source needs in order to be scheduled.
+func ValidateDataSource(ds DataSource) error {
+	if len(ds.Id()) == 0 {
+		return errors.New("The data source doesn't have a valid id.")
+	}
+	if ds.Interval() <= 0 {
+		return fmt.Errorf("The data source has an invalid interval (%s).", ds.Interval())
+	}
+	if ds.Timeout() <= 0 {
+		return fmt.Errorf("The data source has an invalid timeout (%s).", ds.Timeout())
+	}
+
+	err := ValidateValueType(ds.ValueType(), ds.ValueFormat())
+	if err != nil {
+		return err
+	}
+
+	dataSourceType, err := GetDataSourceType(ds.Type())
+	if err != nil {
+		return err
+	}
+
+	return dataSourceType.ValidateTypeSettings(dataSourceType.ExportTypeSettings(ds))
+}
+
+// ScheduleDataSource registers a job at the scheduler that retrieves a sample of the given data source
+// every Interval() (or less often while its circuit breaker is open) and distributes it.
+// It blocks until the scheduler has accepted (or rejected) the job.
+func ScheduleDataSource(scheduler Scheduler, ds DataSource) error {
+	circuitBreaker := NewCircuitBreaker(ds)
+	responseChan, errorChan := scheduler.Schedule(ds.Id(), ds.Interval(), func(ctx context.Context, reportingEngine ReportingEngine) time.Duration {
+		return RetrieveAndDistribute(ctx, ds, reportingEngine, circuitBreaker)
+	})
+
+	select {
+	case <-responseChan:
+		return nil
+	case err := <-errorChan:
+		return err
+	}
+}
+
+// CancelDataSource removes the job of the given data source from the scheduler. It blocks until the job has stopped.
+// A data source without a job (e.g. one that couldn't be rescheduled on startup) counts as canceled.
+func CancelDataSource(scheduler Scheduler, ds DataSource) error {
+	_, errorChan := scheduler.Cancel(ds.Id())
+	err := <-errorChan
+	if _, ok := err.(*JobNotAvailableError); ok {
+		return nil
+	}
+	return err
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func NewAbstractDataSource(name string, interval time.Duration, timeout time.Duration) (AbstractDataSource, error) {
+	uuid, err := uuid.NewV4()
+	if err != nil {
+		return AbstractDataSource{}, err
+	}
+
+	dataSourceId := "ds-" + uuid.String()
+
+	return NewAbstractDataSourceWithId(dataSourceId, name, interval, timeout), nil
+}
+
+// NewAbstractDataSourceWithId is meant for replacing the definition of an existing data source.
+func NewAbstractDataSourceWithId(dataSourceId string, name string, interval time.Duration, timeout time.Duration) AbstractDataSource {
+	return AbstractDataSource{
+		dataSourceId: dataSourceId,
+		name:         name,
+		interval:     interval,
+		timeout:      timeout,
+	}
+}
+
+type AbstractDataSource struct {
+	dataSourceId   string
+	name           string
+	interval       time.Duration
+	timeout        time.Duration
+	retention      time.Duration
+	paused         bool
+	retryPolicy    RetryPolicy
+	circuitBreaker CircuitBreakerSettings
+	valueType      string
+	valueFormat    ValueFormat
+}
+
+func (this AbstractDataSource) Id() string {
+	return this.dataSourceId
+}
+
+func (this AbstractDataSource) Name() string {
+	return this.name
+}
+
+func (this AbstractDataSource) Interval() time.Duration {
+	return this.interval
+}
+
+func (this AbstractDataSource) Timeout() time.Duration {
+	return this.timeout
+}
+
+func (this AbstractDataSource) Retention() time.Duration {
+	return this.retention
+}
+
+func (this *AbstractDataSource) SetRetention(retention time.Duration) {
+	this.retention = retention
+}
+
+func (this AbstractDataSource) Paused() bool {
+	return this.paused
+}
+
+func (this *AbstractDataSource) SetPaused(paused bool) {
+	this.paused = paused
+}
+
+func (this AbstractDataSource) RetryPolicy() RetryPolicy {
+	return this.retryPolicy
+}
+
+func (this *AbstractDataSource) SetRetryPolicy(retryPolicy RetryPolicy) {
+	this.retryPolicy = retryPolicy
+}
+
+func (this AbstractDataSource) CircuitBreaker() CircuitBreakerSettings {
+	return this.circuitBreaker
+}
+
+func (this *AbstractDataSource) SetCircuitBreaker(circuitBreaker CircuitBreakerSettings) {
+	this.circuitBreaker = circuitBreaker
+}
+
+func (this AbstractDataSource) ValueType() string {
+	return this.valueType
+}
+
+func (this AbstractDataSource) ValueFormat() ValueFormat {
+	return this.valueFormat
+}
+
+func (this *AbstractDataSource) SetValueType(valueType string, valueFormat ValueFormat) {
+	this.valueType = valueType
+	this.valueFormat = valueFormat
+}
+
+// abstractDataSourceRecordV1 is embedded into the version 1 records of all data source types.
+// New fields can be added here as long as their zero value is a sensible default for existing records.
+type abstractDataSourceRecordV1 struct {
+	DataSourceId   string
+	Name           string
+	Interval       time.Duration
+	Timeout        time.Duration
+	Retention      time.Duration
+	Paused         bool
+	RetryPolicy    RetryPolicy
+	CircuitBreaker CircuitBreakerSettings
+	ValueType      string
+	ValueFormat    ValueFormat
+}
+
+func (this *AbstractDataSource) recordV1() abstractDataSourceRecordV1 {
+	return abstractDataSourceRecordV1{
+		DataSourceId:   this.dataSourceId,
+		Name:           this.name,
+		Interval:       this.interval,
+		Timeout:        this.timeout,
+		Retention:      this.retention,
+		Paused:         this.paused,
+		RetryPolicy:    this.retryPolicy,
+		CircuitBreaker: this.circuitBreaker,
+		ValueType:      this.valueType,
+		ValueFormat:    this.valueFormat,
+	}
+}
+
+func (this *AbstractDataSource) restoreV1(record abstractDataSourceRecordV1) {
+	this.dataSourceId = record.DataSourceId
+	this.name = record.Name
+	this.interval = record.Interval
+	this.timeout = record.Timeout
+	this.retention = record.Retention
+	this.paused = record.Paused
+	this.retryPolicy = record.RetryPolicy
+	this.circuitBreaker = record.CircuitBreaker
+	this.valueType = record.ValueType
+	this.valueFormat = record.ValueFormat
+}
+
+// GobDecode reads the legacy (version 0) encoding that has been used before the introduction of record envelopes.
+// There is no GobEncode() counterpart anymore because data sources only write versioned records now.
+func (this *AbstractDataSource) GobDecode(abstractDataSourceBytes []byte) error {
+	fmt.Println("   [AbstractDataSource]   GobDecode()")
+
+	buff := bytes.NewBuffer(abstractDataSourceBytes)
+	decoder := gob.NewDecoder(buff)
+
+	err := decoder.Decode(&this.dataSourceId)
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Decode(&this.name)
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Decode(&this.interval)
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Decode(&this.timeout)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// Every record that Kasperbrett persists (samples as well as data sources) is wrapped in an envelope:
+//
+//	| magic ("KBR") | version (uint16, big-endian) | type tag length (uint8) | type tag | payload |
+//
+// The version belongs to the record type and has to be increased whenever its payload changes in a way
+// that existing payloads can't be decoded anymore. Each record type keeps a decoder for every version it ever wrote.
+// Records without the magic prefix have been written before the envelope existed and are treated as version 0.
+const (
+	RecordMagic         = "KBR"
+	LegacyRecordVersion = uint16(0)
+)
+
+type Record struct {
+	Type    string
+	Version uint16
+	Payload []byte
+}
+
+func EncodeRecord(recordType string, version uint16, payload []byte) ([]byte, error) {
+	if len(recordType) == 0 || len(recordType) > 255 {
+		return nil, fmt.Errorf("Invalid record type: '%s'", recordType)
+	}
+	if version == LegacyRecordVersion {
+		return nil, errors.New("Version 0 is reserved for legacy records.")
+	}
+
+	buff := bytes.NewBuffer(make([]byte, 0, len(RecordMagic)+3+len(recordType)+len(payload)))
+	buff.WriteString(RecordMagic)
+	buff.WriteByte(byte(version >> 8))
+	buff.WriteByte(byte(version))
+	buff.WriteByte(byte(len(recordType)))
+	buff.WriteString(recordType)
+	buff.Write(payload)
+
+	return buff.Bytes(), nil
+}
+
+func DecodeRecord(recordBytes []byte) (*Record, error) {
+	if !bytes.HasPrefix(recordBytes, []byte(RecordMagic)) {
+		return &Record{Version: LegacyRecordVersion, Payload: recordBytes}, nil
+	}
+
+	headerLen := len(RecordMagic) + 3
+	if len(recordBytes) < headerLen {
+		return nil, errors.New("The record header is truncated.")
+	}
+
+	version := uint16(recordBytes[len(RecordMagic)])<<8 | uint16(recordBytes[len(RecordMagic)+1])
+	typeLen := int(recordBytes[len(RecordMagic)+2])
+	if len(recordBytes) < headerLen+typeLen {
+		return nil, errors.New("The record type tag is truncated.")
+	}
+
+	return &Record{
+		Type:    string(recordBytes[headerLen : headerLen+typeLen]),
+		Version: version,
+		Payload: recordBytes[headerLen+typeLen:],
+	}, nil
+}
+
+// RecordDecoders maps each version of a record type to the function that is able to read its payload.
+type RecordDecoders map[uint16]func(payload []byte) error
+
+func (decoders RecordDecoders) Decode(recordType string, record *Record) error {
+	if record.Version != LegacyRecordVersion && record.Type != recordType {
+		return fmt.Errorf("Expected a record of type '%s' but got '%s'.", recordType, record.Type)
+	}
+
+	decode, ok := decoders[record.Version]
+	if !ok {
+		return fmt.Errorf("Unsupported version %d of record type '%s'.", record.Version, recordType)
+	}
+
+	return decode(record.Payload)
+}
+
+// encodeGobPayload is a small helper for record types whose payload is a single gob encoded struct.
+func encodeGobPayload(recordType string, version uint16, payload interface{}) ([]byte, error) {
+	buff := new(bytes.Buffer)
+	err := gob.NewEncoder(buff).Encode(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncodeRecord(recordType, version, buff.Bytes())
+}
+
+func decodeGobPayload(payloadBytes []byte, payload interface{}) error {
+	return gob.NewDecoder(bytes.NewBuffer(payloadBytes)).Decode(payload)
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func GenerateKey(dataSourceId string, keySeparator string, timestamp time.Time) string {
+	// Identifies a sample across all data sources (e.g. for de-duplication and logging); Bolt keys are built by EncodeTimeKey().
+	// When it comes to time we want to be as precise as possible,
+	// because we need to avoid collisions in case a data source has a very short retrieval interval (< 1s).
+	// Therefore we use RFC3339Nano instead of simple RFC3339.
+	// Furthermore, keys should be based on reference time instead of local time. That's why we choose UTC.
+	return fmt.Sprintf("%s%s%s", dataSourceId, keySeparator, timestamp.UTC().Format(time.RFC3339Nano))
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func NewSample(value string, timestamp time.Time, dataSourceId string, err error) *Sample {
+	if len(dataSourceId) == 0 {
+		panic("A Sample must have a valid dataSourceId.")
+	}
+
+	if len(value) == 0 && err == nil {
+		panic("A Sample must have a valid value or a specified error.")
+	}
+
+	return &Sample{
+		Value:        value,
+		Timestamp:    timestamp,
+		DataSourceId: dataSourceId,
+		Err:          err,
+	}
+}
+
+type Sample struct {
+	Value        string
+	Timestamp    time.Time
+	DataSourceId string
+	Err          error
+	// Latency is the duration of the retrieval (see Retrieve())
+	Latency time.Duration
+	// Retries and BreakerOpen describe how the scheduled job retrieved the sample (see RetrieveAndDistribute()). They aren't persisted.
+	Retries     int
+	BreakerOpen bool
+	// ValueType and Number are set by ParseSampleValue(). Number is only meaningful for numeric value types.
+	ValueType string
+	Number    float64
+	// Meta holds details of the retrieval that are recorded next to the value (e.g. the status code of HTTP probes).
+	// It's set for failed retrievals too and nil for most data sources.
+	Meta map[string]string
+}
+
+// SampleMessage is the JSON representation of a sample that is broadcasted to the dashboard.
+type SampleMessage struct {
+	DataSourceId string   `json:"dataSourceId"`
+	Timestamp    int64    `json:"timestamp"` // number of milliseconds since Unix Epoch
+	Value        string   `json:"value"`
+	ValueType    string   `json:"valueType"`
+	Number       *float64 `json:"number"` // null if the value isn't numeric
+}
+
+func (this *Sample) JSON() string {
+	msg := SampleMessage{
+		DataSourceId: this.DataSourceId,
+		Timestamp:    this.Timestamp.UnixNano() / 1000000,
+		Value:        this.Value,
+		ValueType:    this.ValueType,
+		Number:       this.OptionalNumericValue(),
+	}
+
+	b, err := json.Marshal(&msg)
+	if err != nil {
+		// TODO: log this misbehaviour
+		return "{}"
+	}
+
+	return string(b)
+}
+
+func (this *Sample) Key() string {
+	return GenerateKey(this.DataSourceId, BoltSampleKeySeparator, this.Timestamp)
+}
+
+const (
+	SampleRecordType    = "Sample"
+	SampleRecordVersion = uint16(1)
+)
+
+type sampleRecordV1 struct {
+	Value        string
+	Timestamp    time.Time
+	DataSourceId string
+	Err          string
+	Latency      time.Duration
+	ValueType    string
+	Number       float64
+	Meta         map[string]string
+}
+
+func (this *Sample) GobEncode() ([]byte, error) {
+	errStr := ""
+	if this.Err != nil {
+		errStr = this.Err.Error()
+	}
+
+	return encodeGobPayload(SampleRecordType, SampleRecordVersion, sampleRecordV1{
+		Value:        this.Value,
+		Timestamp:    this.Timestamp,
+		DataSourceId: this.DataSourceId,
+		Err:          errStr,
+		Latency:      this.Latency,
+		ValueType:    this.ValueType,
+		Number:       this.Number,
+		Meta:         this.Meta,
+	})
+}
+
+func (this *Sample) GobDecode(sampleBytes []byte) error {
+	fmt.Println("   [Sample]   GobDecode()")
+
+	record, err := DecodeRecord(sampleBytes)
+	if err != nil {
+		return err
+	}
+
+	return RecordDecoders{
+		0: this.decodeV0,
+		1: this.decodeV1,
+	}.Decode(SampleRecordType, record)
+}
+
+func (this *Sample) decodeV1(payload []byte) error {
+	var record sampleRecordV1
+	err := decodeGobPayload(payload, &record)
+	if err != nil {
+		return err
+	}
+
+	this.Value = record.Value
+	this.Timestamp = record.Timestamp
+	this.DataSourceId = record.DataSourceId
+	this.setErr(record.Err)
+	this.Latency = record.Latency
+	this.ValueType = record.ValueType
+	this.Number = record.Number
+	this.Meta = record.Meta
+
+	return nil
+}
+
+func (this *Sample) decodeV0(payload []byte) error {
+	buff := bytes.NewBuffer(payload)
+	decoder := gob.NewDecoder(buff)
+
+	err := decoder.Decode(&this.Value)
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Decode(&this.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Decode(&this.DataSourceId)
+	if err != nil {
+		return err
+	}
+
+	var str string
 	err = decoder.Decode(&str)
 	if err != nil {
 		return err
 	}
 
-	if len(str) > 0 {
-		this.Err = errors.New(str)
-	} else {
-		this.Err = nil
+	this.setErr(str)
+
+	return nil
+}
+
+func (this *Sample) setErr(errStr string) {
+	if len(errStr) > 0 {
+		this.Err = errors.New(errStr)
+	} else {
+		this.Err = nil
+	}
+}
+
+// MergeSamples combines sample lists (e.g. stored and buffered samples) into a single list that is sorted by time.
+// Samples that occur in more than one list (same data source and timestamp) are only included once.
+func MergeSamples(sampleLists ...[]*Sample) []*Sample {
+	merged := []*Sample{}
+	seenKeys := map[string]bool{}
+
+	for _, samples := range sampleLists {
+		for _, sample := range samples {
+			key := sample.Key()
+			if !seenKeys[key] {
+				seenKeys[key] = true
+				merged = append(merged, sample)
+			}
+		}
+	}
+
+	sort.Sort(SamplesByTimestamp(merged))
+
+	return merged
+}
+
+// NumericValue returns the parsed number of a successfully retrieved sample (booleans are 1 or 0).
+// Samples without value type (see ParseSampleValue()) are interpreted as numbers if possible.
+func (this *Sample) NumericValue() (float64, bool) {
+	if this.Err != nil {
+		return 0, false
+	}
+
+	switch this.ValueType {
+	case ValueTypeFloat, ValueTypeInt, ValueTypeBool:
+		return this.Number, true
+	case ValueTypeText:
+		return 0, false
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(this.Value), 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, false
+	}
+
+	return number, true
+}
+
+// OptionalNumericValue is like NumericValue(), but returns nil if the value isn't numeric (e.g. for JSON responses).
+func (this *Sample) OptionalNumericValue() *float64 {
+	number, ok := this.NumericValue()
+	if !ok {
+		return nil
+	}
+
+	return &number
+}
+
+const (
+	ValueTypeFloat = "float"
+	ValueTypeInt   = "int"
+	ValueTypeBool  = "bool"
+	ValueTypeText  = "text"
+)
+
+// ValueFormat describes how a data source writes numbers. Separators that aren't set are taken from the locale.
+// Without locale the decimal separator is '.' and there is no group (thousands) separator.
+type ValueFormat struct {
+	Locale           string `json:"locale"`
+	DecimalSeparator string `json:"decimalSeparator"`
+	GroupSeparator   string `json:"groupSeparator"`
+}
+
+// ValueFormatLocales maps locales to their number format. Locales like 'de-AT' fall back to their language ('de').
+var ValueFormatLocales = map[string]ValueFormat{
+	"en":    {DecimalSeparator: ".", GroupSeparator: ","},
+	"de":    {DecimalSeparator: ",", GroupSeparator: "."},
+	"de-CH": {DecimalSeparator: ".", GroupSeparator: "'"},
+	"fr":    {DecimalSeparator: ",", GroupSeparator: " "},
+	"es":    {DecimalSeparator: ",", GroupSeparator: "."},
+	"it":    {DecimalSeparator: ",", GroupSeparator: "."},
+	"nl":    {DecimalSeparator: ",", GroupSeparator: "."},
+}
+
+// Resolve returns the format with both separators set.
+func (f ValueFormat) Resolve() (ValueFormat, error) {
+	resolved := ValueFormat{Locale: f.Locale, DecimalSeparator: ".", GroupSeparator: ""}
+
+	if len(f.Locale) > 0 {
+		localeFormat, ok := ValueFormatLocales[f.Locale]
+		if !ok {
+			localeFormat, ok = ValueFormatLocales[strings.SplitN(f.Locale, "-", 2)[0]]
+		}
+		if !ok {
+			return ValueFormat{}, fmt.Errorf("Unsupported locale: %s", f.Locale)
+		}
+
+		resolved.DecimalSeparator = localeFormat.DecimalSeparator
+		resolved.GroupSeparator = localeFormat.GroupSeparator
+	}
+
+	if len(f.DecimalSeparator) > 0 {
+		resolved.DecimalSeparator = f.DecimalSeparator
+	}
+	if len(f.GroupSeparator) > 0 {
+		resolved.GroupSeparator = f.GroupSeparator
+	}
+
+	if resolved.DecimalSeparator == resolved.GroupSeparator {
+		return ValueFormat{}, errors.New("The decimal separator and the group separator must differ.")
+	}
+
+	return resolved, nil
+}
+
+// ValidateValueType accepts an empty value type for data sources that have been created before the introduction of value types.
+func ValidateValueType(valueType string, valueFormat ValueFormat) error {
+	switch valueType {
+	case "", ValueTypeFloat, ValueTypeInt, ValueTypeBool, ValueTypeText:
+	default:
+		return fmt.Errorf("Unsupported value type: %s", valueType)
+	}
+
+	_, err := valueFormat.Resolve()
+	return err
+}
+
+// ParseSampleValue parses the value of a successfully retrieved sample according to the value type
+// and replaces it by its canonical representation (e.g. '1.234,5' -> '1234.5' for locale 'de').
+// Samples without value type are left untouched.
+func ParseSampleValue(sample *Sample, valueType string, valueFormat ValueFormat) error {
+	if len(valueType) == 0 {
+		return nil
+	}
+
+	value, number, err := ParseValue(sample.Value, valueType, valueFormat)
+	if err != nil {
+		return err
+	}
+
+	sample.Value = value
+	sample.ValueType = valueType
+	sample.Number = number
+	return nil
+}
+
+// ParseValue returns the canonical representation of the raw value and its number (if the value type is numeric).
+func ParseValue(rawValue string, valueType string, valueFormat ValueFormat) (string, float64, error) {
+	parseErr := fmt.Errorf("Couldn't parse '%s' as %s.", rawValue, valueType)
+	value := strings.TrimSpace(rawValue)
+
+	switch valueType {
+	case ValueTypeFloat:
+		number, err := strconv.ParseFloat(normalizeNumber(value, valueFormat), 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return "", 0, parseErr
+		}
+		return strconv.FormatFloat(number, 'f', -1, 64), number, nil
+	case ValueTypeInt:
+		number, err := strconv.ParseInt(normalizeNumber(value, valueFormat), 10, 64)
+		if err != nil {
+			return "", 0, parseErr
+		}
+		return strconv.FormatInt(number, 10), float64(number), nil
+	case ValueTypeBool:
+		switch strings.ToLower(value) {
+		case "true", "yes", "on", "1":
+			return "true", 1, nil
+		case "false", "no", "off", "0":
+			return "false", 0, nil
+		}
+		return "", 0, parseErr
+	case ValueTypeText:
+		if len(value) == 0 {
+			return "", 0, parseErr
+		}
+		return value, 0, nil
+	default:
+		return "", 0, fmt.Errorf("Unsupported value type: %s", valueType)
+	}
+}
+
+// normalizeNumber converts a number of the given format into the format strconv understands.
+// The format has to be valid (see ValidateValueType()).
+func normalizeNumber(value string, valueFormat ValueFormat) string {
+	valueFormat, _ = valueFormat.Resolve()
+
+	if valueFormat.GroupSeparator == " " {
+		// spaces as group separator are often written as (narrow) no-break spaces
+		value = strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "").Replace(value)
+	} else if len(valueFormat.GroupSeparator) > 0 {
+		value = strings.Replace(value, valueFormat.GroupSeparator, "", -1)
+	}
+
+	return strings.Replace(value, valueFormat.DecimalSeparator, ".", -1)
+}
+
+type SamplesByTimestamp []*Sample
+
+func (samples SamplesByTimestamp) Len() int {
+	return len(samples)
+}
+
+func (samples SamplesByTimestamp) Less(i, j int) bool {
+	return samples[i].Timestamp.Before(samples[j].Timestamp)
+}
+
+func (samples SamplesByTimestamp) Swap(i, j int) {
+	samples[i], samples[j] = samples[j], samples[i]
+}
+
+func (this *Sample) String() string {
+	if len(this.Value) > 0 {
+		return fmt.Sprintf("[%s] -> %s", this.Timestamp.UTC().Format(time.RFC1123Z), this.Value)
+	} else {
+		return fmt.Sprintf("[%s] -> %s", this.Timestamp.UTC().Format(time.RFC1123Z), this.Err)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+const (
+	AggAvg   = "avg"
+	AggMin   = "min"
+	AggMax   = "max"
+	AggSum   = "sum"
+	AggCount = "count"
+	AggLast  = "last"
+	AggP95   = "p95"
+)
+
+// An aggregation function reduces the (time ordered, non-empty) values of a bucket to a single value.
+var aggregationFunctions = map[string]func(values []float64) float64{
+	AggAvg: func(values []float64) float64 {
+		sum := 0.0
+		for _, value := range values {
+			sum += value
+		}
+		return sum / float64(len(values))
+	},
+	AggMin: func(values []float64) float64 {
+		min := values[0]
+		for _, value := range values[1:] {
+			min = math.Min(min, value)
+		}
+		return min
+	},
+	AggMax: func(values []float64) float64 {
+		max := values[0]
+		for _, value := range values[1:] {
+			max = math.Max(max, value)
+		}
+		return max
+	},
+	AggSum: func(values []float64) float64 {
+		sum := 0.0
+		for _, value := range values {
+			sum += value
+		}
+		return sum
+	},
+	AggCount: func(values []float64) float64 {
+		return float64(len(values))
+	},
+	AggLast: func(values []float64) float64 {
+		return values[len(values)-1]
+	},
+	AggP95: func(values []float64) float64 {
+		// nearest-rank method
+		sorted := append([]float64{}, values...)
+		sort.Float64s(sorted)
+		return sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+	},
+}
+
+type AggregatedPoint struct {
+	Timestamp time.Time // start of the bucket
+	Value     float64
+	Count     int
+}
+
+type AggregatedSeries struct {
+	Step              time.Duration
+	Aggregation       string
+	Resolution        string
+	Points            []AggregatedPoint
+	SkippedErrors     int
+	SkippedNonNumeric int
+}
+
+// AggregateSamples groups the numeric values of the given (time ordered) samples into buckets of the size `step`
+// and reduces every bucket with the given aggregation. Buckets are aligned to multiples of `step` since the zero time,
+// so consecutive queries produce the same buckets. Empty buckets are omitted.
+// Samples with an error or a non-numeric value are skipped and counted.
+func AggregateSamples(samples []*Sample, step time.Duration, aggregation string) *AggregatedSeries {
+	series := &AggregatedSeries{Step: step, Aggregation: aggregation, Resolution: "raw", Points: []AggregatedPoint{}}
+	aggregate := aggregationFunctions[aggregation]
+
+	var bucketStart time.Time
+	var bucketValues []float64
+	flush := func() {
+		if len(bucketValues) > 0 {
+			series.Points = append(series.Points, AggregatedPoint{Timestamp: bucketStart, Value: aggregate(bucketValues), Count: len(bucketValues)})
+		}
+		bucketValues = nil
+	}
+
+	for _, sample := range samples {
+		if sample.Err != nil {
+			series.SkippedErrors++
+			continue
+		}
+
+		value, ok := sample.NumericValue()
+		if !ok {
+			series.SkippedNonNumeric++
+			continue
+		}
+
+		sampleBucketStart := sample.Timestamp.Truncate(step)
+		if !sampleBucketStart.Equal(bucketStart) {
+			flush()
+			bucketStart = sampleBucketStart
+		}
+		bucketValues = append(bucketValues, value)
+	}
+	flush()
+
+	return series
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+type RollupResolution struct {
+	Name                 string
+	Duration             time.Duration
+	DefaultRetentionDays int
+}
+
+// RollupResolutions are ordered from fine to coarse.
+var RollupResolutions = []RollupResolution{
+	{Name: "1m", Duration: time.Minute, DefaultRetentionDays: 30},
+	{Name: "1h", Duration: time.Hour, DefaultRetentionDays: 365},
+	{Name: "1d", Duration: 24 * time.Hour, DefaultRetentionDays: 0},
+}
+
+func GetRollupResolution(name string) (RollupResolution, error) {
+	for _, resolution := range RollupResolutions {
+		if resolution.Name == name {
+			return resolution, nil
+		}
+	}
+
+	return RollupResolution{}, fmt.Errorf("Unknown rollup resolution '%s'. Valid resolutions are 1m, 1h and 1d.", name)
+}
+
+// SelectRollupResolution picks the coarsest rollup resolution that can answer an aggregated query:
+// the step has to be a multiple of the resolution and the rollups must not have been pruned before `from`.
+// Aggregations like p95 or last can't be computed from rollups and always need the raw samples.
+func SelectRollupResolution(step time.Duration, aggregation string, from time.Time, rollupRetention func(RollupResolution) time.Duration, now time.Time) (RollupResolution, bool) {
+	switch aggregation {
+	case AggAvg, AggMin, AggMax, AggSum, AggCount:
+	default:
+		return RollupResolution{}, false
+	}
+
+	for i := len(RollupResolutions) - 1; i >= 0; i-- {
+		resolution := RollupResolutions[i]
+		retention := rollupRetention(resolution)
+		if step%resolution.Duration == 0 && (retention == 0 || !from.Before(now.Add(-1*retention))) {
+			return resolution, true
+		}
+	}
+
+	return RollupResolution{}, false
+}
+
+// updateRollups adds the value of the sample to its rollups of all resolutions.
+func updateRollups(rollupsBucket *bolt.Bucket, sample *Sample) error {
+	value, ok := sample.NumericValue()
+	if !ok {
+		return nil
+	}
+
+	for _, resolution := range RollupResolutions {
+		resolutionBucket, err := createNestedBucketIfNotExists(rollupsBucket, sample.DataSourceId, resolution.Name)
+		if err != nil {
+			return err
+		}
+
+		bucketStart := sample.Timestamp.Truncate(resolution.Duration)
+		key := EncodeTimeKey(bucketStart)
+
+		rollup := &Rollup{Timestamp: bucketStart}
+		if rollupBytes := resolutionBucket.Get(key); rollupBytes != nil {
+			err := rollup.GobDecode(rollupBytes)
+			if err != nil {
+				return err
+			}
+		}
+		rollup.Add(value)
+
+		rollupBytes, err := rollup.GobEncode()
+		if err != nil {
+			return err
+		}
+
+		err = resolutionBucket.Put(key, rollupBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	RollupRecordType    = "Rollup"
+	RollupRecordVersion = uint16(1)
+)
+
+// Rollup summarizes the numeric sample values of a data source within [Timestamp, Timestamp + resolution).
+type Rollup struct {
+	Timestamp time.Time
+	Min       float64
+	Max       float64
+	Sum       float64
+	Count     int
+}
+
+func (this *Rollup) Add(value float64) {
+	this.Merge(&Rollup{Min: value, Max: value, Sum: value, Count: 1})
+}
+
+func (this *Rollup) Merge(other *Rollup) {
+	if other.Count == 0 {
+		return
+	}
+
+	if this.Count == 0 {
+		this.Min = other.Min
+		this.Max = other.Max
+	} else {
+		this.Min = math.Min(this.Min, other.Min)
+		this.Max = math.Max(this.Max, other.Max)
+	}
+	this.Sum += other.Sum
+	this.Count += other.Count
+}
+
+func (this *Rollup) Value(aggregation string) float64 {
+	switch aggregation {
+	case AggMin:
+		return this.Min
+	case AggMax:
+		return this.Max
+	case AggSum:
+		return this.Sum
+	case AggCount:
+		return float64(this.Count)
+	default:
+		return this.Sum / float64(this.Count)
+	}
+}
+
+// rollupRecordV1 has the same fields as Rollup but not its methods, which keeps gob from calling Rollup.GobEncode() recursively.
+type rollupRecordV1 Rollup
+
+func (this *Rollup) GobEncode() ([]byte, error) {
+	return encodeGobPayload(RollupRecordType, RollupRecordVersion, (*rollupRecordV1)(this))
+}
+
+func (this *Rollup) GobDecode(rollupBytes []byte) error {
+	record, err := DecodeRecord(rollupBytes)
+	if err != nil {
+		return err
+	}
+
+	return RecordDecoders{
+		1: func(payload []byte) error {
+			return decodeGobPayload(payload, (*rollupRecordV1)(this))
+		},
+	}.Decode(RollupRecordType, record)
+}
+
+// AggregateRollups is the rollup based counterpart of AggregateSamples(). Samples that haven't been
+// rolled up yet (e.g. buffered samples) are taken into account as well.
+func AggregateRollups(rollups []*Rollup, samples []*Sample, resolution RollupResolution, step time.Duration, aggregation string) *AggregatedSeries {
+	series := &AggregatedSeries{Step: step, Aggregation: aggregation, Resolution: resolution.Name, Points: []AggregatedPoint{}}
+
+	mergedRollups := map[int64]*Rollup{}
+	merge := func(rollup *Rollup) {
+		bucketStart := rollup.Timestamp.Truncate(step)
+		mergedRollup, ok := mergedRollups[bucketStart.UnixNano()]
+		if !ok {
+			mergedRollup = &Rollup{Timestamp: bucketStart}
+			mergedRollups[bucketStart.UnixNano()] = mergedRollup
+		}
+		mergedRollup.Merge(rollup)
+	}
+
+	for _, rollup := range rollups {
+		merge(rollup)
+	}
+
+	for _, sample := range samples {
+		if sample.Err != nil {
+			series.SkippedErrors++
+			continue
+		}
+
+		value, ok := sample.NumericValue()
+		if !ok {
+			series.SkippedNonNumeric++
+			continue
+		}
+
+		sampleRollup := &Rollup{Timestamp: sample.Timestamp}
+		sampleRollup.Add(value)
+		merge(sampleRollup)
+	}
+
+	for _, rollup := range mergedRollups {
+		if rollup.Count > 0 {
+			series.Points = append(series.Points, AggregatedPoint{Timestamp: rollup.Timestamp, Value: rollup.Value(aggregation), Count: rollup.Count})
+		}
+	}
+	sort.Sort(AggregatedPointsByTimestamp(series.Points))
+
+	return series
+}
+
+// RollupRange returns the range [rollupsFrom, rollupsTo) covered by the rollups of the given resolution that lie entirely within [from, to].
+// rollupsFrom isn't before rollupsTo, even if there isn't a single complete rollup within [from, to].
+func RollupRange(resolution RollupResolution, from time.Time, to time.Time) (time.Time, time.Time) {
+	rollupsFrom := from.Truncate(resolution.Duration)
+	if rollupsFrom.Before(from) {
+		rollupsFrom = rollupsFrom.Add(resolution.Duration)
+	}
+
+	// `to` is inclusive
+	rollupsTo := to.Add(time.Nanosecond).Truncate(resolution.Duration)
+	if rollupsTo.Before(rollupsFrom) {
+		rollupsTo = rollupsFrom
+	}
+
+	return rollupsFrom, rollupsTo
+}
+
+// AggregateStoredRollups answers an aggregated query with the stored rollups of the data source. The partial rollup buckets
+// at the edges of [from, to] are computed from the raw samples instead, so that the result is the same as the one of
+// AggregateSamples() for the raw samples of [from, to]. Samples that haven't been persisted yet have to be passed as unpersistedSamples,
+// those that have been persisted in the meantime are skipped (see DataStore.GetRollups()), so that no sample is counted twice.
+func AggregateStoredRollups(dataStore DataStore, dataSourceId string, from time.Time, to time.Time, unpersistedSamples []*Sample, resolution RollupResolution, step time.Duration, aggregation string) (*AggregatedSeries, error) {
+	rollupsFrom, rollupsTo := RollupRange(resolution, from, to)
+
+	rollups, unpersistedSamples, err := dataStore.GetRollups(dataSourceId, resolution, from, to, unpersistedSamples)
+	if err != nil {
+		return nil, err
+	}
+
+	edgeSamples := [][]*Sample{unpersistedSamples}
+	if rollupsFrom.Equal(rollupsTo) {
+		samples, err := dataStore.GetSamples(dataSourceId, from, to)
+		if err != nil {
+			return nil, err
+		}
+		edgeSamples = append(edgeSamples, samples)
+	} else {
+		if from.Before(rollupsFrom) {
+			samples, err := dataStore.GetSamples(dataSourceId, from, rollupsFrom.Add(-1*time.Nanosecond))
+			if err != nil {
+				return nil, err
+			}
+			edgeSamples = append(edgeSamples, samples)
+		}
+
+		if !to.Before(rollupsTo) {
+			samples, err := dataStore.GetSamples(dataSourceId, rollupsTo, to)
+			if err != nil {
+				return nil, err
+			}
+			edgeSamples = append(edgeSamples, samples)
+		}
+	}
+
+	return AggregateRollups(rollups, MergeSamples(edgeSamples...), resolution, step, aggregation), nil
+}
+
+type AggregatedPointsByTimestamp []AggregatedPoint
+
+func (points AggregatedPointsByTimestamp) Len() int {
+	return len(points)
+}
+
+func (points AggregatedPointsByTimestamp) Less(i, j int) bool {
+	return points[i].Timestamp.Before(points[j].Timestamp)
+}
+
+func (points AggregatedPointsByTimestamp) Swap(i, j int) {
+	points[i], points[j] = points[j], points[i]
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func init() {
+	RegisterDataSourceType(&DataSourceType{
+		Name: DsUrlScraper,
+		New: func(abstractDataSource AbstractDataSource, typeSettings map[string]string) (DataSource, error) {
+			return NewUrlScraper(abstractDataSource, typeSettings["url"], typeSettings["cssPath"], typeSettings["transformations"], typeSettings["transformationScript"])
+		},
+		ValidateTypeSettings: func(typeSettings map[string]string) error {
+			if len(typeSettings["url"]) == 0 {
+				return errors.New("Please provide a valid URL.")
+			}
+			if len(typeSettings["cssPath"]) == 0 {
+				return errors.New("Please provide a valid CSS path.")
+			}
+			_, err := ParseTransformationPipeline(typeSettings["transformations"])
+			return err
+		},
+		ExportTypeSettings: func(dataSource DataSource) map[string]string {
+			urlScraperDs := dataSource.(*UrlScraper)
+			return map[string]string{
+				"url":                  urlScraperDs.url,
+				"cssPath":              urlScraperDs.cssPath,
+				"transformations":      urlScraperDs.transformations,
+				"transformationScript": urlScraperDs.transformationScript,
+			}
+		},
+		Decode: func(dataSourceBytes []byte) (DataSource, error) {
+			urlScraperDs := &UrlScraper{}
+			err := urlScraperDs.GobDecode(dataSourceBytes)
+			if err != nil {
+				return nil, err
+			}
+			return urlScraperDs, nil
+		},
+	})
+}
+
+// NewUrlScraper expects the transformations as JSON (see ParseTransformationPipeline()).
+func NewUrlScraper(abstractDataSource AbstractDataSource, url string, cssPath string, transformations string, transformationScript string) (*UrlScraper, error) {
+	transformationPipeline, err := ParseTransformationPipeline(transformations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UrlScraper{
+		AbstractDataSource:     abstractDataSource,
+		url:                    url,
+		cssPath:                cssPath,
+		transformations:        transformations,
+		transformationPipeline: transformationPipeline,
+		transformationScript:   transformationScript,
+	}, nil
+}
+
+type UrlScraper struct {
+	AbstractDataSource
+	url                    string
+	cssPath                string
+	transformations        string
+	transformationPipeline TransformationPipeline
+	transformationScript   string
+}
+
+func (this *UrlScraper) Retrieve(ctx context.Context, sampleChan chan *Sample) {
+	t := time.Now()
+	req, err := http.NewRequest("GET", this.url, nil)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	_, body, err := DoTracedRequest(ctx, req)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	selection := doc.Find(this.cssPath)
+	TraceDomSelection(ctx, this.cssPath, selection)
+
+	value := selection.Text()
+	GetRetrievalTrace(ctx).SetRawValue(value)
+	if len(value) == 0 {
+		sampleChan <- NewSample("", t, this.dataSourceId, errors.New("The specified CSS path is invalid or doesn't match any DOM nodes."))
+		return
+	}
+
+	value, err = ApplyTransformations(ctx, value, this.transformationPipeline, this.transformationScript)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	sampleChan <- NewSample(value, t, this.dataSourceId, nil)
+}
+
+func (this *UrlScraper) Type() string {
+	return DsUrlScraper
+}
+
+const UrlScraperRecordVersion = uint16(1)
+
+type urlScraperRecordV1 struct {
+	AbstractDataSource   abstractDataSourceRecordV1
+	Url                  string
+	CssPath              string
+	TransformationScript string
+	Transformations      string
+}
+
+func (this *UrlScraper) GobEncode() ([]byte, error) {
+	fmt.Println("   [UrlScraper]   GobEncode()")
+
+	return encodeGobPayload(DsUrlScraper, UrlScraperRecordVersion, urlScraperRecordV1{
+		AbstractDataSource:   this.AbstractDataSource.recordV1(),
+		Url:                  this.url,
+		CssPath:              this.cssPath,
+		TransformationScript: this.transformationScript,
+		Transformations:      this.transformations,
+	})
+}
+
+func (this *UrlScraper) GobDecode(urlScraperBytes []byte) error {
+	fmt.Println("   [UrlScraper]   GobDecode()")
+
+	record, err := DecodeRecord(urlScraperBytes)
+	if err != nil {
+		return err
+	}
+
+	return RecordDecoders{
+		0: this.decodeV0,
+		1: this.decodeV1,
+	}.Decode(DsUrlScraper, record)
+}
+
+func (this *UrlScraper) decodeV1(payload []byte) error {
+	var record urlScraperRecordV1
+	err := decodeGobPayload(payload, &record)
+	if err != nil {
+		return err
+	}
+
+	this.AbstractDataSource.restoreV1(record.AbstractDataSource)
+	this.url = record.Url
+	this.cssPath = record.CssPath
+	this.transformationScript = record.TransformationScript
+	this.transformations = record.Transformations
+	this.transformationPipeline, err = ParseTransformationPipeline(record.Transformations)
+
+	return err
+}
+
+func (this *UrlScraper) decodeV0(payload []byte) error {
+	buff := bytes.NewBuffer(payload)
+	decoder := gob.NewDecoder(buff)
+
+	err := decoder.Decode(&this.AbstractDataSource)
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Decode(&this.url)
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Decode(&this.cssPath)
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Decode(&this.transformationScript)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func init() {
+	RegisterDataSourceType(&DataSourceType{
+		Name: DsJsonApi,
+		New: func(abstractDataSource AbstractDataSource, typeSettings map[string]string) (DataSource, error) {
+			return NewJsonApi(
+				abstractDataSource, typeSettings["url"], typeSettings["method"], typeSettings["body"],
+				typeSettings["contentType"], typeSettings["jsonPath"], typeSettings["transformations"], typeSettings["transformationScript"],
+			)
+		},
+		ValidateTypeSettings: func(typeSettings map[string]string) error {
+			err := ValidateHttpRequestTypeSettings(typeSettings)
+			if err != nil {
+				return err
+			}
+			if len(typeSettings["jsonPath"]) == 0 {
+				return errors.New("Please provide a valid JSONPath expression.")
+			}
+			_, err = jsonpath.Compile(typeSettings["jsonPath"])
+			if err != nil {
+				return errors.New("Please provide a valid JSONPath expression: " + err.Error())
+			}
+			_, err = ParseTransformationPipeline(typeSettings["transformations"])
+			return err
+		},
+		ExportTypeSettings: func(dataSource DataSource) map[string]string {
+			jsonApiDs := dataSource.(*JsonApi)
+			return map[string]string{
+				"url":                  jsonApiDs.url,
+				"method":               jsonApiDs.method,
+				"body":                 jsonApiDs.body,
+				"contentType":          jsonApiDs.contentType,
+				"jsonPath":             jsonApiDs.jsonPath,
+				"transformations":      jsonApiDs.transformations,
+				"transformationScript": jsonApiDs.transformationScript,
+			}
+		},
+		Decode: func(dataSourceBytes []byte) (DataSource, error) {
+			jsonApiDs := &JsonApi{}
+			err := jsonApiDs.GobDecode(dataSourceBytes)
+			if err != nil {
+				return nil, err
+			}
+			return jsonApiDs, nil
+		},
+	})
+}
+
+// NewJsonApi expects the transformations as JSON (see ParseTransformationPipeline()).
+func NewJsonApi(abstractDataSource AbstractDataSource, url string, method string, body string, contentType string, jsonPath string, transformations string, transformationScript string) (*JsonApi, error) {
+	transformationPipeline, err := ParseTransformationPipeline(transformations)
+	if err != nil {
+		return nil, err
+	}
+
+	method = strings.ToUpper(method)
+	if len(method) == 0 {
+		method = "GET"
+	}
+	if len(contentType) == 0 && method == "POST" {
+		contentType = "application/json"
+	}
+
+	return &JsonApi{
+		AbstractDataSource:     abstractDataSource,
+		url:                    url,
+		method:                 method,
+		body:                   body,
+		contentType:            contentType,
+		jsonPath:               jsonPath,
+		transformations:        transformations,
+		transformationPipeline: transformationPipeline,
+		transformationScript:   transformationScript,
+	}, nil
+}
+
+// JsonApi requests a JSON document via HTTP GET (or POST) and extracts a single value with a JSONPath expression.
+type JsonApi struct {
+	AbstractDataSource
+	url                    string
+	method                 string
+	body                   string
+	contentType            string
+	jsonPath               string
+	transformations        string
+	transformationPipeline TransformationPipeline
+	transformationScript   string
+}
+
+func (this *JsonApi) Retrieve(ctx context.Context, sampleChan chan *Sample) {
+	t := time.Now()
+
+	var bodyReader io.Reader
+	if len(this.body) > 0 {
+		bodyReader = strings.NewReader(this.body)
+	}
+
+	req, err := http.NewRequest(this.method, this.url, bodyReader)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(this.contentType) > 0 {
+		req.Header.Set("Content-Type", this.contentType)
+	}
+
+	res, body, err := DoTracedRequest(ctx, req)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		sampleChan <- NewSample("", t, this.dataSourceId, fmt.Errorf("The JSON API responded with status %s.", res.Status))
+		return
+	}
+
+	var document interface{}
+	err = json.Unmarshal(body, &document)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, errors.New("The response isn't a valid JSON document: "+err.Error()))
+		return
+	}
+
+	value, err := ExtractJsonValue(document, this.jsonPath)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+	GetRetrievalTrace(ctx).SetRawValue(value)
+
+	value, err = ApplyTransformations(ctx, value, this.transformationPipeline, this.transformationScript)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	sampleChan <- NewSample(value, t, this.dataSourceId, nil)
+}
+
+func (this *JsonApi) Type() string {
+	return DsJsonApi
+}
+
+const JsonApiRecordVersion = uint16(1)
+
+type jsonApiRecordV1 struct {
+	AbstractDataSource   abstractDataSourceRecordV1
+	Url                  string
+	Method               string
+	Body                 string
+	ContentType          string
+	JsonPath             string
+	TransformationScript string
+	Transformations      string
+}
+
+func (this *JsonApi) GobEncode() ([]byte, error) {
+	return encodeGobPayload(DsJsonApi, JsonApiRecordVersion, jsonApiRecordV1{
+		AbstractDataSource:   this.AbstractDataSource.recordV1(),
+		Url:                  this.url,
+		Method:               this.method,
+		Body:                 this.body,
+		ContentType:          this.contentType,
+		JsonPath:             this.jsonPath,
+		TransformationScript: this.transformationScript,
+		Transformations:      this.transformations,
+	})
+}
+
+func (this *JsonApi) GobDecode(jsonApiBytes []byte) error {
+	record, err := DecodeRecord(jsonApiBytes)
+	if err != nil {
+		return err
+	}
+
+	return RecordDecoders{
+		1: this.decodeV1,
+	}.Decode(DsJsonApi, record)
+}
+
+func (this *JsonApi) decodeV1(payload []byte) error {
+	var record jsonApiRecordV1
+	err := decodeGobPayload(payload, &record)
+	if err != nil {
+		return err
+	}
+
+	this.AbstractDataSource.restoreV1(record.AbstractDataSource)
+	this.url = record.Url
+	this.method = record.Method
+	this.body = record.Body
+	this.contentType = record.ContentType
+	this.jsonPath = record.JsonPath
+	this.transformationScript = record.TransformationScript
+	this.transformations = record.Transformations
+	this.transformationPipeline, err = ParseTransformationPipeline(record.Transformations)
+
+	return err
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// ExtractJsonValue evaluates the JSONPath expression against the decoded JSON document.
+// The expression has to match exactly one scalar value, which is returned in its textual representation.
+func ExtractJsonValue(document interface{}, jsonPath string) (string, error) {
+	result, err := jsonpath.JsonPathLookup(document, jsonPath)
+	if err != nil {
+		return "", errors.New("The specified JSONPath expression doesn't match: " + err.Error())
+	}
+
+	// filters and wildcards always produce a list
+	if list, ok := result.([]interface{}); ok && len(list) == 1 {
+		result = list[0]
+	}
+
+	switch v := result.(type) {
+	case nil:
+		return "", errors.New("The specified JSONPath expression matches a null value.")
+	case string:
+		if len(v) == 0 {
+			return "", errors.New("The specified JSONPath expression matches an empty string.")
+		}
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		return "", fmt.Errorf("The specified JSONPath expression matches %d values instead of exactly one.", len(v))
+	default:
+		return "", errors.New("The specified JSONPath expression matches an object instead of a single value.")
+	}
+}
+
+// ValidateHttpRequestTypeSettings checks the type settings `url`, `method` (GET or POST), and `body` of data sources
+// that request documents via HTTP.
+func ValidateHttpRequestTypeSettings(typeSettings map[string]string) error {
+	if len(typeSettings["url"]) == 0 {
+		return errors.New("Please provide a valid URL.")
+	}
+	method := strings.ToUpper(typeSettings["method"])
+	if len(method) > 0 && method != "GET" && method != "POST" {
+		return errors.New("Please provide a valid HTTP method (GET or POST).")
+	}
+	if len(typeSettings["body"]) > 0 && method != "POST" {
+		return errors.New("A request body is only supported for POST requests.")
+	}
+	return nil
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+const (
+	HttpRegexModeCapture = "capture"
+	HttpRegexModeCount   = "count"
+)
+
+func init() {
+	RegisterDataSourceType(&DataSourceType{
+		Name: DsHttpRegex,
+		New: func(abstractDataSource AbstractDataSource, typeSettings map[string]string) (DataSource, error) {
+			return NewHttpRegex(
+				abstractDataSource, typeSettings["url"], typeSettings["pattern"], typeSettings["group"], typeSettings["mode"],
+				typeSettings["transformations"], typeSettings["transformationScript"],
+			)
+		},
+		ValidateTypeSettings: func(typeSettings map[string]string) error {
+			if len(typeSettings["url"]) == 0 {
+				return errors.New("Please provide a valid URL.")
+			}
+			if len(typeSettings["pattern"]) == 0 {
+				return errors.New("Please provide a regular expression.")
+			}
+			pattern, err := regexp.Compile(typeSettings["pattern"])
+			if err != nil {
+				return errors.New("Please provide a valid regular expression: " + err.Error())
+			}
+			switch typeSettings["mode"] {
+			case "", HttpRegexModeCapture:
+				_, err = ResolveRegexGroup(pattern, typeSettings["group"])
+				if err != nil {
+					return err
+				}
+			case HttpRegexModeCount:
+				if len(typeSettings["group"]) > 0 {
+					return errors.New("A capture group is only supported in capture mode.")
+				}
+			default:
+				return fmt.Errorf("Please provide a valid mode (%s or %s).", HttpRegexModeCapture, HttpRegexModeCount)
+			}
+			_, err = ParseTransformationPipeline(typeSettings["transformations"])
+			return err
+		},
+		ExportTypeSettings: func(dataSource DataSource) map[string]string {
+			httpRegexDs := dataSource.(*HttpRegex)
+			return map[string]string{
+				"url":                  httpRegexDs.url,
+				"pattern":              httpRegexDs.pattern,
+				"group":                httpRegexDs.group,
+				"mode":                 httpRegexDs.mode,
+				"transformations":      httpRegexDs.transformations,
+				"transformationScript": httpRegexDs.transformationScript,
+			}
+		},
+		Decode: func(dataSourceBytes []byte) (DataSource, error) {
+			httpRegexDs := &HttpRegex{}
+			err := httpRegexDs.GobDecode(dataSourceBytes)
+			if err != nil {
+				return nil, err
+			}
+			return httpRegexDs, nil
+		},
+	})
+}
+
+// NewHttpRegex expects a valid pattern and group (see ValidateTypeSettings) and the transformations as JSON (see ParseTransformationPipeline()).
+func NewHttpRegex(abstractDataSource AbstractDataSource, url string, pattern string, group string, mode string, transformations string, transformationScript string) (*HttpRegex, error) {
+	if len(mode) == 0 {
+		mode = HttpRegexModeCapture
+	}
+
+	httpRegex := &HttpRegex{
+		AbstractDataSource:   abstractDataSource,
+		url:                  url,
+		pattern:              pattern,
+		group:                group,
+		mode:                 mode,
+		transformations:      transformations,
+		transformationScript: transformationScript,
+	}
+
+	err := httpRegex.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return httpRegex, nil
+}
+
+// HttpRegex requests a URL via HTTP GET and extracts a value from the (plain text) response with a regular expression.
+// In capture mode the value is a capture group of the first match, in count mode it's the number of matches.
+// Flags like multi-line mode are part of the pattern, e.g. (?m)^Total Accesses: (\d+)$
+type HttpRegex struct {
+	AbstractDataSource
+	url                    string
+	pattern                string
+	group                  string
+	mode                   string
+	transformations        string
+	transformationScript   string
+	compiledPattern        *regexp.Regexp
+	groupIndex             int
+	transformationPipeline TransformationPipeline
+}
+
+// compile prepares the pattern, group, and transformations after the data source has been created or decoded.
+func (this *HttpRegex) compile() (err error) {
+	this.compiledPattern, err = regexp.Compile(this.pattern)
+	if err != nil {
+		return err
+	}
+
+	if this.mode == HttpRegexModeCapture {
+		this.groupIndex, err = ResolveRegexGroup(this.compiledPattern, this.group)
+		if err != nil {
+			return err
+		}
+	}
+
+	this.transformationPipeline, err = ParseTransformationPipeline(this.transformations)
+	return err
+}
+
+func (this *HttpRegex) Retrieve(ctx context.Context, sampleChan chan *Sample) {
+	t := time.Now()
+	req, err := http.NewRequest("GET", this.url, nil)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	res, body, err := DoTracedRequest(ctx, req)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		sampleChan <- NewSample("", t, this.dataSourceId, fmt.Errorf("The server responded with status %s.", res.Status))
+		return
+	}
+
+	value, err := this.extract(string(body))
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+	GetRetrievalTrace(ctx).SetRawValue(value)
+
+	value, err = ApplyTransformations(ctx, value, this.transformationPipeline, this.transformationScript)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	sampleChan <- NewSample(value, t, this.dataSourceId, nil)
+}
+
+func (this *HttpRegex) extract(body string) (string, error) {
+	if this.mode == HttpRegexModeCount {
+		return strconv.Itoa(len(this.compiledPattern.FindAllStringIndex(body, -1))), nil
+	}
+
+	match := this.compiledPattern.FindStringSubmatch(body)
+	if match == nil {
+		return "", errors.New("The specified regular expression doesn't match the response.")
+	}
+	if len(match[this.groupIndex]) == 0 {
+		return "", errors.New("The specified capture group is empty.")
+	}
+
+	return match[this.groupIndex], nil
+}
+
+func (this *HttpRegex) Type() string {
+	return DsHttpRegex
+}
+
+const HttpRegexRecordVersion = uint16(1)
+
+type httpRegexRecordV1 struct {
+	AbstractDataSource   abstractDataSourceRecordV1
+	Url                  string
+	Pattern              string
+	Group                string
+	Mode                 string
+	Transformations      string
+	TransformationScript string
+}
+
+func (this *HttpRegex) GobEncode() ([]byte, error) {
+	return encodeGobPayload(DsHttpRegex, HttpRegexRecordVersion, httpRegexRecordV1{
+		AbstractDataSource:   this.AbstractDataSource.recordV1(),
+		Url:                  this.url,
+		Pattern:              this.pattern,
+		Group:                this.group,
+		Mode:                 this.mode,
+		Transformations:      this.transformations,
+		TransformationScript: this.transformationScript,
+	})
+}
+
+func (this *HttpRegex) GobDecode(httpRegexBytes []byte) error {
+	record, err := DecodeRecord(httpRegexBytes)
+	if err != nil {
+		return err
+	}
+
+	return RecordDecoders{
+		1: this.decodeV1,
+	}.Decode(DsHttpRegex, record)
+}
+
+func (this *HttpRegex) decodeV1(payload []byte) error {
+	var record httpRegexRecordV1
+	err := decodeGobPayload(payload, &record)
+	if err != nil {
+		return err
+	}
+
+	this.AbstractDataSource.restoreV1(record.AbstractDataSource)
+	this.url = record.Url
+	this.pattern = record.Pattern
+	this.group = record.Group
+	this.mode = record.Mode
+	this.transformations = record.Transformations
+	this.transformationScript = record.TransformationScript
+
+	return this.compile()
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func init() {
+	RegisterDataSourceType(&DataSourceType{
+		Name: DsXmlApi,
+		New: func(abstractDataSource AbstractDataSource, typeSettings map[string]string) (DataSource, error) {
+			return NewXmlApi(
+				abstractDataSource, typeSettings["url"], typeSettings["method"], typeSettings["body"], typeSettings["contentType"],
+				typeSettings["xPath"], typeSettings["namespaces"], typeSettings["transformations"], typeSettings["transformationScript"],
+			)
+		},
+		ValidateTypeSettings: func(typeSettings map[string]string) error {
+			err := ValidateHttpRequestTypeSettings(typeSettings)
+			if err != nil {
+				return err
+			}
+			if len(typeSettings["xPath"]) == 0 {
+				return errors.New("Please provide a valid XPath expression.")
+			}
+			namespaces, err := ParseXPathNamespaces(typeSettings["namespaces"])
+			if err != nil {
+				return err
+			}
+			_, err = xpath.CompileWithNS(typeSettings["xPath"], namespaces)
+			if err != nil {
+				return errors.New("Please provide a valid XPath expression: " + err.Error())
+			}
+			_, err = ParseTransformationPipeline(typeSettings["transformations"])
+			return err
+		},
+		ExportTypeSettings: func(dataSource DataSource) map[string]string {
+			xmlApiDs := dataSource.(*XmlApi)
+			return map[string]string{
+				"url":                  xmlApiDs.url,
+				"method":               xmlApiDs.method,
+				"body":                 xmlApiDs.body,
+				"contentType":          xmlApiDs.contentType,
+				"xPath":                xmlApiDs.xPath,
+				"namespaces":           xmlApiDs.namespaces,
+				"transformations":      xmlApiDs.transformations,
+				"transformationScript": xmlApiDs.transformationScript,
+			}
+		},
+		Decode: func(dataSourceBytes []byte) (DataSource, error) {
+			xmlApiDs := &XmlApi{}
+			err := xmlApiDs.GobDecode(dataSourceBytes)
+			if err != nil {
+				return nil, err
+			}
+			return xmlApiDs, nil
+		},
+	})
+}
+
+// NewXmlApi expects the namespaces as JSON object (see ParseXPathNamespaces()) and the transformations as JSON (see ParseTransformationPipeline()).
+func NewXmlApi(abstractDataSource AbstractDataSource, url string, method string, body string, contentType string, xPath string, namespaces string, transformations string, transformationScript string) (*XmlApi, error) {
+	method = strings.ToUpper(method)
+	if len(method) == 0 {
+		method = "GET"
+	}
+	if len(contentType) == 0 && method == "POST" {
+		contentType = "text/xml; charset=utf-8"
+	}
+
+	xmlApi := &XmlApi{
+		AbstractDataSource:   abstractDataSource,
+		url:                  url,
+		method:               method,
+		body:                 body,
+		contentType:          contentType,
+		xPath:                xPath,
+		namespaces:           namespaces,
+		transformations:      transformations,
+		transformationScript: transformationScript,
+	}
+
+	err := xmlApi.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return xmlApi, nil
+}
+
+// XmlApi requests an XML document via HTTP GET (or POST, e.g. for SOAP) and evaluates an XPath expression against it.
+// The expression may use functions like count() or sum() and namespace prefixes, which are mapped to namespace URIs by `namespaces`.
+type XmlApi struct {
+	AbstractDataSource
+	url                    string
+	method                 string
+	body                   string
+	contentType            string
+	xPath                  string
+	namespaces             string
+	transformations        string
+	transformationScript   string
+	parsedNamespaces       map[string]string
+	transformationPipeline TransformationPipeline
+}
+
+// compile validates the XPath expression and prepares the namespaces and the transformations after the data source
+// has been created or decoded. The expression itself is compiled per retrieval (see compileXPath()).
+func (this *XmlApi) compile() (err error) {
+	this.parsedNamespaces, err = ParseXPathNamespaces(this.namespaces)
+	if err != nil {
+		return err
+	}
+
+	_, err = this.compileXPath()
+	if err != nil {
+		return err
+	}
+
+	this.transformationPipeline, err = ParseTransformationPipeline(this.transformations)
+	return err
+}
+
+// compileXPath returns a new compiled expression, because an *xpath.Expr keeps evaluation state
+// and mustn't be shared by concurrent retrievals.
+func (this *XmlApi) compileXPath() (*xpath.Expr, error) {
+	return xpath.CompileWithNS(this.xPath, this.parsedNamespaces)
+}
+
+func (this *XmlApi) Retrieve(ctx context.Context, sampleChan chan *Sample) {
+	t := time.Now()
+
+	var bodyReader io.Reader
+	if len(this.body) > 0 {
+		bodyReader = strings.NewReader(this.body)
+	}
+
+	req, err := http.NewRequest(this.method, this.url, bodyReader)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+	req.Header.Set("Accept", "application/xml, text/xml")
+	if len(this.contentType) > 0 {
+		req.Header.Set("Content-Type", this.contentType)
+	}
+
+	res, body, err := DoTracedRequest(ctx, req)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		sampleChan <- NewSample("", t, this.dataSourceId, fmt.Errorf("The XML API responded with status %s.", res.Status))
+		return
+	}
+
+	document, err := xmlquery.Parse(bytes.NewReader(body))
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, errors.New("The response isn't a valid XML document: "+err.Error()))
+		return
+	}
+
+	expr, err := this.compileXPath()
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	value, err := EvaluateXPath(document, expr)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+	GetRetrievalTrace(ctx).SetRawValue(value)
+
+	value, err = ApplyTransformations(ctx, value, this.transformationPipeline, this.transformationScript)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	sampleChan <- NewSample(value, t, this.dataSourceId, nil)
+}
+
+func (this *XmlApi) Type() string {
+	return DsXmlApi
+}
+
+const XmlApiRecordVersion = uint16(1)
+
+type xmlApiRecordV1 struct {
+	AbstractDataSource   abstractDataSourceRecordV1
+	Url                  string
+	Method               string
+	Body                 string
+	ContentType          string
+	XPath                string
+	Namespaces           string
+	Transformations      string
+	TransformationScript string
+}
+
+func (this *XmlApi) GobEncode() ([]byte, error) {
+	return encodeGobPayload(DsXmlApi, XmlApiRecordVersion, xmlApiRecordV1{
+		AbstractDataSource:   this.AbstractDataSource.recordV1(),
+		Url:                  this.url,
+		Method:               this.method,
+		Body:                 this.body,
+		ContentType:          this.contentType,
+		XPath:                this.xPath,
+		Namespaces:           this.namespaces,
+		Transformations:      this.transformations,
+		TransformationScript: this.transformationScript,
+	})
+}
+
+func (this *XmlApi) GobDecode(xmlApiBytes []byte) error {
+	record, err := DecodeRecord(xmlApiBytes)
+	if err != nil {
+		return err
+	}
+
+	return RecordDecoders{
+		1: this.decodeV1,
+	}.Decode(DsXmlApi, record)
+}
+
+func (this *XmlApi) decodeV1(payload []byte) error {
+	var record xmlApiRecordV1
+	err := decodeGobPayload(payload, &record)
+	if err != nil {
+		return err
+	}
+
+	this.AbstractDataSource.restoreV1(record.AbstractDataSource)
+	this.url = record.Url
+	this.method = record.Method
+	this.body = record.Body
+	this.contentType = record.ContentType
+	this.xPath = record.XPath
+	this.namespaces = record.Namespaces
+	this.transformations = record.Transformations
+	this.transformationScript = record.TransformationScript
+
+	return this.compile()
+}
+
+// ParseXPathNamespaces parses a JSON object which maps namespace prefixes to URIs,
+// e.g. {"atom": "http://www.w3.org/2005/Atom"}. An empty definition results in no namespaces.
+func ParseXPathNamespaces(definition string) (map[string]string, error) {
+	namespaces := map[string]string{}
+	if len(strings.TrimSpace(definition)) == 0 {
+		return namespaces, nil
+	}
+
+	err := json.Unmarshal([]byte(definition), &namespaces)
+	if err != nil {
+		return nil, errors.New("Please provide valid namespaces (a JSON object that maps prefixes to URIs): " + err.Error())
+	}
+
+	for prefix, uri := range namespaces {
+		if len(prefix) == 0 || len(uri) == 0 {
+			return nil, errors.New("Please provide valid namespaces (neither prefixes nor URIs may be empty).")
+		}
+	}
+
+	return namespaces, nil
+}
+
+// EvaluateXPath returns the textual representation of the result. Node sets are represented by the value of their first node.
+func EvaluateXPath(document *xmlquery.Node, expr *xpath.Expr) (string, error) {
+	switch v := expr.Evaluate(xmlquery.CreateXPathNavigator(document)).(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", errors.New("The specified XPath expression doesn't evaluate to a valid number.")
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string:
+		if len(v) == 0 {
+			return "", errors.New("The specified XPath expression evaluates to an empty string.")
+		}
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case *xpath.NodeIterator:
+		if !v.MoveNext() {
+			return "", errors.New("The specified XPath expression doesn't match any nodes.")
+		}
+		value := v.Current().Value()
+		if len(strings.TrimSpace(value)) == 0 {
+			return "", errors.New("The specified XPath expression matches an empty node.")
+		}
+		return value, nil
+	default:
+		return "", fmt.Errorf("The specified XPath expression evaluates to an unsupported type (%T).", v)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// AllowCommandDataSources is set according to the config. Command data sources can't be created (and don't retrieve
+// any samples) unless it's true.
+var AllowCommandDataSources = false
+
+var ErrCommandDataSourcesNotAllowed = errors.New("Command data sources are disabled (see config option allowCommandDataSources).")
+
+const (
+	// CommandMaxOutputSize is the number of bytes that are kept of stdout and stderr each. The remaining output is discarded.
+	CommandMaxOutputSize = 1 << 20
+	// CommandWaitDelay is how long a killed command may keep its output pipes open before they are closed forcibly.
+	CommandWaitDelay = time.Second
+)
+
+// LimitedBuffer keeps only the first Limit bytes written to it.
+// It accepts (and discards) the remaining ones, so that the writer doesn't fail because of the limit.
+type LimitedBuffer struct {
+	buffer    bytes.Buffer // not embedded, otherwise io.Copy would bypass Write() via bytes.Buffer.ReadFrom()
+	Limit     int
+	Truncated bool
+}
+
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.Limit - b.buffer.Len()
+	if len(p) > remaining {
+		b.Truncated = true
+		if remaining > 0 {
+			b.buffer.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+
+	return b.buffer.Write(p)
+}
+
+func (b *LimitedBuffer) String() string {
+	return b.buffer.String()
+}
+
+func init() {
+	RegisterDataSourceType(&DataSourceType{
+		Name: DsCommand,
+		New: func(abstractDataSource AbstractDataSource, typeSettings map[string]string) (DataSource, error) {
+			return NewCommand(
+				abstractDataSource, typeSettings["command"], typeSettings["args"], typeSettings["workingDir"], typeSettings["env"],
+				typeSettings["pattern"], typeSettings["group"], typeSettings["transformations"], typeSettings["transformationScript"],
+			)
+		},
+		ValidateTypeSettings: func(typeSettings map[string]string) error {
+			if !AllowCommandDataSources {
+				return ErrCommandDataSourcesNotAllowed
+			}
+			if len(typeSettings["command"]) == 0 {
+				return errors.New("Please provide a command.")
+			}
+			_, err := ParseCommandArgs(typeSettings["args"])
+			if err != nil {
+				return err
+			}
+			_, err = ParseCommandEnv(typeSettings["env"])
+			if err != nil {
+				return err
+			}
+			if len(typeSettings["pattern"]) > 0 {
+				pattern, err := regexp.Compile(typeSettings["pattern"])
+				if err != nil {
+					return errors.New("Please provide a valid regular expression: " + err.Error())
+				}
+				_, err = ResolveRegexGroup(pattern, typeSettings["group"])
+				if err != nil {
+					return err
+				}
+			} else if len(typeSettings["group"]) > 0 {
+				return errors.New("A capture group requires a regular expression.")
+			}
+			_, err = ParseTransformationPipeline(typeSettings["transformations"])
+			return err
+		},
+		ExportTypeSettings: func(dataSource DataSource) map[string]string {
+			commandDs := dataSource.(*Command)
+			return map[string]string{
+				"command":              commandDs.command,
+				"args":                 commandDs.args,
+				"workingDir":           commandDs.workingDir,
+				"env":                  commandDs.env,
+				"pattern":              commandDs.pattern,
+				"group":                commandDs.group,
+				"transformations":      commandDs.transformations,
+				"transformationScript": commandDs.transformationScript,
+			}
+		},
+		Decode: func(dataSourceBytes []byte) (DataSource, error) {
+			commandDs := &Command{}
+			err := commandDs.GobDecode(dataSourceBytes)
+			if err != nil {
+				return nil, err
+			}
+			return commandDs, nil
+		},
+	})
+}
+
+// NewCommand expects args as JSON array (see ParseCommandArgs()), env as JSON object (see ParseCommandEnv()),
+// and the transformations as JSON (see ParseTransformationPipeline()). Pattern and group are optional.
+func NewCommand(abstractDataSource AbstractDataSource, command string, args string, workingDir string, env string, pattern string, group string, transformations string, transformationScript string) (*Command, error) {
+	commandDs := &Command{
+		AbstractDataSource:   abstractDataSource,
+		command:              command,
+		args:                 args,
+		workingDir:           workingDir,
+		env:                  env,
+		pattern:              pattern,
+		group:                group,
+		transformations:      transformations,
+		transformationScript: transformationScript,
+	}
+
+	err := commandDs.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return commandDs, nil
+}
+
+// Command runs a local command and takes its output (stdout) as value. Without pattern the whole output is taken
+// (without leading and trailing whitespace), otherwise a capture group of the first match (see ResolveRegexGroup()).
+// A non-zero exit status results in a failed retrieval. The command gets killed as soon as the retrieval times out.
+type Command struct {
+	AbstractDataSource
+	command                string
+	args                   string
+	workingDir             string
+	env                    string
+	pattern                string
+	group                  string
+	transformations        string
+	transformationScript   string
+	parsedArgs             []string
+	parsedEnv              map[string]string
+	compiledPattern        *regexp.Regexp
+	groupIndex             int
+	transformationPipeline TransformationPipeline
+}
+
+// compile prepares args, env, pattern, and transformations after the data source has been created or decoded.
+func (this *Command) compile() (err error) {
+	this.parsedArgs, err = ParseCommandArgs(this.args)
+	if err != nil {
+		return err
+	}
+
+	this.parsedEnv, err = ParseCommandEnv(this.env)
+	if err != nil {
+		return err
+	}
+
+	this.compiledPattern = nil
+	if len(this.pattern) > 0 {
+		this.compiledPattern, err = regexp.Compile(this.pattern)
+		if err != nil {
+			return err
+		}
+
+		this.groupIndex, err = ResolveRegexGroup(this.compiledPattern, this.group)
+		if err != nil {
+			return err
+		}
+	}
+
+	this.transformationPipeline, err = ParseTransformationPipeline(this.transformations)
+	return err
+}
+
+func (this *Command) Retrieve(ctx context.Context, sampleChan chan *Sample) {
+	t := time.Now()
+	if !AllowCommandDataSources {
+		sampleChan <- NewSample("", t, this.dataSourceId, ErrCommandDataSourcesNotAllowed)
+		return
+	}
+
+	// CommandContext kills the process (group) as soon as ctx is done. Children that aren't part of the group
+	// might still hold the output pipes open, that's why Run() stops waiting for them after CommandWaitDelay.
+	cmd := exec.CommandContext(ctx, this.command, this.parsedArgs...)
+	PrepareProcessGroup(cmd)
+	cmd.WaitDelay = CommandWaitDelay
+	cmd.Dir = this.workingDir
+	if len(this.parsedEnv) > 0 {
+		cmd.Env = os.Environ()
+		for name, value := range this.parsedEnv {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
+
+	stdout := &LimitedBuffer{Limit: CommandMaxOutputSize}
+	stderr := &LimitedBuffer{Limit: CommandMaxOutputSize}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+
+	commandTrace := CommandTrace{
+		Command:   this.command,
+		Args:      this.parsedArgs,
+		ExitCode:  -1,
+		Duration:  time.Since(t).Nanoseconds() / 1000000,
+		Stdout:    ShortenSnippet(stdout.String(), TraceMaxSnippetLength),
+		Stderr:    ShortenSnippet(stderr.String(), TraceMaxSnippetLength),
+		Truncated: stdout.Truncated || stderr.Truncated,
+	}
+	if cmd.ProcessState != nil {
+		commandTrace.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	GetRetrievalTrace(ctx).SetCommand(commandTrace)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if exitErr, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("The command exited with status %d.", exitErr.ExitCode())
+			if errOutput := strings.TrimSpace(stderr.String()); len(errOutput) > 0 {
+				err = fmt.Errorf("The command exited with status %d: %s", exitErr.ExitCode(), ShortenSnippet(errOutput, TraceMaxSnippetLength))
+			}
+		}
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	value, err := this.extract(stdout.String())
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+	GetRetrievalTrace(ctx).SetRawValue(value)
+
+	value, err = ApplyTransformations(ctx, value, this.transformationPipeline, this.transformationScript)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	sampleChan <- NewSample(value, t, this.dataSourceId, nil)
+}
+
+func (this *Command) extract(output string) (string, error) {
+	if this.compiledPattern == nil {
+		value := strings.TrimSpace(output)
+		if len(value) == 0 {
+			return "", errors.New("The command didn't print anything.")
+		}
+		return value, nil
+	}
+
+	match := this.compiledPattern.FindStringSubmatch(output)
+	if match == nil {
+		return "", errors.New("The specified regular expression doesn't match the output of the command.")
+	}
+	if len(match[this.groupIndex]) == 0 {
+		return "", errors.New("The specified capture group is empty.")
+	}
+
+	return match[this.groupIndex], nil
+}
+
+func (this *Command) Type() string {
+	return DsCommand
+}
+
+const CommandRecordVersion = uint16(1)
+
+type commandRecordV1 struct {
+	AbstractDataSource   abstractDataSourceRecordV1
+	Command              string
+	Args                 string
+	WorkingDir           string
+	Env                  string
+	Pattern              string
+	Group                string
+	Transformations      string
+	TransformationScript string
+}
+
+func (this *Command) GobEncode() ([]byte, error) {
+	return encodeGobPayload(DsCommand, CommandRecordVersion, commandRecordV1{
+		AbstractDataSource:   this.AbstractDataSource.recordV1(),
+		Command:              this.command,
+		Args:                 this.args,
+		WorkingDir:           this.workingDir,
+		Env:                  this.env,
+		Pattern:              this.pattern,
+		Group:                this.group,
+		Transformations:      this.transformations,
+		TransformationScript: this.transformationScript,
+	})
+}
+
+func (this *Command) GobDecode(commandBytes []byte) error {
+	record, err := DecodeRecord(commandBytes)
+	if err != nil {
+		return err
+	}
+
+	return RecordDecoders{
+		1: this.decodeV1,
+	}.Decode(DsCommand, record)
+}
+
+func (this *Command) decodeV1(payload []byte) error {
+	var record commandRecordV1
+	err := decodeGobPayload(payload, &record)
+	if err != nil {
+		return err
+	}
+
+	this.AbstractDataSource.restoreV1(record.AbstractDataSource)
+	this.command = record.Command
+	this.args = record.Args
+	this.workingDir = record.WorkingDir
+	this.env = record.Env
+	this.pattern = record.Pattern
+	this.group = record.Group
+	this.transformations = record.Transformations
+	this.transformationScript = record.TransformationScript
+
+	return this.compile()
+}
+
+// ParseCommandArgs parses a JSON array of arguments, e.g. ["-c", "ls | wc -l"]. An empty definition results in no arguments.
+func ParseCommandArgs(definition string) ([]string, error) {
+	var args []string
+	if len(strings.TrimSpace(definition)) == 0 {
+		return args, nil
+	}
+
+	err := json.Unmarshal([]byte(definition), &args)
+	if err != nil {
+		return nil, errors.New("Please provide valid arguments (a JSON array of strings): " + err.Error())
+	}
+
+	return args, nil
+}
+
+// ParseCommandEnv parses a JSON object of environment variables, e.g. {"LANG": "C"}, which are passed to the command
+// in addition to the environment of Kasperbrett. An empty definition results in no additional variables.
+func ParseCommandEnv(definition string) (map[string]string, error) {
+	env := map[string]string{}
+	if len(strings.TrimSpace(definition)) == 0 {
+		return env, nil
+	}
+
+	err := json.Unmarshal([]byte(definition), &env)
+	if err != nil {
+		return nil, errors.New("Please provide valid environment variables (a JSON object that maps names to values): " + err.Error())
+	}
+
+	for name := range env {
+		if len(name) == 0 || strings.ContainsAny(name, "=\x00") {
+			return nil, fmt.Errorf("Please provide valid environment variables ('%s' isn't a valid name).", name)
+		}
+	}
+
+	return env, nil
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+const DefaultProcPath = "/proc"
+
+// ProcPath is set according to the config. It's not a type setting, because clients of the REST API mustn't be able
+// to read from arbitrary paths.
+var ProcPath = DefaultProcPath
+
+// SystemMetricsMeasurementInterval is the time between the two readings of rates and utilizations (e.g. cpu.utilization).
+const SystemMetricsMeasurementInterval = 500 * time.Millisecond
+
+// SystemMetric reads a single metric. Target is the mount point of disk metrics and the interface of network metrics.
+type SystemMetric struct {
+	TargetRequired bool
+	DefaultTarget  string
+	Read           func(ctx context.Context, procPath string, target string) (string, error)
+}
+
+// SystemMetrics lists all metrics of the system metrics data source. Memory, disk, and network metrics are bytes
+// (or bytes per second), percentages have two decimals.
+var SystemMetrics = map[string]SystemMetric{
+	"cpu.utilization":    {Read: readCpuUtilization},
+	"load.1":             {Read: loadAverageReader(0)},
+	"load.5":             {Read: loadAverageReader(1)},
+	"load.15":            {Read: loadAverageReader(2)},
+	"memory.total":       {Read: memoryReader(func(total, available uint64) string { return formatUint(total) })},
+	"memory.available":   {Read: memoryReader(func(total, available uint64) string { return formatUint(available) })},
+	"memory.used":        {Read: memoryReader(func(total, available uint64) string { return formatUint(total - available) })},
+	"memory.usedPercent": {Read: memoryReader(func(total, available uint64) string { return formatPercent(total-available, total) })},
+	"disk.total":         {DefaultTarget: "/", Read: diskReader(func(usage DiskUsage) string { return formatUint(usage.Total) })},
+	"disk.available":     {DefaultTarget: "/", Read: diskReader(func(usage DiskUsage) string { return formatUint(usage.Available) })},
+	"disk.used":          {DefaultTarget: "/", Read: diskReader(func(usage DiskUsage) string { return formatUint(usage.Used()) })},
+	"disk.usedPercent":   {DefaultTarget: "/", Read: diskReader(func(usage DiskUsage) string { return formatPercent(usage.Used(), usage.Used()+usage.Available) })},
+	"net.rxBytes":        {TargetRequired: true, Read: netBytesReader(true)},
+	"net.txBytes":        {TargetRequired: true, Read: netBytesReader(false)},
+	"net.rxRate":         {TargetRequired: true, Read: netRateReader(true)},
+	"net.txRate":         {TargetRequired: true, Read: netRateReader(false)},
+	"processes.total":    {Read: readProcessCount},
+	"processes.running":  {Read: procStatReader("procs_running")},
+	"processes.blocked":  {Read: procStatReader("procs_blocked")},
+}
+
+var ErrSystemMetricsNotSupported = errors.New("System metrics aren't supported on this platform.")
+
+func init() {
+	RegisterDataSourceType(&DataSourceType{
+		Name: DsSystemMetrics,
+		New: func(abstractDataSource AbstractDataSource, typeSettings map[string]string) (DataSource, error) {
+			return NewSystemMetricsDataSource(abstractDataSource, typeSettings["metric"], typeSettings["target"])
+		},
+		ValidateTypeSettings: func(typeSettings map[string]string) error {
+			// the metrics are read from /proc (and statfs), which only exist on Linux
+			if runtime.GOOS != "linux" {
+				return ErrSystemMetricsNotSupported
+			}
+			metric, ok := SystemMetrics[typeSettings["metric"]]
+			if !ok {
+				return errors.New("Please provide a valid metric (e.g. cpu.utilization, memory.usedPercent, or disk.usedPercent).")
+			}
+			if metric.TargetRequired && len(typeSettings["target"]) == 0 {
+				return fmt.Errorf("The metric %s requires a target (e.g. the network interface).", typeSettings["metric"])
+			}
+			return nil
+		},
+		ExportTypeSettings: func(dataSource DataSource) map[string]string {
+			systemMetricsDs := dataSource.(*SystemMetricsDataSource)
+			return map[string]string{
+				"metric": systemMetricsDs.metric,
+				"target": systemMetricsDs.target,
+			}
+		},
+		Decode: func(dataSourceBytes []byte) (DataSource, error) {
+			systemMetricsDs := &SystemMetricsDataSource{}
+			err := systemMetricsDs.GobDecode(dataSourceBytes)
+			if err != nil {
+				return nil, err
+			}
+			return systemMetricsDs, nil
+		},
+	})
+}
+
+// NewSystemMetricsDataSource applies the default target of the metric.
+func NewSystemMetricsDataSource(abstractDataSource AbstractDataSource, metric string, target string) (*SystemMetricsDataSource, error) {
+	systemMetric, ok := SystemMetrics[metric]
+	if !ok {
+		return nil, errors.New("Unsupported metric: " + metric)
+	}
+	if len(target) == 0 {
+		target = systemMetric.DefaultTarget
+	}
+
+	return &SystemMetricsDataSource{
+		AbstractDataSource: abstractDataSource,
+		metric:             metric,
+		target:             target,
+	}, nil
+}
+
+// SystemMetricsDataSource reads a metric of the system Kasperbrett is running on from /proc (see ProcPath) and statfs (Linux only).
+type SystemMetricsDataSource struct {
+	AbstractDataSource
+	metric string
+	target string
+}
+
+func (this *SystemMetricsDataSource) Retrieve(ctx context.Context, sampleChan chan *Sample) {
+	t := time.Now()
+
+	systemMetric, ok := SystemMetrics[this.metric]
+	if !ok {
+		sampleChan <- NewSample("", t, this.dataSourceId, errors.New("Unsupported metric: "+this.metric))
+		return
+	}
+
+	value, err := systemMetric.Read(ctx, ProcPath, this.target)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+	GetRetrievalTrace(ctx).SetRawValue(value)
+
+	sampleChan <- NewSample(value, t, this.dataSourceId, nil)
+}
+
+func (this *SystemMetricsDataSource) Type() string {
+	return DsSystemMetrics
+}
+
+const SystemMetricsRecordVersion = uint16(1)
+
+type systemMetricsRecordV1 struct {
+	AbstractDataSource abstractDataSourceRecordV1
+	Metric             string
+	Target             string
+}
+
+func (this *SystemMetricsDataSource) GobEncode() ([]byte, error) {
+	return encodeGobPayload(DsSystemMetrics, SystemMetricsRecordVersion, systemMetricsRecordV1{
+		AbstractDataSource: this.AbstractDataSource.recordV1(),
+		Metric:             this.metric,
+		Target:             this.target,
+	})
+}
+
+func (this *SystemMetricsDataSource) GobDecode(systemMetricsBytes []byte) error {
+	record, err := DecodeRecord(systemMetricsBytes)
+	if err != nil {
+		return err
+	}
+
+	return RecordDecoders{
+		1: this.decodeV1,
+	}.Decode(DsSystemMetrics, record)
+}
+
+func (this *SystemMetricsDataSource) decodeV1(payload []byte) error {
+	var record systemMetricsRecordV1
+	err := decodeGobPayload(payload, &record)
+	if err != nil {
+		return err
+	}
+
+	this.AbstractDataSource.restoreV1(record.AbstractDataSource)
+	this.metric = record.Metric
+	this.target = record.Target
+
+	return nil
+}
+
+// DiskUsage is measured in bytes. Available is the free space that can be used by unprivileged users.
+type DiskUsage struct {
+	Total     uint64
+	Free      uint64
+	Available uint64
+}
+
+func (u DiskUsage) Used() uint64 {
+	return u.Total - u.Free
+}
+
+func formatUint(value uint64) string {
+	return strconv.FormatUint(value, 10)
+}
+
+func formatPercent(part uint64, whole uint64) string {
+	if whole == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(part)/float64(whole)*100, 'f', 2, 64)
+}
+
+// waitForMeasurement waits SystemMetricsMeasurementInterval unless ctx is done before.
+func waitForMeasurement(ctx context.Context) error {
+	select {
+	case <-time.After(SystemMetricsMeasurementInterval):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// readProcFields returns the fields of all lines of a /proc file.
+func readProcFields(procPath string, name string) ([][]string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procPath, name))
+	if err != nil {
+		return nil, err
+	}
+
+	var lines [][]string
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			lines = append(lines, fields)
+		}
+	}
+	return lines, nil
+}
+
+// parseProcUint doesn't include the field in its error, the content of /proc files isn't meant for API clients.
+func parseProcUint(procPath string, name string, field string) (uint64, error) {
+	value, err := strconv.ParseUint(field, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Couldn't parse %s.", filepath.Join(procPath, name))
+	}
+	return value, nil
+}
+
+// ReadCpuTimes returns the idle time (including I/O wait) and the overall time of all CPUs since boot (in ticks).
+func ReadCpuTimes(procPath string) (idle uint64, total uint64, err error) {
+	lines, err := readProcFields(procPath, "stat")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, fields := range lines {
+		if fields[0] != "cpu" {
+			continue
+		}
+
+		// user nice system idle iowait irq softirq steal (guest times are part of the user times)
+		for i := 1; i < len(fields) && i <= 8; i++ {
+			ticks, err := parseProcUint(procPath, "stat", fields[i])
+			if err != nil {
+				return 0, 0, err
+			}
+			total += ticks
+			if i == 4 || i == 5 {
+				idle += ticks
+			}
+		}
+		return idle, total, nil
+	}
+
+	return 0, 0, fmt.Errorf("Couldn't find the CPU times in %s.", filepath.Join(procPath, "stat"))
+}
+
+func readCpuUtilization(ctx context.Context, procPath string, target string) (string, error) {
+	idleBefore, totalBefore, err := ReadCpuTimes(procPath)
+	if err != nil {
+		return "", err
+	}
+
+	err = waitForMeasurement(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	idleAfter, totalAfter, err := ReadCpuTimes(procPath)
+	if err != nil {
+		return "", err
+	}
+
+	if totalAfter <= totalBefore {
+		return "0", nil
+	}
+	idle, total := idleAfter-idleBefore, totalAfter-totalBefore
+	return formatPercent(total-idle, total), nil
+}
+
+func loadAverageReader(index int) func(ctx context.Context, procPath string, target string) (string, error) {
+	return func(ctx context.Context, procPath string, target string) (string, error) {
+		lines, err := readProcFields(procPath, "loadavg")
+		if err != nil {
+			return "", err
+		}
+		if len(lines) == 0 || len(lines[0]) < 3 {
+			return "", fmt.Errorf("Couldn't parse %s.", filepath.Join(procPath, "loadavg"))
+		}
+
+		return lines[0][index], nil
+	}
+}
+
+// ReadMemory returns the total and the available memory in bytes.
+func ReadMemory(procPath string) (total uint64, available uint64, err error) {
+	lines, err := readProcFields(procPath, "meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	memInfo := map[string]uint64{}
+	for _, fields := range lines {
+		if len(fields) < 2 {
+			continue
+		}
+		kiloBytes, err := parseProcUint(procPath, "meminfo", fields[1])
+		if err != nil {
+			return 0, 0, err
+		}
+		memInfo[strings.TrimSuffix(fields[0], ":")] = kiloBytes * 1024
+	}
+
+	total, ok := memInfo["MemTotal"]
+	if !ok {
+		return 0, 0, fmt.Errorf("Couldn't find the total memory in %s.", filepath.Join(procPath, "meminfo"))
+	}
+
+	available, ok = memInfo["MemAvailable"]
+	if !ok {
+		// kernels before 3.14 don't provide an estimate
+		available = memInfo["MemFree"] + memInfo["Buffers"] + memInfo["Cached"]
+	}
+	if available > total {
+		available = total
+	}
+
+	return total, available, nil
+}
+
+func memoryReader(format func(total uint64, available uint64) string) func(ctx context.Context, procPath string, target string) (string, error) {
+	return func(ctx context.Context, procPath string, target string) (string, error) {
+		total, available, err := ReadMemory(procPath)
+		if err != nil {
+			return "", err
+		}
+		return format(total, available), nil
+	}
+}
+
+func diskReader(format func(usage DiskUsage) string) func(ctx context.Context, procPath string, target string) (string, error) {
+	return func(ctx context.Context, procPath string, target string) (string, error) {
+		usage, err := StatFs(target)
+		if err != nil {
+			return "", err
+		}
+		return format(usage), nil
+	}
+}
+
+// ReadNetworkBytes returns the received and transmitted bytes of the network interface since boot.
+func ReadNetworkBytes(procPath string, networkInterface string) (rx uint64, tx uint64, err error) {
+	lines, err := readProcFields(procPath, filepath.Join("net", "dev"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, fields := range lines {
+		// the interface name and the first counter aren't separated by whitespace if the counter is large
+		line := strings.Join(fields, " ")
+		separator := strings.Index(line, ":")
+		if separator < 0 || strings.TrimSpace(line[:separator]) != networkInterface {
+			continue
+		}
+
+		counters := strings.Fields(line[separator+1:])
+		if len(counters) < 9 {
+			break
+		}
+		rx, err = parseProcUint(procPath, "net/dev", counters[0])
+		if err != nil {
+			return 0, 0, err
+		}
+		tx, err = parseProcUint(procPath, "net/dev", counters[8])
+		if err != nil {
+			return 0, 0, err
+		}
+		return rx, tx, nil
+	}
+
+	return 0, 0, fmt.Errorf("There is no network interface '%s'.", networkInterface)
+}
+
+func netBytesReader(received bool) func(ctx context.Context, procPath string, target string) (string, error) {
+	return func(ctx context.Context, procPath string, target string) (string, error) {
+		rx, tx, err := ReadNetworkBytes(procPath, target)
+		if err != nil {
+			return "", err
+		}
+		if received {
+			return formatUint(rx), nil
+		}
+		return formatUint(tx), nil
+	}
+}
+
+func netRateReader(received bool) func(ctx context.Context, procPath string, target string) (string, error) {
+	return func(ctx context.Context, procPath string, target string) (string, error) {
+		start := time.Now()
+		rxBefore, txBefore, err := ReadNetworkBytes(procPath, target)
+		if err != nil {
+			return "", err
+		}
+
+		err = waitForMeasurement(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		rxAfter, txAfter, err := ReadNetworkBytes(procPath, target)
+		if err != nil {
+			return "", err
+		}
+
+		before, after := txBefore, txAfter
+		if received {
+			before, after = rxBefore, rxAfter
+		}
+		if after < before {
+			// the counter has been reset or has wrapped around
+			return "0", nil
+		}
+		return strconv.FormatFloat(float64(after-before)/time.Since(start).Seconds(), 'f', 0, 64), nil
+	}
+}
+
+func procStatReader(name string) func(ctx context.Context, procPath string, target string) (string, error) {
+	return func(ctx context.Context, procPath string, target string) (string, error) {
+		lines, err := readProcFields(procPath, "stat")
+		if err != nil {
+			return "", err
+		}
+
+		for _, fields := range lines {
+			if fields[0] == name && len(fields) > 1 {
+				count, err := parseProcUint(procPath, "stat", fields[1])
+				if err != nil {
+					return "", err
+				}
+				return formatUint(count), nil
+			}
+		}
+
+		return "", fmt.Errorf("Couldn't find %s in %s.", name, filepath.Join(procPath, "stat"))
+	}
+}
+
+func readProcessCount(ctx context.Context, procPath string, target string) (string, error) {
+	entries, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return "", err
+	}
+
+	count := uint64(0)
+	for _, entry := range entries {
+		if _, err := strconv.ParseUint(entry.Name(), 10, 64); err == nil && entry.IsDir() {
+			count++
+		}
+	}
+	return formatUint(count), nil
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// The metrics of HTTP probes are recorded as sample meta, one of them is the value of the sample.
+const (
+	HttpProbeResponseTime    = "responseTime" // milliseconds including the response body
+	HttpProbeStatusCode      = "statusCode"
+	HttpProbeBodySize        = "bodySize" // bytes
+	HttpProbeAssertionPassed = "assertionPassed"
+)
+
+// HttpProbeTimeoutReserve is how much earlier than the retrieval a probe request times out, so that the sample of the
+// probe (with the response time and the failed assertion) arrives before Retrieve() gives up on it.
+const HttpProbeTimeoutReserve = 50 * time.Millisecond
+
+// HttpProbeClient doesn't follow redirects, so that probes record (and assert) the status of the probed URL itself.
+var HttpProbeClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func init() {
+	RegisterDataSourceType(&DataSourceType{
+		Name: DsHttpProbe,
+		New: func(abstractDataSource AbstractDataSource, typeSettings map[string]string) (DataSource, error) {
+			return NewHttpProbe(
+				abstractDataSource, typeSettings["url"], typeSettings["method"], typeSettings["metric"],
+				typeSettings["expectedStatus"], typeSettings["bodyContains"], typeSettings["headerName"], typeSettings["headerPattern"],
+			)
+		},
+		ValidateTypeSettings: func(typeSettings map[string]string) error {
+			if len(typeSettings["url"]) == 0 {
+				return errors.New("Please provide a valid URL.")
+			}
+			method := strings.ToUpper(typeSettings["method"])
+			if len(method) > 0 && method != "GET" && method != "HEAD" {
+				return errors.New("Please provide a valid HTTP method (GET or HEAD).")
+			}
+			if len(typeSettings["bodyContains"]) > 0 && method == "HEAD" {
+				return errors.New("The response body can't be checked for HEAD requests.")
+			}
+			switch typeSettings["metric"] {
+			case "", HttpProbeResponseTime, HttpProbeStatusCode, HttpProbeBodySize:
+			default:
+				return fmt.Errorf("Please provide a valid metric (%s, %s, or %s).", HttpProbeResponseTime, HttpProbeStatusCode, HttpProbeBodySize)
+			}
+			_, err := ParseExpectedStatus(typeSettings["expectedStatus"])
+			if err != nil {
+				return err
+			}
+			if len(typeSettings["headerPattern"]) > 0 {
+				if len(typeSettings["headerName"]) == 0 {
+					return errors.New("Please provide the name of the header that has to match the pattern.")
+				}
+				_, err = regexp.Compile(typeSettings["headerPattern"])
+				if err != nil {
+					return errors.New("Please provide a valid header pattern: " + err.Error())
+				}
+			}
+			return nil
+		},
+		ExportTypeSettings: func(dataSource DataSource) map[string]string {
+			httpProbeDs := dataSource.(*HttpProbe)
+			return map[string]string{
+				"url":            httpProbeDs.url,
+				"method":         httpProbeDs.method,
+				"metric":         httpProbeDs.metric,
+				"expectedStatus": httpProbeDs.expectedStatus,
+				"bodyContains":   httpProbeDs.bodyContains,
+				"headerName":     httpProbeDs.headerName,
+				"headerPattern":  httpProbeDs.headerPattern,
+			}
+		},
+		Decode: func(dataSourceBytes []byte) (DataSource, error) {
+			httpProbeDs := &HttpProbe{}
+			err := httpProbeDs.GobDecode(dataSourceBytes)
+			if err != nil {
+				return nil, err
+			}
+			return httpProbeDs, nil
+		},
+	})
+}
+
+// NewHttpProbe defaults to GET requests, the response time as value, and 2xx as expected status.
+// A header name without pattern only asserts that the header is present.
+func NewHttpProbe(abstractDataSource AbstractDataSource, url string, method string, metric string, expectedStatus string, bodyContains string, headerName string, headerPattern string) (*HttpProbe, error) {
+	method = strings.ToUpper(method)
+	if len(method) == 0 {
+		method = "GET"
+	}
+	if len(metric) == 0 {
+		metric = HttpProbeResponseTime
+	}
+	if len(expectedStatus) == 0 {
+		expectedStatus = "2xx"
+	}
+
+	httpProbe := &HttpProbe{
+		AbstractDataSource: abstractDataSource,
+		url:                url,
+		method:             method,
+		metric:             metric,
+		expectedStatus:     expectedStatus,
+		bodyContains:       bodyContains,
+		headerName:         headerName,
+		headerPattern:      headerPattern,
+	}
+
+	err := httpProbe.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return httpProbe, nil
+}
+
+// HttpProbe checks the availability of a URL. Every retrieval records the response time, the status code, the body size,
+// and whether the assertions (expected status, body contains, header match) passed as sample meta.
+// Failed requests and assertions result in failed retrievals, so that they show up in the history of the data source.
+// Redirects aren't followed (see HttpProbeClient) and the body isn't limited by MaxResponseBodySize, since it's only scanned.
+type HttpProbe struct {
+	AbstractDataSource
+	url                   string
+	method                string
+	metric                string
+	expectedStatus        string
+	bodyContains          string
+	headerName            string
+	headerPattern         string
+	parsedExpectedStatus  []string
+	compiledHeaderPattern *regexp.Regexp
+}
+
+// compile prepares the assertions after the data source has been created or decoded.
+func (this *HttpProbe) compile() (err error) {
+	this.parsedExpectedStatus, err = ParseExpectedStatus(this.expectedStatus)
+	if err != nil {
+		return err
+	}
+
+	this.compiledHeaderPattern = nil
+	if len(this.headerPattern) > 0 {
+		this.compiledHeaderPattern, err = regexp.Compile(this.headerPattern)
+	}
+	return err
+}
+
+func (this *HttpProbe) Retrieve(ctx context.Context, sampleChan chan *Sample) {
+	t := time.Now()
+	req, err := http.NewRequest(this.method, this.url, nil)
+	if err != nil {
+		sampleChan <- NewSample("", t, this.dataSourceId, err)
+		return
+	}
+
+	requestCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) > 2*HttpProbeTimeoutReserve {
+		var cancel context.CancelFunc
+		requestCtx, cancel = context.WithDeadline(ctx, deadline.Add(-HttpProbeTimeoutReserve))
+		defer cancel()
+	}
+
+	httpTrace := HttpTrace{Method: req.Method, Url: req.URL.String()}
+	var bodySize int64
+	var bodyContains bool
+
+	res, err := HttpProbeClient.Do(req.WithContext(requestCtx))
+	if err == nil {
+		defer res.Body.Close()
+		httpTrace.Status = res.Status
+		httpTrace.StatusCode = res.StatusCode
+		httpTrace.Headers = res.Header
+		httpTrace.ResponseTime = time.Since(t).Nanoseconds() / 1000000
+		bodySize, bodyContains, err = ScanResponseBody(res.Body, this.bodyContains)
+	}
+	httpTrace.BodySize = int(bodySize)
+	httpTrace.TotalTime = time.Since(t).Nanoseconds() / 1000000
+	if err != nil {
+		httpTrace.Error = err.Error()
+	}
+	GetRetrievalTrace(ctx).SetHttp(httpTrace)
+
+	if err != nil {
+		// e.g. DNS errors, refused connections or timeouts, which are the most important outages to record
+		if requestCtx.Err() == context.DeadlineExceeded {
+			err = errors.New("Sample retrieval timed out.")
+		}
+		sample := NewSample("", t, this.dataSourceId, err)
+		sample.Meta = map[string]string{
+			HttpProbeResponseTime:    strconv.FormatInt(httpTrace.TotalTime, 10),
+			HttpProbeAssertionPassed: strconv.FormatBool(false),
+		}
+		sampleChan <- sample
+		return
+	}
+
+	meta := map[string]string{
+		HttpProbeResponseTime: strconv.FormatInt(httpTrace.TotalTime, 10),
+		HttpProbeStatusCode:   strconv.Itoa(res.StatusCode),
+		HttpProbeBodySize:     strconv.FormatInt(bodySize, 10),
+	}
+
+	err = this.assert(res, bodyContains)
+	meta[HttpProbeAssertionPassed] = strconv.FormatBool(err == nil)
+	if err != nil {
+		sample := NewSample("", t, this.dataSourceId, err)
+		sample.Meta = meta
+		sampleChan <- sample
+		return
+	}
+
+	value := meta[this.metric]
+	GetRetrievalTrace(ctx).SetRawValue(value)
+
+	sample := NewSample(value, t, this.dataSourceId, nil)
+	sample.Meta = meta
+	sampleChan <- sample
+}
+
+// assert checks the response, bodyContains tells whether the body contains this.bodyContains (see ScanResponseBody()).
+func (this *HttpProbe) assert(res *http.Response, bodyContains bool) error {
+	if !StatusMatches(this.parsedExpectedStatus, res.StatusCode) {
+		return fmt.Errorf("Expected status %s but got %s.", this.expectedStatus, res.Status)
+	}
+
+	if len(this.bodyContains) > 0 && !bodyContains {
+		return fmt.Errorf("The response body doesn't contain '%s'.", this.bodyContains)
+	}
+
+	if len(this.headerName) > 0 {
+		values, ok := res.Header[http.CanonicalHeaderKey(this.headerName)]
+		if !ok {
+			return fmt.Errorf("The response has no header '%s'.", this.headerName)
+		}
+
+		if this.compiledHeaderPattern != nil {
+			matches := false
+			for _, value := range values {
+				matches = matches || this.compiledHeaderPattern.MatchString(value)
+			}
+			if !matches {
+				return fmt.Errorf("The header '%s' doesn't match '%s'.", this.headerName, this.headerPattern)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (this *HttpProbe) Type() string {
+	return DsHttpProbe
+}
+
+const HttpProbeRecordVersion = uint16(1)
+
+type httpProbeRecordV1 struct {
+	AbstractDataSource abstractDataSourceRecordV1
+	Url                string
+	Method             string
+	Metric             string
+	ExpectedStatus     string
+	BodyContains       string
+	HeaderName         string
+	HeaderPattern      string
+}
+
+func (this *HttpProbe) GobEncode() ([]byte, error) {
+	return encodeGobPayload(DsHttpProbe, HttpProbeRecordVersion, httpProbeRecordV1{
+		AbstractDataSource: this.AbstractDataSource.recordV1(),
+		Url:                this.url,
+		Method:             this.method,
+		Metric:             this.metric,
+		ExpectedStatus:     this.expectedStatus,
+		BodyContains:       this.bodyContains,
+		HeaderName:         this.headerName,
+		HeaderPattern:      this.headerPattern,
+	})
+}
+
+func (this *HttpProbe) GobDecode(httpProbeBytes []byte) error {
+	record, err := DecodeRecord(httpProbeBytes)
+	if err != nil {
+		return err
+	}
+
+	return RecordDecoders{
+		1: this.decodeV1,
+	}.Decode(DsHttpProbe, record)
+}
+
+func (this *HttpProbe) decodeV1(payload []byte) error {
+	var record httpProbeRecordV1
+	err := decodeGobPayload(payload, &record)
+	if err != nil {
+		return err
+	}
+
+	this.AbstractDataSource.restoreV1(record.AbstractDataSource)
+	this.url = record.Url
+	this.method = record.Method
+	this.metric = record.Metric
+	this.expectedStatus = record.ExpectedStatus
+	this.bodyContains = record.BodyContains
+	this.headerName = record.HeaderName
+	this.headerPattern = record.HeaderPattern
+
+	return this.compile()
+}
+
+// ScanResponseBody reads the whole body and returns its size and whether it contains the substring (an empty one is
+// always contained). Only a window of the body is kept in memory, so that the size of the body isn't limited.
+func ScanResponseBody(body io.Reader, substring string) (size int64, contains bool, err error) {
+	needle := []byte(substring)
+	contains = len(needle) == 0
+	buffer := make([]byte, 32*1024)
+	var window []byte
+
+	for {
+		n, err := body.Read(buffer)
+		size += int64(n)
+		if !contains && n > 0 {
+			// the window keeps the end of the previous reads, in case the substring spans several reads
+			window = append(window, buffer[:n]...)
+			contains = bytes.Contains(window, needle)
+			if keep := len(needle) - 1; len(window) > keep {
+				window = append(window[:0], window[len(window)-keep:]...)
+			}
+		}
+
+		if err == io.EOF {
+			return size, contains, nil
+		} else if err != nil {
+			return size, contains, err
+		}
+	}
+}
+
+// ParseExpectedStatus parses a comma separated list of status codes and classes, e.g. "200, 3xx".
+// An empty definition expects 2xx.
+func ParseExpectedStatus(definition string) ([]string, error) {
+	if len(strings.TrimSpace(definition)) == 0 {
+		return []string{"2xx"}, nil
+	}
+
+	var expectedStatus []string
+	for _, status := range strings.Split(definition, ",") {
+		status = strings.ToLower(strings.TrimSpace(status))
+		if len(status) != 3 || status[0] < '1' || status[0] > '5' {
+			return nil, fmt.Errorf("Please provide a valid expected status (e.g. 200 or 2xx) instead of '%s'.", status)
+		}
+		if status[1:] != "xx" {
+			if _, err := strconv.Atoi(status); err != nil {
+				return nil, fmt.Errorf("Please provide a valid expected status (e.g. 200 or 2xx) instead of '%s'.", status)
+			}
+		}
+		expectedStatus = append(expectedStatus, status)
+	}
+
+	return expectedStatus, nil
+}
+
+// StatusMatches checks the status code against the result of ParseExpectedStatus().
+func StatusMatches(expectedStatus []string, statusCode int) bool {
+	code := strconv.Itoa(statusCode)
+	for _, status := range expectedStatus {
+		if status == code || (status[1:] == "xx" && status[0] == code[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// TransformationScriptTimeout limits the execution time of a single transformation script run.
+const TransformationScriptTimeout = 1 * time.Second
+
+// TransformationScriptGlobals lists the globals which are exposed to transformation scripts (next to `value`).
+// Everything else (e.g. eval, Function or console) is removed from the JS engine before the script runs. Since every
+// function still leads to the Function constructor (e.g. `(function(){}).constructor`), it's disabled as well.
+var TransformationScriptGlobals = []string{
+	"Object", "Array", "String", "Number", "Boolean", "Math", "Date", "RegExp", "JSON",
+	"Error", "TypeError", "RangeError", "SyntaxError",
+	"parseInt", "parseFloat", "isNaN", "isFinite",
+	"encodeURIComponent", "decodeURIComponent",
+	"NaN", "Infinity", "undefined",
+}
+
+// errTransformationScriptInterrupted is raised (as panic) within the JS engine to halt a transformation script.
+var errTransformationScriptInterrupted = errors.New("The transformation script has been interrupted.")
+
+// NewTransformationScriptEngine returns a fresh JS engine which only exposes TransformationScriptGlobals.
+func NewTransformationScriptEngine() (*otto.Otto, error) {
+	jsEngine := otto.New()
+
+	err := jsEngine.Set("allowedGlobals", TransformationScriptGlobals)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = jsEngine.Run(`
+		(function(global) {
+			var disabledConstructor = function() {
+				throw new Error("The Function constructor isn't available.");
+			};
+			Object.defineProperty(Function.prototype, "constructor", {value: disabledConstructor, writable: false, configurable: false});
+
+			var allowed = {};
+			for (var i = 0; i < allowedGlobals.length; i++) {
+				allowed[allowedGlobals[i]] = true;
+			}
+			var names = Object.getOwnPropertyNames(global);
+			for (var j = 0; j < names.length; j++) {
+				if (!allowed[names[j]]) {
+					delete global[names[j]];
+				}
+			}
+		})(this);`)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsEngine, nil
+}
+
+// ApplyTransformationScript runs the (optional) JS transformation script of a data source.
+// Within the script the retrieved value is accessible as `value`. An empty script leaves the value untouched.
+// Every run gets its own JS engine, which is interrupted as soon as ctx is done or TransformationScriptTimeout has elapsed.
+func ApplyTransformationScript(ctx context.Context, value string, transformationScript string) (result string, err error) {
+	if len(transformationScript) == 0 {
+		return value, nil
+	}
+
+	jsEngine, err := NewTransformationScriptEngine()
+	if err != nil {
+		return "", err
+	}
+
+	scriptCtx, cancel := context.WithTimeout(ctx, TransformationScriptTimeout)
+	defer cancel()
+
+	jsEngine.Interrupt = make(chan func(), 1)
+	scriptDoneChan := make(chan bool)
+	defer close(scriptDoneChan)
+
+	go func() {
+		select {
+		case <-scriptCtx.Done():
+			jsEngine.Interrupt <- func() {
+				panic(errTransformationScriptInterrupted)
+			}
+		case <-scriptDoneChan:
+		}
+	}()
+
+	defer func() {
+		if caught := recover(); caught != nil {
+			if caught != errTransformationScriptInterrupted {
+				panic(caught)
+			}
+			if ctx.Err() != nil {
+				result, err = "", ctx.Err()
+			} else {
+				result, err = "", fmt.Errorf("The transformation script exceeded its time limit of %s.", TransformationScriptTimeout)
+			}
+		}
+	}()
+
+	// the value is passed as variable (instead of being part of the source), so that retrieved content can't inject JS code
+	err = jsEngine.Set("value", value)
+	if err != nil {
+		return "", err
+	}
+
+	jsValue, err := jsEngine.Run("value = " + transformationScript + ";")
+	if err != nil {
+		return "", err
+	}
+
+	value = jsValue.String()
+	if len(value) == 0 {
+		return "", errors.New("Couldn't perform the provided JS transformation.")
+	}
+
+	return value, nil
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+const (
+	TransformTrim     = "trim"
+	TransformRegex    = "regex"
+	TransformReplace  = "replace"
+	TransformNumber   = "number"
+	TransformMultiply = "multiply"
+	TransformRound    = "round"
+	TransformDefault  = "default"
+)
+
+// TransformationStep is a single step of a TransformationPipeline. Which fields apply depends on the type:
+//
+//	trim                                      removes leading and trailing whitespace
+//	regex     pattern, group                  extracts a group (number or name, by default the first group or the whole match),
+//	                                          results in an empty value if the pattern doesn't match
+//	replace   pattern, replacement            replaces all matches, the replacement may refer to groups (e.g. $1)
+//	number    locale, decimalSeparator,       parses a number (see ValueFormat) and returns it in canonical form,
+//	          groupSeparator                  e.g. '1.234,5' -> '1234.5' for locale 'de'
+//	multiply  factor                          multiplies a number in canonical form
+//	round     decimals                        rounds a number in canonical form
+//	default   value                           replaces an empty value
+type TransformationStep struct {
+	Type        string `json:"type"`
+	Pattern     string `json:"pattern"`
+	Group       string `json:"group"`
+	Replacement string `json:"replacement"`
+	ValueFormat
+	Factor   float64 `json:"factor"`
+	Decimals int     `json:"decimals"`
+	Value    string  `json:"value"`
+
+	compiledPattern *regexp.Regexp
+	groupIndex      int
+}
+
+// compile validates the step and prepares it for apply().
+func (step *TransformationStep) compile() error {
+	switch step.Type {
+	case TransformTrim:
+	case TransformRegex, TransformReplace:
+		if len(step.Pattern) == 0 {
+			return errors.New("Please provide a pattern.")
+		}
+		compiledPattern, err := regexp.Compile(step.Pattern)
+		if err != nil {
+			return errors.New("Please provide a valid pattern: " + err.Error())
+		}
+		step.compiledPattern = compiledPattern
+
+		if step.Type == TransformRegex {
+			return step.compileGroup()
+		}
+	case TransformNumber:
+		_, err := step.ValueFormat.Resolve()
+		return err
+	case TransformMultiply:
+		if step.Factor == 0 {
+			return errors.New("Please provide a factor (!= 0).")
+		}
+	case TransformRound:
+		if step.Decimals < 0 || step.Decimals > 15 {
+			return errors.New("Please provide a valid number of decimals (0 - 15).")
+		}
+	case TransformDefault:
+		if len(step.Value) == 0 {
+			return errors.New("Please provide a default value.")
+		}
+	default:
+		return fmt.Errorf("Unsupported transformation: %s", step.Type)
+	}
+
+	return nil
+}
+
+func (step *TransformationStep) compileGroup() (err error) {
+	step.groupIndex, err = ResolveRegexGroup(step.compiledPattern, step.Group)
+	return err
+}
+
+// ResolveRegexGroup returns the index of a capture group, which is given by number or name.
+// Without group it's the first group or (if the pattern has no groups) the whole match.
+func ResolveRegexGroup(pattern *regexp.Regexp, group string) (int, error) {
+	if len(group) == 0 {
+		if pattern.NumSubexp() > 0 {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	if groupIndex, err := strconv.Atoi(group); err == nil {
+		if groupIndex < 0 || groupIndex > pattern.NumSubexp() {
+			return 0, fmt.Errorf("The pattern has no group %d.", groupIndex)
+		}
+		return groupIndex, nil
+	}
+
+	for groupIndex, name := range pattern.SubexpNames() {
+		if name == group {
+			return groupIndex, nil
+		}
+	}
+	return 0, fmt.Errorf("The pattern has no group named '%s'.", group)
+}
+
+func (step *TransformationStep) apply(value string) (string, error) {
+	switch step.Type {
+	case TransformTrim:
+		return strings.TrimSpace(value), nil
+	case TransformRegex:
+		match := step.compiledPattern.FindStringSubmatch(value)
+		if match == nil {
+			return "", nil
+		}
+		return match[step.groupIndex], nil
+	case TransformReplace:
+		return step.compiledPattern.ReplaceAllString(value, step.Replacement), nil
+	case TransformNumber:
+		value, _, err := ParseValue(value, ValueTypeFloat, step.ValueFormat)
+		return value, err
+	case TransformMultiply:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", fmt.Errorf("Couldn't multiply '%s' since it isn't a number.", value)
+		}
+		return strconv.FormatFloat(number*step.Factor, 'f', -1, 64), nil
+	case TransformRound:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", fmt.Errorf("Couldn't round '%s' since it isn't a number.", value)
+		}
+		return strconv.FormatFloat(number, 'f', step.Decimals, 64), nil
+	case TransformDefault:
+		if len(strings.TrimSpace(value)) == 0 {
+			return step.Value, nil
+		}
+		return value, nil
+	}
+
+	return "", fmt.Errorf("Unsupported transformation: %s", step.Type)
+}
+
+// TransformationPipeline is the declarative alternative to transformation scripts.
+type TransformationPipeline []TransformationStep
+
+// ParseTransformationPipeline parses and validates a JSON list of transformation steps,
+// e.g. [{"type": "regex", "pattern": "([0-9.,]+) EUR"}, {"type": "number", "locale": "de"}].
+// An empty definition results in an empty pipeline.
+func ParseTransformationPipeline(definition string) (TransformationPipeline, error) {
+	if len(strings.TrimSpace(definition)) == 0 {
+		return nil, nil
+	}
+
+	var pipeline TransformationPipeline
+	err := json.Unmarshal([]byte(definition), &pipeline)
+	if err != nil {
+		return nil, errors.New("Please provide valid transformations: " + err.Error())
+	}
+
+	for i := range pipeline {
+		err = pipeline[i].compile()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid transformation step %d (%s): %s", i+1, pipeline[i].Type, err.Error())
+		}
+	}
+
+	return pipeline, nil
+}
+
+// Apply runs the steps in order and adds their intermediate results to the retrieval trace of ctx (if any).
+func (pipeline TransformationPipeline) Apply(ctx context.Context, value string) (string, error) {
+	trace := GetRetrievalTrace(ctx)
+
+	for i := range pipeline {
+		step := &pipeline[i]
+		stepName := fmt.Sprintf("%d: %s", i+1, step.Type)
+
+		result, err := step.apply(value)
+		if err != nil {
+			trace.AddTransformation(TransformationTraceStep{Step: stepName, Value: value, Error: err.Error()})
+			return "", fmt.Errorf("Transformation step %d (%s) failed: %s", i+1, step.Type, err.Error())
+		}
+
+		trace.AddTransformation(TransformationTraceStep{Step: stepName, Value: result})
+		value = result
+	}
+
+	return value, nil
+}
+
+// ApplyTransformations applies the transformation pipeline and afterwards the transformation script of a data source.
+func ApplyTransformations(ctx context.Context, value string, pipeline TransformationPipeline, transformationScript string) (string, error) {
+	value, err := pipeline.Apply(ctx, value)
+	if err != nil {
+		return "", err
+	}
+
+	if len(transformationScript) == 0 {
+		if len(value) == 0 {
+			return "", errors.New("The transformations resulted in an empty value.")
+		}
+		return value, nil
+	}
+
+	result, err := ApplyTransformationScript(ctx, value, transformationScript)
+	if err != nil {
+		GetRetrievalTrace(ctx).AddTransformation(TransformationTraceStep{Step: "script", Value: value, Error: err.Error()})
+		return "", err
+	}
+
+	GetRetrievalTrace(ctx).AddTransformation(TransformationTraceStep{Step: "script", Value: result})
+	return result, nil
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// RetrievalTrace collects the intermediate results of a single retrieval, e.g. for test runs via the REST API.
+// Data sources add to the trace of their context (see GetRetrievalTrace()) and might still do so after a timeout,
+// therefore it's safe for concurrent use. All methods accept a nil trace, which doesn't record anything.
+type RetrievalTrace struct {
+	mutex sync.Mutex
+	dto   RetrievalTraceDto
+}
+
+// RetrievalTraceDto only contains the parts that apply to the data source, e.g. there is no DOM trace for JSON APIs.
+type RetrievalTraceDto struct {
+	Http            *HttpTrace                `json:"http,omitempty"`
+	Dom             *DomTrace                 `json:"dom,omitempty"`
+	Command         *CommandTrace             `json:"command,omitempty"`
+	RawValue        string                    `json:"rawValue"` // the value before any transformation
+	Transformations []TransformationTraceStep `json:"transformations"`
+}
+
+type HttpTrace struct {
+	Method       string              `json:"method"`
+	Url          string              `json:"url"`
+	Status       string              `json:"status"` // e.g. "200 OK"
+	StatusCode   int                 `json:"statusCode"`
+	Headers      map[string][]string `json:"headers"`
+	ResponseTime int64               `json:"responseTime"` // milliseconds until the response headers arrived
+	TotalTime    int64               `json:"totalTime"`    // milliseconds including the response body
+	BodySize     int                 `json:"bodySize"`     // bytes
+	Truncated    bool                `json:"truncated"`    // true if the body exceeded MaxResponseBodySize, it isn't parsed then
+	Error        string              `json:"error,omitempty"`
+}
+
+type DomTrace struct {
+	CssPath  string   `json:"cssPath"`
+	Matches  int      `json:"matches"`
+	Snippets []string `json:"snippets"` // inner HTML of the first matching nodes (see TraceMaxSnippets)
+}
+
+type CommandTrace struct {
+	Command   string   `json:"command"`
+	Args      []string `json:"args"`
+	ExitCode  int      `json:"exitCode"`  // -1 if the command couldn't be started or has been killed
+	Duration  int64    `json:"duration"`  // milliseconds
+	Stdout    string   `json:"stdout"`    // shortened (see TraceMaxSnippetLength)
+	Stderr    string   `json:"stderr"`    // shortened (see TraceMaxSnippetLength)
+	Truncated bool     `json:"truncated"` // true if the command printed more than CommandMaxOutputSize bytes to stdout or stderr
+}
+
+// TransformationTraceStep is the result of a transformation step. Value is the input of the step if it failed.
+type TransformationTraceStep struct {
+	Step  string `json:"step"` // e.g. "2: regex" or "script"
+	Value string `json:"value"`
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	TraceMaxSnippets      = 5
+	TraceMaxSnippetLength = 500
+)
+
+type retrievalTraceKey struct{}
+
+func WithRetrievalTrace(ctx context.Context) (context.Context, *RetrievalTrace) {
+	trace := &RetrievalTrace{dto: RetrievalTraceDto{Transformations: make([]TransformationTraceStep, 0)}}
+	return context.WithValue(ctx, retrievalTraceKey{}, trace), trace
+}
+
+// GetRetrievalTrace returns nil unless ctx has been created by WithRetrievalTrace().
+func GetRetrievalTrace(ctx context.Context) *RetrievalTrace {
+	trace, _ := ctx.Value(retrievalTraceKey{}).(*RetrievalTrace)
+	return trace
+}
+
+// update calls fn with the locked DTO unless the trace is nil.
+func (trace *RetrievalTrace) update(fn func(dto *RetrievalTraceDto)) {
+	if trace == nil {
+		return
+	}
+
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+	fn(&trace.dto)
+}
+
+func (trace *RetrievalTrace) SetHttp(httpTrace HttpTrace) {
+	trace.update(func(dto *RetrievalTraceDto) {
+		dto.Http = &httpTrace
+	})
+}
+
+func (trace *RetrievalTrace) SetDom(domTrace DomTrace) {
+	trace.update(func(dto *RetrievalTraceDto) {
+		dto.Dom = &domTrace
+	})
+}
+
+func (trace *RetrievalTrace) SetCommand(commandTrace CommandTrace) {
+	trace.update(func(dto *RetrievalTraceDto) {
+		dto.Command = &commandTrace
+	})
+}
+
+func (trace *RetrievalTrace) SetRawValue(rawValue string) {
+	trace.update(func(dto *RetrievalTraceDto) {
+		dto.RawValue = rawValue
+	})
+}
+
+func (trace *RetrievalTrace) AddTransformation(step TransformationTraceStep) {
+	trace.update(func(dto *RetrievalTraceDto) {
+		dto.Transformations = append(dto.Transformations, step)
+	})
+}
+
+// Dto returns a copy of the current state of the trace.
+func (trace *RetrievalTrace) Dto() RetrievalTraceDto {
+	var dtoCopy RetrievalTraceDto
+	trace.update(func(dto *RetrievalTraceDto) {
+		dtoCopy = *dto
+		dtoCopy.Transformations = append([]TransformationTraceStep(nil), dto.Transformations...)
+	})
+	return dtoCopy
+}
+
+const DefaultMaxResponseBodySize = 10 << 20
+
+// MaxResponseBodySize is set according to the config. DoTracedRequest() rejects longer response bodies.
+var MaxResponseBodySize int64 = DefaultMaxResponseBodySize
+
+// ResponseBodyTooLargeError is returned by DoTracedRequest() instead of a truncated body, which would be parsed as if it was complete.
+type ResponseBodyTooLargeError struct {
+	MaxSize int64
+}
+
+func (err *ResponseBodyTooLargeError) Error() string {
+	return fmt.Sprintf("The response body exceeds %d bytes.", err.MaxSize)
+}
+
+// DoTracedRequest performs the HTTP request and reads the response body (and closes it).
+// A body of more than MaxResponseBodySize bytes results in a *ResponseBodyTooLargeError, which is returned together with the response.
+// The details of the exchange are added to the retrieval trace of ctx (if any).
+func DoTracedRequest(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	start := time.Now()
+	httpTrace := HttpTrace{Method: req.Method, Url: req.URL.String()}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		httpTrace.Error = err.Error()
+		httpTrace.TotalTime = time.Since(start).Nanoseconds() / 1000000
+		GetRetrievalTrace(ctx).SetHttp(httpTrace)
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	httpTrace.Status = res.Status
+	httpTrace.StatusCode = res.StatusCode
+	httpTrace.Headers = res.Header
+	httpTrace.ResponseTime = time.Since(start).Nanoseconds() / 1000000
+
+	// one more byte than allowed is read to tell a truncated body from one of exactly the maximum size
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, MaxResponseBodySize+1))
+	if err == nil && int64(len(body)) > MaxResponseBodySize {
+		body = body[:MaxResponseBodySize]
+		httpTrace.Truncated = true
+		err = &ResponseBodyTooLargeError{MaxSize: MaxResponseBodySize}
+	}
+	httpTrace.BodySize = len(body)
+	httpTrace.TotalTime = time.Since(start).Nanoseconds() / 1000000
+	if err != nil {
+		httpTrace.Error = err.Error()
+	}
+	GetRetrievalTrace(ctx).SetHttp(httpTrace)
+
+	if httpTrace.Truncated {
+		return res, nil, err
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return res, body, nil
+}
+
+// TraceDomSelection adds the number of matching DOM nodes and the HTML of the first ones to the retrieval trace of ctx (if any).
+func TraceDomSelection(ctx context.Context, cssPath string, selection *goquery.Selection) {
+	trace := GetRetrievalTrace(ctx)
+	if trace == nil {
+		return
+	}
+
+	domTrace := DomTrace{CssPath: cssPath, Matches: selection.Length(), Snippets: make([]string, 0, TraceMaxSnippets)}
+	for i := 0; i < domTrace.Matches && i < TraceMaxSnippets; i++ {
+		snippet, err := selection.Eq(i).Html()
+		if err != nil {
+			snippet = "(" + err.Error() + ")"
+		}
+		domTrace.Snippets = append(domTrace.Snippets, ShortenSnippet(snippet, TraceMaxSnippetLength))
+	}
+
+	trace.SetDom(domTrace)
+}
+
+// ShortenSnippet cuts the snippet after maxLength bytes (without splitting UTF-8 characters).
+func ShortenSnippet(snippet string, maxLength int) string {
+	if len(snippet) <= maxLength {
+		return snippet
+	}
+
+	cut := 0
+	for i := range snippet {
+		if i > maxLength {
+			break
+		}
+		cut = i
+	}
+	return snippet[:cut] + "…"
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+/* ***** ██████╗ ███████╗██████╗  ██████╗ ██████╗ ████████╗██╗███╗   ██╗ ██████╗  ***** */
+/* ***** ██╔══██╗██╔════╝██╔══██╗██╔═══██╗██╔══██╗╚══██╔══╝██║████╗  ██║██╔════╝  ***** */
+/* ***** ██████╔╝█████╗  ██████╔╝██║   ██║██████╔╝   ██║   ██║██╔██╗ ██║██║  ███╗ ***** */
+/* ***** ██╔══██╗██╔══╝  ██╔═══╝ ██║   ██║██╔══██╗   ██║   ██║██║╚██╗██║██║   ██║ ***** */
+/* ***** ██║  ██║███████╗██║     ╚██████╔╝██║  ██║   ██║   ██║██║ ╚████║╚██████╔╝ ***** */
+/* ***** ╚═╝  ╚═╝╚══════╝╚═╝      ╚═════╝ ╚═╝  ╚═╝   ╚═╝   ╚═╝╚═╝  ╚═══╝ ╚═════╝  ***** */
+/* ***** Banner comment generated by http://patorjk.com/software/taag ***** ***** ***** */
+
+// TODO: Refactor this section to a separate package!
+// TODO: Add test cases
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+type Reporter interface {
+	OnSample(sample *Sample)
+	Prepare() error
+	ShutDown() error
+}
+
+type ReportingEngine interface {
+	Register(reporters ...Reporter) error
+	Distribute(sample *Sample)
+	ShutDown() error
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func NewSocketIOReporter(socketIOApi SocketIOApi) SocketIOReporter {
+	return SocketIOReporter{socketIOApi: socketIOApi}
+}
+
+type SocketIOReporter struct {
+	socketIOApi SocketIOApi
+}
+
+func (r SocketIOReporter) OnSample(sample *Sample) {
+	r.socketIOApi.Broadcast(sample)
+}
+
+func (r SocketIOReporter) Prepare() error {
+	return nil
+}
+
+func (r SocketIOReporter) ShutDown() error {
+	return nil
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func NewConsoleReporter(prefix string) ConsoleReporter {
+	return ConsoleReporter{prefix: prefix}
+}
+
+type ConsoleReporter struct {
+	prefix string
+}
+
+func (r ConsoleReporter) OnSample(sample *Sample) {
+	fmt.Printf("%s%s\n", r.prefix, sample)
+}
+
+func (r ConsoleReporter) Prepare() error {
+	return nil
+}
+
+func (r ConsoleReporter) ShutDown() error {
+	return nil
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+const (
+	SampleWriteAheadLogFileSuffix = ".wal"
+	SampleSpillLogFileSuffix      = ".spill"
+)
+
+// SampleWriteAheadLog is an append-only file containing the samples that have been received since the last flush.
+// Every entry consists of the length (uint32, big-endian) and the CRC-32 checksum (IEEE, uint32, big-endian)
+// of the sample record followed by the sample record itself.
+// A tombstone entry has the highest bit of its length set and contains the key of a previously logged sample instead.
+// It removes the earliest remaining sample with that key from the log, e.g. after the sample has been dropped from the buffer.
+// Entries that have been torn apart by a crash are detected by their length or checksum and get discarded.
+func NewSampleWriteAheadLog(fileAbsPath string) *SampleWriteAheadLog {
+	return &SampleWriteAheadLog{fileAbsPath: fileAbsPath}
+}
+
+const sampleWriteAheadLogTombstoneFlag = uint32(1) << 31
+
+type SampleWriteAheadLog struct {
+	fileAbsPath string
+	file        *os.File
+}
+
+// Open opens (or creates) the log file and returns the samples that are still contained in it.
+func (wal *SampleWriteAheadLog) Open() ([]*Sample, error) {
+	file, err := os.OpenFile(wal.fileAbsPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, validLength, err := readSampleWriteAheadLog(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return samples, wal.openAt(file, validLength)
+}
+
+// OpenStreaming is like Open, but passes the contained samples to fn in batches of at most batchSize samples
+// instead of returning them at once. Tombstones are ignored, i.e. it's meant for logs that don't contain any.
+func (wal *SampleWriteAheadLog) OpenStreaming(batchSize int, fn func(samples []*Sample) error) error {
+	file, err := os.OpenFile(wal.fileAbsPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	validLength, err := scanSampleWriteAheadLog(file, batchSize, fn)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return wal.openAt(file, validLength)
+}
+
+// openAt cuts off everything behind validLength and prepares the file for appending.
+func (wal *SampleWriteAheadLog) openAt(file *os.File, validLength int64) error {
+	var err error
+
+	// a torn tail has to be cut off, otherwise it would precede the entries that are appended from now on
+	err = file.Truncate(validLength)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	_, err = file.Seek(validLength, os.SEEK_SET)
+	if err != nil {
+		file.Close()
+		return err
 	}
 
+	wal.file = file
 	return nil
 }
 
-func (this *Sample) String() string {
-	if len(this.Value) > 0 {
-		return fmt.Sprintf("[%s] -> %s", this.Timestamp.UTC().Format(time.RFC1123Z), this.Value)
-	} else {
-		return fmt.Sprintf("[%s] -> %s", this.Timestamp.UTC().Format(time.RFC1123Z), this.Err)
+// readSampleWriteAheadLog returns all intact samples that haven't been removed by a tombstone
+// and the number of bytes the intact entries occupy at the beginning of the file.
+func readSampleWriteAheadLog(file *os.File) ([]*Sample, int64, error) {
+	// removed samples leave a nil behind, so that the indices of the remaining ones stay valid
+	samples := []*Sample{}
+	indicesByKey := map[string][]int{}
+
+	validLength, err := walkSampleWriteAheadLog(file, func(sample *Sample, tombstoneKey string) error {
+		if sample != nil {
+			key := sample.Key()
+			indicesByKey[key] = append(indicesByKey[key], len(samples))
+			samples = append(samples, sample)
+			return nil
+		}
+
+		indices := indicesByKey[tombstoneKey]
+		if len(indices) > 0 {
+			samples[indices[0]] = nil
+			indicesByKey[tombstoneKey] = indices[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	remainingSamples := samples[:0]
+	for _, sample := range samples {
+		if sample != nil {
+			remainingSamples = append(remainingSamples, sample)
+		}
 	}
+
+	return remainingSamples, validLength, nil
 }
 
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+// scanSampleWriteAheadLog passes the intact samples to fn in batches of at most batchSize samples
+// and returns the number of bytes the intact entries occupy at the beginning of the file.
+func scanSampleWriteAheadLog(file *os.File, batchSize int, fn func(samples []*Sample) error) (int64, error) {
+	batch := make([]*Sample, 0, batchSize)
 
-func NewUrlScraper(abstractDataSource AbstractDataSource, url string, cssPath string, transformationScript string) *UrlScraper {
-	return &UrlScraper{
-		AbstractDataSource:   abstractDataSource,
-		url:                  url,
-		cssPath:              cssPath,
-		jsEngine:             otto.New(),
-		transformationScript: transformationScript,
+	validLength, err := walkSampleWriteAheadLog(file, func(sample *Sample, tombstoneKey string) error {
+		if sample == nil {
+			return nil
+		}
+
+		batch = append(batch, sample)
+		if len(batch) < batchSize {
+			return nil
+		}
+
+		err := fn(batch)
+		batch = batch[:0]
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(batch) > 0 {
+		err = fn(batch)
+		if err != nil {
+			return 0, err
+		}
 	}
+
+	return validLength, nil
 }
 
-type UrlScraper struct {
-	AbstractDataSource
-	url                  string
-	cssPath              string
-	jsEngine             *otto.Otto
-	transformationScript string
+// walkSampleWriteAheadLog calls fn for every intact entry, either with the sample or with the key of a tombstone.
+// It returns the number of bytes the intact entries occupy at the beginning of the file.
+func walkSampleWriteAheadLog(file *os.File, fn func(sample *Sample, tombstoneKey string) error) (int64, error) {
+	_, err := file.Seek(0, os.SEEK_SET)
+	if err != nil {
+		return 0, err
+	}
+
+	var validLength int64 = 0
+	header := make([]byte, 8)
+
+	for {
+		_, err = io.ReadFull(file, header)
+		if err == io.EOF {
+			return validLength, nil
+		} else if err == io.ErrUnexpectedEOF {
+			fmt.Printf("[SampleWriteAheadLog] Discarding torn entry at offset %d\n", validLength)
+			return validLength, nil
+		} else if err != nil {
+			return 0, err
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		isTombstone := length&sampleWriteAheadLogTombstoneFlag != 0
+
+		recordBytes := make([]byte, length&^sampleWriteAheadLogTombstoneFlag)
+		_, err = io.ReadFull(file, recordBytes)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			fmt.Printf("[SampleWriteAheadLog] Discarding torn entry at offset %d\n", validLength)
+			return validLength, nil
+		} else if err != nil {
+			return 0, err
+		}
+
+		if crc32.ChecksumIEEE(recordBytes) != binary.BigEndian.Uint32(header[4:8]) {
+			fmt.Printf("[SampleWriteAheadLog] Discarding corrupt entry at offset %d\n", validLength)
+			return validLength, nil
+		}
+
+		if isTombstone {
+			err = fn(nil, string(recordBytes))
+		} else {
+			sample := new(Sample)
+			err = sample.GobDecode(recordBytes)
+			if err != nil {
+				fmt.Printf("[SampleWriteAheadLog] Couldn't read sample at offset %d due to: %s\n", validLength, err.Error())
+				err = nil
+			} else {
+				err = fn(sample, "")
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		validLength += int64(len(header) + len(recordBytes))
+	}
 }
 
-func (this *UrlScraper) Retrieve(sampleChan chan *Sample) {
-	t := time.Now()
-	doc, err := goquery.NewDocument(this.url)
+// ForEachBatch passes the samples of the log to fn in batches of at most batchSize samples,
+// so that a large log doesn't have to be loaded into memory at once. Tombstones are ignored.
+// New samples are still appended afterwards.
+func (wal *SampleWriteAheadLog) ForEachBatch(batchSize int, fn func(samples []*Sample) error) error {
+	_, err := scanSampleWriteAheadLog(wal.file, batchSize, fn)
 	if err != nil {
-		sampleChan <- NewSample("", t, this.dataSourceId, err)
-		return
+		return err
 	}
 
-	value := doc.Find(this.cssPath).Text()
-	if len(value) == 0 {
-		sampleChan <- NewSample("", t, this.dataSourceId, errors.New("The specified CSS path is invalid or doesn't match any DOM nodes."))
-		return
+	_, err = wal.file.Seek(0, os.SEEK_END)
+	return err
+}
+
+// Append writes the sample to the log and syncs the file, so that the sample survives a crash.
+func (wal *SampleWriteAheadLog) Append(sample *Sample) error {
+	recordBytes, err := sample.GobEncode()
+	if err != nil {
+		return err
+	}
+
+	return wal.appendEntry(recordBytes, 0)
+}
+
+// AppendTombstone removes the earliest logged sample with the same key as the given one from the log.
+func (wal *SampleWriteAheadLog) AppendTombstone(sample *Sample) error {
+	return wal.appendEntry([]byte(sample.Key()), sampleWriteAheadLogTombstoneFlag)
+}
+
+func (wal *SampleWriteAheadLog) appendEntry(recordBytes []byte, flags uint32) error {
+	_, err := wal.file.Write(encodeSampleWriteAheadLogEntry(recordBytes, flags))
+	if err != nil {
+		return err
 	}
 
-	if len(this.transformationScript) > 0 {
-		// TODO: perform some JS sanitation to prevent injection of harmful JS code
-		value = strings.Replace(value, "'", "\\'", -1)
-		value = strings.Replace(value, "\n", "", -1)
-		value = strings.Replace(value, "\r", "", -1)
+	return wal.file.Sync()
+}
+
+func encodeSampleWriteAheadLogEntry(recordBytes []byte, flags uint32) []byte {
+	entry := make([]byte, 8, 8+len(recordBytes))
+	binary.BigEndian.PutUint32(entry[0:4], uint32(len(recordBytes))|flags)
+	binary.BigEndian.PutUint32(entry[4:8], crc32.ChecksumIEEE(recordBytes))
+	return append(entry, recordBytes...)
+}
+
+// Rewrite replaces the content of the log with the given samples, e.g. to get rid of tombstones.
+// The new content is written to a temporary file first, which replaces the log afterwards.
+func (wal *SampleWriteAheadLog) Rewrite(samples []*Sample) error {
+	tmpFileAbsPath := wal.fileAbsPath + ".tmp"
+	tmpFile, err := os.OpenFile(tmpFileAbsPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
 
-		_, err = this.jsEngine.Run("var value = '" + value + "';")
+	for _, sample := range samples {
+		var recordBytes []byte
+		recordBytes, err = sample.GobEncode()
+		if err == nil {
+			_, err = tmpFile.Write(encodeSampleWriteAheadLogEntry(recordBytes, 0))
+		}
 		if err != nil {
-			sampleChan <- NewSample("", t, this.dataSourceId, err)
-			return
+			tmpFile.Close()
+			os.Remove(tmpFileAbsPath)
+			return err
 		}
+	}
 
-		jsValue, err := this.jsEngine.Run("value = " + this.transformationScript + ";")
-		if err != nil {
-			sampleChan <- NewSample("", t, this.dataSourceId, err)
-			return
+	err = tmpFile.Sync()
+	if err == nil {
+		err = os.Rename(tmpFileAbsPath, wal.fileAbsPath)
+	}
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFileAbsPath)
+		return err
+	}
+
+	wal.file.Close()
+	wal.file = tmpFile
+	return nil
+}
+
+// Truncate empties the log. It must only be called after all logged samples have been persisted.
+func (wal *SampleWriteAheadLog) Truncate() error {
+	err := wal.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = wal.file.Seek(0, os.SEEK_SET)
+	if err != nil {
+		return err
+	}
+
+	return wal.file.Sync()
+}
+
+func (wal *SampleWriteAheadLog) Close() error {
+	return wal.file.Close()
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// Policies for samples that arrive while the buffer of the PersistentDataStoreReporter is full.
+const (
+	// BufferOverflowBlock stops accepting samples until the next successful flush.
+	// A sample that still can't be accepted after one flush interval is dropped,
+	// so that the number of waiting samples stays bounded while the data store is unavailable.
+	BufferOverflowBlock = "block"
+	// BufferOverflowDropOldest discards the oldest buffered sample in favor of the new one.
+	BufferOverflowDropOldest = "drop-oldest"
+	// BufferOverflowSpill writes the new sample to the spill log. Spilled samples are persisted after the buffer has been flushed.
+	// Please note that GetSamples() reads them from the spill log until then, but GetLatestSamples() doesn't see them.
+	BufferOverflowSpill = "spill"
+)
+
+type SampleBufferStats struct {
+	Capacity       int
+	OverflowPolicy string
+	Buffered       int
+	Spilled        int
+	TotalDropped   int
+	TotalRetried   int
+	TotalSpilled   int
+	FailedFlushes  int
+	LastFlush      time.Time
+	LastFlushError error
+}
+
+// NewPersistentDataStoreReporter creates a reporter that buffers up to bufferSize samples and flushes them to the data store periodically.
+// Buffered samples are additionally written to the write-ahead log. They get replayed by Prepare() after a crash.
+// Samples that are dropped from the buffer get a tombstone in the write-ahead log, so that they aren't replayed.
+// Samples of failed flushes stay in the buffer and are retried with the next flush.
+func NewPersistentDataStoreReporter(dataStore DataStore, writeAheadLog *SampleWriteAheadLog, spillLog *SampleWriteAheadLog, flushInterval time.Duration, bufferSize int, overflowPolicy string) *PersistentDataStoreReporter {
+	r := &PersistentDataStoreReporter{
+		dataStore:                     dataStore,
+		writeAheadLog:                 writeAheadLog,
+		spillLog:                      spillLog,
+		flushInterval:                 flushInterval,
+		flushTicker:                   time.NewTicker(flushInterval),
+		sampleChan:                    make(chan *Sample),
+		sampleRequestChan:             make(chan SampleRetrievalRequest),
+		quantitativeSampleRequestChan: make(chan QuantitativeSampleRetrievalRequest),
+		discardRequestChan:            make(chan SampleDiscardRequest),
+		statsRequestChan:              make(chan chan SampleBufferStats),
+		shutDownChan:                  make(chan chan error),
+		doneChan:                      make(chan bool),
+		stats:                         SampleBufferStats{Capacity: bufferSize, OverflowPolicy: overflowPolicy},
+	}
+
+	go func() {
+		for {
+			// receiving from a nil channel blocks forever, i.e. samples aren't accepted while the buffer is full
+			sampleChan := r.sampleChan
+			if r.stats.OverflowPolicy == BufferOverflowBlock && len(r.buffer) >= r.stats.Capacity {
+				sampleChan = nil
+			}
+
+			select {
+			case <-r.flushTicker.C:
+				err := r.flush()
+				if err != nil {
+					fmt.Println("[PersistentDataStoreReporter] Couldn't persist samples due to:", err)
+				}
+
+			case sample := <-sampleChan:
+				if len(r.buffer) >= r.stats.Capacity {
+					if r.stats.OverflowPolicy == BufferOverflowSpill {
+						err := r.spillLog.Append(sample)
+						if err != nil {
+							fmt.Println("[PersistentDataStoreReporter] Couldn't spill sample due to:", err)
+							r.stats.TotalDropped++
+						} else {
+							r.stats.Spilled++
+							r.stats.TotalSpilled++
+						}
+						continue
+					}
+
+					// BufferOverflowDropOldest
+					droppedSample := r.buffer[0]
+					fmt.Printf("[PersistentDataStoreReporter] Buffer is full, dropping sample %s\n", droppedSample.Key())
+					copy(r.buffer, r.buffer[1:])
+					r.buffer = r.buffer[:len(r.buffer)-1]
+					if r.failedSamples > 0 {
+						r.failedSamples--
+					}
+					r.stats.TotalDropped++
+					r.dropFromWriteAheadLog(droppedSample)
+				}
+
+				err := r.writeAheadLog.Append(sample)
+				if err != nil {
+					fmt.Println("[PersistentDataStoreReporter] Couldn't write sample to the write-ahead log due to:", err)
+				}
+
+				// it might be better to impl. a custom append() function for performance reasons
+				// but in the first version the built-in one is sufficient
+				r.buffer = append(r.buffer, sample)
+
+			case sampleRetrievalRequest := <-r.sampleRequestChan:
+				var eligibleSamples []*Sample
+
+				for _, sample := range r.buffer {
+					doesDataSourceIdMatch := sample.DataSourceId == sampleRetrievalRequest.DataSourceId
+					doesFromTimeMatch := sample.Timestamp.Equal(sampleRetrievalRequest.From) || sample.Timestamp.After(sampleRetrievalRequest.From)
+					doesToTimeMatch := sample.Timestamp.Equal(sampleRetrievalRequest.To) || sample.Timestamp.Before(sampleRetrievalRequest.To)
+
+					if doesDataSourceIdMatch && doesFromTimeMatch && doesToTimeMatch {
+						eligibleSamples = append(eligibleSamples, sample)
+					}
+				}
+
+				if r.stats.Spilled > 0 {
+					spilledSamples, err := r.getSpilledSamples(sampleRetrievalRequest)
+					if err != nil {
+						fmt.Println("[PersistentDataStoreReporter] Couldn't read spilled samples due to:", err)
+					}
+					eligibleSamples = append(eligibleSamples, spilledSamples...)
+				}
+
+				sampleRetrievalRequest.ResponseChan <- eligibleSamples
+
+			case quantitativeSampleRetrievalRequest := <-r.quantitativeSampleRequestChan:
+				var eligibleSamples []*Sample
+
+				for _, sample := range r.buffer {
+					if len(eligibleSamples) < quantitativeSampleRetrievalRequest.Quantity && sample.DataSourceId == quantitativeSampleRetrievalRequest.DataSourceId {
+						eligibleSamples = append(eligibleSamples, sample)
+					}
+				}
+
+				quantitativeSampleRetrievalRequest.ResponseChan <- eligibleSamples
+
+			case discardRequest := <-r.discardRequestChan:
+				discardRequest.ResponseChan <- r.discard(discardRequest.DataSourceId)
+
+			case responseChan := <-r.statsRequestChan:
+				stats := r.stats
+				stats.Buffered = len(r.buffer)
+				stats.TotalDropped += int(atomic.LoadInt64(&r.rejectedSamples))
+				responseChan <- stats
+
+			case responseChan := <-r.shutDownChan:
+				r.flushTicker.Stop()
+				// samples that are still waiting to be accepted are dropped
+				close(r.doneChan)
+
+				err := r.flush()
+				if err != nil {
+					fmt.Println("[PersistentDataStoreReporter] Couldn't persist samples due to:", err)
+					fmt.Println("[PersistentDataStoreReporter] They will be replayed from the write-ahead log on next start.")
+				}
+
+				closeErr := r.writeAheadLog.Close()
+				if err == nil {
+					err = closeErr
+				}
+
+				closeErr = r.spillLog.Close()
+				if err == nil {
+					err = closeErr
+				}
+
+				responseChan <- err
+				return
+			}
 		}
+	}()
 
-		value = jsValue.String()
-		if len(value) == 0 {
-			sampleChan <- NewSample("", t, this.dataSourceId, errors.New("Couldn't perform the provided JS transformation."))
-			return
+	return r
+}
+
+type PersistentDataStoreReporter struct {
+	dataStore                     DataStore
+	writeAheadLog                 *SampleWriteAheadLog
+	spillLog                      *SampleWriteAheadLog
+	buffer                        []*Sample
+	failedSamples                 int // number of samples at the beginning of the buffer whose last flush failed
+	tombstones                    int // number of tombstones in the write-ahead log
+	rejectedSamples               int64
+	stats                         SampleBufferStats
+	flushInterval                 time.Duration
+	flushTicker                   *time.Ticker
+	sampleChan                    chan *Sample
+	sampleRequestChan             chan SampleRetrievalRequest
+	quantitativeSampleRequestChan chan QuantitativeSampleRetrievalRequest
+	discardRequestChan            chan SampleDiscardRequest
+	statsRequestChan              chan chan SampleBufferStats
+	shutDownChan                  chan chan error
+	doneChan                      chan bool
+}
+
+// dropFromWriteAheadLog adds a tombstone for the dropped sample to the write-ahead log.
+// Once there are more tombstones than the buffer can hold, the log is rewritten with the buffered samples only,
+// so that it doesn't grow while the buffer stays full.
+func (r *PersistentDataStoreReporter) dropFromWriteAheadLog(droppedSample *Sample) {
+	var err error
+	if r.tombstones >= r.stats.Capacity {
+		err = r.writeAheadLog.Rewrite(r.buffer)
+		if err == nil {
+			r.tombstones = 0
+		}
+	} else {
+		err = r.writeAheadLog.AppendTombstone(droppedSample)
+		if err == nil {
+			r.tombstones++
 		}
 	}
 
-	sampleChan <- NewSample(value, t, this.dataSourceId, nil)
+	if err != nil {
+		fmt.Println("[PersistentDataStoreReporter] Couldn't remove dropped sample from the write-ahead log due to:", err)
+	}
 }
 
-func (this *UrlScraper) Type() string {
-	return DsUrlScraper
+// discard removes the samples of the data source from the buffer, the write-ahead log, and the spill log.
+func (r *PersistentDataStoreReporter) discard(dataSourceId string) error {
+	failedSamples := r.failedSamples
+	remainingSamples := r.buffer[:0]
+	for i, sample := range r.buffer {
+		if sample.DataSourceId != dataSourceId {
+			remainingSamples = append(remainingSamples, sample)
+		} else if i < failedSamples {
+			r.failedSamples--
+		}
+	}
+	r.buffer = remainingSamples
+
+	err := r.writeAheadLog.Rewrite(r.buffer)
+	if err != nil {
+		return err
+	}
+	r.tombstones = 0
+
+	if r.stats.Spilled == 0 {
+		return nil
+	}
+
+	remainingSpilledSamples := []*Sample{}
+	err = r.spillLog.ForEachBatch(r.stats.Capacity, func(samples []*Sample) error {
+		for _, sample := range samples {
+			if sample.DataSourceId != dataSourceId {
+				remainingSpilledSamples = append(remainingSpilledSamples, sample)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = r.spillLog.Rewrite(remainingSpilledSamples)
+	if err != nil {
+		return err
+	}
+	r.stats.Spilled = len(remainingSpilledSamples)
+
+	return nil
 }
 
-func (this *UrlScraper) GobEncode() ([]byte, error) {
-	// TODO: It might make sense to include a version number in the encoding due to future changes.
+// flush persists the buffered samples and afterwards the spilled ones. The buffer and the logs are only cleared on success,
+// so that failed samples are retried with the next flush.
+func (r *PersistentDataStoreReporter) flush() error {
+	err := r.flushBuffer()
+	if err == nil {
+		err = r.flushSpillLog()
+	}
+
+	if err != nil {
+		r.stats.FailedFlushes++
+		r.stats.LastFlushError = err
+	} else {
+		r.stats.LastFlush = time.Now()
+		r.stats.LastFlushError = nil
+	}
+
+	return err
+}
 
-	fmt.Println("   [UrlScraper]   GobEncode()")
+func (r *PersistentDataStoreReporter) flushBuffer() error {
+	if len(r.buffer) == 0 {
+		return nil
+	}
 
-	buff := new(bytes.Buffer)
-	encoder := gob.NewEncoder(buff)
+	r.stats.TotalRetried += r.failedSamples
 
-	err := encoder.Encode(this.AbstractDataSource)
+	err := r.dataStore.PersistSamples(r.buffer)
 	if err != nil {
-		return nil, err
+		r.failedSamples = len(r.buffer)
+		return err
 	}
 
-	err = encoder.Encode(this.url)
+	// clear buffer
+	// Attention! This might produce a memory leak...
+	// see: http://stackoverflow.com/questions/16971741/how-do-you-clear-a-slice-in-go
+	r.buffer = r.buffer[:0]
+	r.failedSamples = 0
+
+	err = r.writeAheadLog.Truncate()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	err = encoder.Encode(this.cssPath)
-	if err != nil {
-		return nil, err
+	r.tombstones = 0
+	return nil
+}
+
+// flushSpillLog persists the spilled samples in batches of the buffer's capacity, because the spill log may have grown
+// much larger than the buffer during a long outage. Persisting a batch twice after a failure is harmless.
+func (r *PersistentDataStoreReporter) flushSpillLog() error {
+	if r.stats.Spilled == 0 {
+		return nil
 	}
 
-	err = encoder.Encode(this.transformationScript)
+	err := r.spillLog.ForEachBatch(r.stats.Capacity, r.dataStore.PersistSamples)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return buff.Bytes(), nil
+	r.stats.Spilled = 0
+	return r.spillLog.Truncate()
 }
 
-func (this *UrlScraper) GobDecode(urlScraperBytes []byte) error {
-	// TODO: It might make sense to include a version number in the encoding due to future changes.
-	fmt.Println("   [UrlScraper]   GobDecode()")
+// getSpilledSamples returns the spilled samples that match the request.
+func (r *PersistentDataStoreReporter) getSpilledSamples(request SampleRetrievalRequest) ([]*Sample, error) {
+	var eligibleSamples []*Sample
 
-	buff := bytes.NewBuffer(urlScraperBytes)
-	decoder := gob.NewDecoder(buff)
+	err := r.spillLog.ForEachBatch(r.stats.Capacity, func(samples []*Sample) error {
+		for _, sample := range samples {
+			if sample.DataSourceId == request.DataSourceId && !sample.Timestamp.Before(request.From) && !sample.Timestamp.After(request.To) {
+				eligibleSamples = append(eligibleSamples, sample)
+			}
+		}
+		return nil
+	})
 
-	err := decoder.Decode(&this.AbstractDataSource)
+	return eligibleSamples, err
+}
+
+// OnSample hands the sample over to the buffer. With the block policy it waits at most one flush interval
+// for the buffer to accept the sample, otherwise the sample is counted as dropped.
+func (r *PersistentDataStoreReporter) OnSample(sample *Sample) {
+	if r.stats.OverflowPolicy != BufferOverflowBlock {
+		select {
+		case r.sampleChan <- sample:
+		case <-r.doneChan:
+			fmt.Printf("[PersistentDataStoreReporter] Already shut down, dropping sample %s\n", sample.Key())
+			atomic.AddInt64(&r.rejectedSamples, 1)
+		}
+		return
+	}
+
+	timer := time.NewTimer(r.flushInterval)
+	defer timer.Stop()
+
+	select {
+	case r.sampleChan <- sample:
+	case <-timer.C:
+		fmt.Printf("[PersistentDataStoreReporter] Buffer is still full, dropping sample %s\n", sample.Key())
+		atomic.AddInt64(&r.rejectedSamples, 1)
+	case <-r.doneChan:
+		fmt.Printf("[PersistentDataStoreReporter] Already shut down, dropping sample %s\n", sample.Key())
+		atomic.AddInt64(&r.rejectedSamples, 1)
+	}
+}
+
+func (r *PersistentDataStoreReporter) GetSamples(dataSourceId string, from time.Time, to time.Time) []*Sample {
+	responseChan := make(chan []*Sample)
+	sampleRetrievalRequest := SampleRetrievalRequest{DataSourceId: dataSourceId, From: from, To: to, ResponseChan: responseChan}
+	r.sampleRequestChan <- sampleRetrievalRequest
+	return <-responseChan
+}
+
+func (r *PersistentDataStoreReporter) GetLatestSamples(dataSourceId string, num int) []*Sample {
+	responseChan := make(chan []*Sample)
+	sampleRetrievalRequest := QuantitativeSampleRetrievalRequest{DataSourceId: dataSourceId, Quantity: num, ResponseChan: responseChan}
+	r.quantitativeSampleRequestChan <- sampleRetrievalRequest
+	return <-responseChan
+}
+
+// Prepare prepares the data store and replays the samples of the write-ahead log that haven't been flushed before the last shutdown.
+func (r *PersistentDataStoreReporter) Prepare() error {
+	err := r.dataStore.Prepare()
 	if err != nil {
 		return err
 	}
 
-	err = decoder.Decode(&this.url)
+	samples, err := r.writeAheadLog.Open()
 	if err != nil {
 		return err
 	}
 
-	err = decoder.Decode(&this.cssPath)
+	if len(samples) > 0 {
+		fmt.Printf("[PersistentDataStoreReporter] Replaying %d samples from the write-ahead log\n", len(samples))
+		// persisting a sample twice is harmless, so it doesn't matter whether they have been flushed partially before
+		err = r.dataStore.PersistSamples(samples)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = r.writeAheadLog.Truncate()
 	if err != nil {
 		return err
 	}
 
-	err = decoder.Decode(&this.transformationScript)
+	spilledSamples := 0
+	err = r.spillLog.OpenStreaming(r.stats.Capacity, func(samples []*Sample) error {
+		spilledSamples += len(samples)
+		return r.dataStore.PersistSamples(samples)
+	})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if spilledSamples > 0 {
+		fmt.Printf("[PersistentDataStoreReporter] Persisted %d spilled samples\n", spilledSamples)
+	}
+
+	return r.spillLog.Truncate()
 }
 
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+// DiscardSamples removes the buffered and spilled samples of the data source (e.g. because they are about to be purged),
+// so that they can't be replayed after a crash either.
+func (r *PersistentDataStoreReporter) DiscardSamples(dataSourceId string) error {
+	responseChan := make(chan error)
+	r.discardRequestChan <- SampleDiscardRequest{DataSourceId: dataSourceId, ResponseChan: responseChan}
+	return <-responseChan
+}
 
-/* ***** ██████╗ ███████╗██████╗  ██████╗ ██████╗ ████████╗██╗███╗   ██╗ ██████╗  ***** */
-/* ***** ██╔══██╗██╔════╝██╔══██╗██╔═══██╗██╔══██╗╚══██╔══╝██║████╗  ██║██╔════╝  ***** */
-/* ***** ██████╔╝█████╗  ██████╔╝██║   ██║██████╔╝   ██║   ██║██╔██╗ ██║██║  ███╗ ***** */
-/* ***** ██╔══██╗██╔══╝  ██╔═══╝ ██║   ██║██╔══██╗   ██║   ██║██║╚██╗██║██║   ██║ ***** */
-/* ***** ██║  ██║███████╗██║     ╚██████╔╝██║  ██║   ██║   ██║██║ ╚████║╚██████╔╝ ***** */
-/* ***** ╚═╝  ╚═╝╚══════╝╚═╝      ╚═════╝ ╚═╝  ╚═╝   ╚═╝   ╚═╝╚═╝  ╚═══╝ ╚═════╝  ***** */
-/* ***** Banner comment generated by http://patorjk.com/software/taag ***** ***** ***** */
+func (r *PersistentDataStoreReporter) Stats() SampleBufferStats {
+	responseChan := make(chan SampleBufferStats)
+	r.statsRequestChan <- responseChan
+	return <-responseChan
+}
 
-// TODO: Refactor this section to a separate package!
-// TODO: Add test cases
+// ShutDown stops the flush ticker, flushes the remaining samples, and afterwards shuts down the data store.
+func (r *PersistentDataStoreReporter) ShutDown() error {
+	responseChan := make(chan error)
+	r.shutDownChan <- responseChan
+	flushErr := <-responseChan
+
+	err := r.dataStore.ShutDown()
+	if flushErr != nil {
+		return flushErr
+	} else {
+		return err
+	}
+}
 
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
-type Reporter interface {
-	OnSample(sample *Sample)
-	Prepare() error
-	ShutDown() error
-}
+const (
+	DataSourceStatusRecordType    = "DataSourceStatus"
+	DataSourceStatusRecordVersion = uint16(1)
+)
 
-type ReportingEngine interface {
-	Register(reporters ...Reporter)
-	Distribute(sample *Sample)
-	ShutDown() error
+// DataSourceStatus keeps track of the health of a data source. It's updated with every distributed sample.
+type DataSourceStatus struct {
+	DataSourceId        string
+	LastAttempt         time.Time
+	LastSuccess         time.Time
+	LastFailure         time.Time
+	LastError           string
+	ConsecutiveFailures int
+	Attempts            int
+	Successes           int
+	TotalLatency        time.Duration
+	TotalRetries        int
+	BreakerOpen         bool
+	BreakerOpenedAt     time.Time
 }
 
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+func (this *DataSourceStatus) Update(sample *Sample) {
+	this.LastAttempt = sample.Timestamp
+	this.Attempts++
+	this.TotalLatency += sample.Latency
+	this.TotalRetries += sample.Retries
 
-func NewSocketIOReporter(socketIOApi SocketIOApi) SocketIOReporter {
-	return SocketIOReporter{socketIOApi: socketIOApi}
-}
+	if sample.BreakerOpen && !this.BreakerOpen {
+		this.BreakerOpenedAt = sample.Timestamp
+	}
+	this.BreakerOpen = sample.BreakerOpen
 
-type SocketIOReporter struct {
-	socketIOApi SocketIOApi
+	if sample.Err != nil {
+		this.LastFailure = sample.Timestamp
+		this.LastError = sample.Err.Error()
+		this.ConsecutiveFailures++
+	} else {
+		this.LastSuccess = sample.Timestamp
+		this.Successes++
+		this.ConsecutiveFailures = 0
+	}
 }
 
-func (r SocketIOReporter) OnSample(sample *Sample) {
-	r.socketIOApi.Broadcast(sample)
+func (this DataSourceStatus) SuccessRatio() float64 {
+	if this.Attempts == 0 {
+		return 0
+	}
+	return float64(this.Successes) / float64(this.Attempts)
 }
 
-func (r SocketIOReporter) Prepare() error {
-	return nil
+func (this DataSourceStatus) AverageLatency() time.Duration {
+	if this.Attempts == 0 {
+		return 0
+	}
+	return this.TotalLatency / time.Duration(this.Attempts)
 }
 
-func (r SocketIOReporter) ShutDown() error {
-	return nil
+// dataSourceStatusRecordV1 has the same fields as DataSourceStatus, but no methods (gob would call GobEncode() recursively).
+type dataSourceStatusRecordV1 DataSourceStatus
+
+func (this *DataSourceStatus) GobEncode() ([]byte, error) {
+	return encodeGobPayload(DataSourceStatusRecordType, DataSourceStatusRecordVersion, (*dataSourceStatusRecordV1)(this))
 }
 
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+func (this *DataSourceStatus) GobDecode(statusBytes []byte) error {
+	record, err := DecodeRecord(statusBytes)
+	if err != nil {
+		return err
+	}
 
-func NewConsoleReporter(prefix string) ConsoleReporter {
-	return ConsoleReporter{prefix: prefix}
+	return RecordDecoders{
+		1: func(payload []byte) error {
+			return decodeGobPayload(payload, (*dataSourceStatusRecordV1)(this))
+		},
+	}.Decode(DataSourceStatusRecordType, record)
 }
 
-type ConsoleReporter struct {
-	prefix string
+// NewDataSourceStatusReporter creates a reporter that updates the status of a data source with every sample.
+// Changed statuses are persisted every flushInterval and on shutdown.
+func NewDataSourceStatusReporter(dataStore DataStore, flushInterval time.Duration) *DataSourceStatusReporter {
+	return &DataSourceStatusReporter{
+		dataStore:         dataStore,
+		flushInterval:     flushInterval,
+		statuses:          map[string]*DataSourceStatus{},
+		changed:           map[string]bool{},
+		forgotten:         map[string]bool{},
+		sampleChan:        make(chan *Sample),
+		statusRequestChan: make(chan chan map[string]DataSourceStatus),
+		forgetRequestChan: make(chan DataSourceStatusForgetRequest),
+		shutDownChan:      make(chan chan error),
+	}
 }
 
-func (r ConsoleReporter) OnSample(sample *Sample) {
-	fmt.Printf("%s%s\n", r.prefix, sample)
+type DataSourceStatusReporter struct {
+	dataStore         DataStore
+	flushInterval     time.Duration
+	statuses          map[string]*DataSourceStatus
+	changed           map[string]bool
+	forgotten         map[string]bool // ids of deleted data sources, whose samples might still be in flight
+	sampleChan        chan *Sample
+	statusRequestChan chan chan map[string]DataSourceStatus
+	forgetRequestChan chan DataSourceStatusForgetRequest
+	shutDownChan      chan chan error
 }
 
-func (r ConsoleReporter) Prepare() error {
-	return nil
+type DataSourceStatusForgetRequest struct {
+	DataSourceId string
+	ResponseChan chan bool
 }
 
-func (r ConsoleReporter) ShutDown() error {
-	return nil
+func (r *DataSourceStatusReporter) OnSample(sample *Sample) {
+	r.sampleChan <- sample
 }
 
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
-
-func NewPersistentDataStoreReporter(dataStore DataStore, flushInterval time.Duration) *PersistentDataStoreReporter {
-	r := &PersistentDataStoreReporter{
-		dataStore:                     dataStore,
-		flushTicker:                   time.NewTicker(flushInterval),
-		sampleChan:                    make(chan *Sample),
-		sampleRequestChan:             make(chan SampleRetrievalRequest),
-		quantitativeSampleRequestChan: make(chan QuantitativeSampleRetrievalRequest),
+// Prepare restores the persisted statuses. The reporter goroutine is started afterwards, so the statuses don't need to be synchronized.
+// The data store has to be prepared already.
+func (r *DataSourceStatusReporter) Prepare() error {
+	statuses, err := r.dataStore.GetDataSourceStatuses()
+	if err != nil {
+		return err
 	}
 
-	go func() {
-		for {
-			select {
-			case <-r.flushTicker.C:
-				samplesToFlush := r.buffer[0:len(r.buffer)]
-				err := r.dataStore.PersistSamples(samplesToFlush)
-				if err != nil {
-					fmt.Println("[PersistentDataStoreReporter] Couldn't persist samples due to:", err)
-				}
-
-				// clear buffer
-				// Attention! This might produce a memory leak...
-				// see: http://stackoverflow.com/questions/16971741/how-do-you-clear-a-slice-in-go
-				r.buffer = r.buffer[:0]
-
-			case sample := <-r.sampleChan:
-				// it might be better to impl. a custom append() function for performance reasons
-				// but in the first version the built-in one is sufficient
-				r.buffer = append(r.buffer, sample)
-
-			case sampleRetrievalRequest := <-r.sampleRequestChan:
-				var eligibleSamples []*Sample
+	for _, status := range statuses {
+		r.statuses[status.DataSourceId] = status
+	}
 
-				for _, sample := range r.buffer {
-					doesDataSourceIdMatch := sample.DataSourceId == sampleRetrievalRequest.DataSourceId
-					doesFromTimeMatch := sample.Timestamp.Equal(sampleRetrievalRequest.From) || sample.Timestamp.After(sampleRetrievalRequest.From)
-					doesToTimeMatch := sample.Timestamp.Equal(sampleRetrievalRequest.To) || sample.Timestamp.Before(sampleRetrievalRequest.To)
+	go r.run()
+	return nil
+}
 
-					if doesDataSourceIdMatch && doesFromTimeMatch && doesToTimeMatch {
-						eligibleSamples = append(eligibleSamples, sample)
-					}
-				}
+func (r *DataSourceStatusReporter) run() {
+	flushTicker := time.NewTicker(r.flushInterval)
 
-				sampleRetrievalRequest.ResponseChan <- eligibleSamples
+	for {
+		select {
+		case <-flushTicker.C:
+			err := r.flush()
+			if err != nil {
+				fmt.Println("[DataSourceStatusReporter] Couldn't persist statuses due to:", err)
+			}
 
-			case quantitativeSampleRetrievalRequest := <-r.quantitativeSampleRequestChan:
-				var eligibleSamples []*Sample
+		case sample := <-r.sampleChan:
+			if r.forgotten[sample.DataSourceId] {
+				continue
+			}
 
-				for _, sample := range r.buffer {
-					if len(eligibleSamples) < quantitativeSampleRetrievalRequest.Quantity && sample.DataSourceId == quantitativeSampleRetrievalRequest.DataSourceId {
-						eligibleSamples = append(eligibleSamples, sample)
-					}
-				}
+			status, ok := r.statuses[sample.DataSourceId]
+			if !ok {
+				status = &DataSourceStatus{DataSourceId: sample.DataSourceId}
+				r.statuses[sample.DataSourceId] = status
+			}
+			status.Update(sample)
+			r.changed[sample.DataSourceId] = true
 
-				quantitativeSampleRetrievalRequest.ResponseChan <- eligibleSamples
+		case responseChan := <-r.statusRequestChan:
+			statuses := make(map[string]DataSourceStatus, len(r.statuses))
+			for dataSourceId, status := range r.statuses {
+				statuses[dataSourceId] = *status
 			}
+			responseChan <- statuses
+
+		case forgetRequest := <-r.forgetRequestChan:
+			delete(r.statuses, forgetRequest.DataSourceId)
+			delete(r.changed, forgetRequest.DataSourceId)
+			// data source ids aren't reused, so the set only grows by the number of deleted data sources
+			r.forgotten[forgetRequest.DataSourceId] = true
+			forgetRequest.ResponseChan <- true
+
+		case responseChan := <-r.shutDownChan:
+			flushTicker.Stop()
+			responseChan <- r.flush()
+			return
 		}
-	}()
-
-	return r
+	}
 }
 
-type PersistentDataStoreReporter struct {
-	dataStore                     DataStore
-	buffer                        []*Sample
-	flushTicker                   *time.Ticker
-	sampleChan                    chan *Sample
-	sampleRequestChan             chan SampleRetrievalRequest
-	quantitativeSampleRequestChan chan QuantitativeSampleRetrievalRequest
-}
+// flush persists the statuses that have changed since the last flush.
+func (r *DataSourceStatusReporter) flush() error {
+	if len(r.changed) == 0 {
+		return nil
+	}
 
-func (r *PersistentDataStoreReporter) OnSample(sample *Sample) {
-	r.sampleChan <- sample
+	changedStatuses := make([]*DataSourceStatus, 0, len(r.changed))
+	for dataSourceId := range r.changed {
+		changedStatuses = append(changedStatuses, r.statuses[dataSourceId])
+	}
+
+	err := r.dataStore.PersistDataSourceStatuses(changedStatuses)
+	if err != nil {
+		return err
+	}
+
+	r.changed = map[string]bool{}
+	return nil
 }
 
-func (r *PersistentDataStoreReporter) GetSamples(dataSourceId string, from time.Time, to time.Time) []*Sample {
-	responseChan := make(chan []*Sample)
-	sampleRetrievalRequest := SampleRetrievalRequest{DataSourceId: dataSourceId, From: from, To: to, ResponseChan: responseChan}
-	r.sampleRequestChan <- sampleRetrievalRequest
+// GetStatuses returns a snapshot of the statuses of all data sources that have been retrieved at least once.
+func (r *DataSourceStatusReporter) GetStatuses() map[string]DataSourceStatus {
+	responseChan := make(chan map[string]DataSourceStatus)
+	r.statusRequestChan <- responseChan
 	return <-responseChan
 }
 
-func (r *PersistentDataStoreReporter) GetLatestSamples(dataSourceId string, num int) []*Sample {
-	responseChan := make(chan []*Sample)
-	sampleRetrievalRequest := QuantitativeSampleRetrievalRequest{DataSourceId: dataSourceId, Quantity: num, ResponseChan: responseChan}
-	r.quantitativeSampleRequestChan <- sampleRetrievalRequest
-	return <-responseChan
+// GetStatus returns an empty status if the data source hasn't been retrieved yet.
+func (r *DataSourceStatusReporter) GetStatus(dataSourceId string) DataSourceStatus {
+	status, ok := r.GetStatuses()[dataSourceId]
+	if !ok {
+		status = DataSourceStatus{DataSourceId: dataSourceId}
+	}
+	return status
 }
 
-func (r *PersistentDataStoreReporter) Prepare() error {
-	return r.dataStore.Prepare()
+// Forget drops the status of the data source (e.g. because it's about to be deleted).
+// Samples of the data source that are distributed afterwards are ignored.
+func (r *DataSourceStatusReporter) Forget(dataSourceId string) {
+	responseChan := make(chan bool)
+	r.forgetRequestChan <- DataSourceStatusForgetRequest{DataSourceId: dataSourceId, ResponseChan: responseChan}
+	<-responseChan
 }
 
-func (r *PersistentDataStoreReporter) ShutDown() error {
-	// TODO: stop ticker, flush remaining samples, and afterwards shutdown the data store
-	return r.dataStore.ShutDown()
+func (r *DataSourceStatusReporter) ShutDown() error {
+	responseChan := make(chan error)
+	r.shutDownChan <- responseChan
+	return <-responseChan
 }
 
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
@@ -1528,19 +7907,29 @@ type SampleRetrievalRequest struct {
 	ResponseChan chan []*Sample
 }
 
+type ReporterRegistrationRequest struct {
+	Reporter  Reporter
+	ErrorChan chan error
+}
+
 type QuantitativeSampleRetrievalRequest struct {
 	DataSourceId string
 	Quantity     int
 	ResponseChan chan []*Sample
 }
 
+type SampleDiscardRequest struct {
+	DataSourceId string
+	ResponseChan chan error
+}
+
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
 func NewKasperbrettReportingEngine() *KasperbrettReportingEngine {
 	re := &KasperbrettReportingEngine{
-		reporterRegistrationChan: make(chan Reporter),
+		reporterRegistrationChan: make(chan ReporterRegistrationRequest),
 		sampleChan:               make(chan *Sample),
 		shutDownChan:             make(chan chan error),
 	}
@@ -1548,17 +7937,21 @@ func NewKasperbrettReportingEngine() *KasperbrettReportingEngine {
 	go func() {
 		for {
 			select {
-			case reporter := <-re.reporterRegistrationChan:
-				re.reporters = append(re.reporters, reporter) // it is valid to append data to nil slices
-				reporter.Prepare()                            // TODO: handle error that might happen during preparation
+			case registrationRequest := <-re.reporterRegistrationChan:
+				err := registrationRequest.Reporter.Prepare()
+				if err == nil {
+					re.reporters = append(re.reporters, registrationRequest.Reporter) // it is valid to append data to nil slices
+				}
+				registrationRequest.ErrorChan <- err
 			case sample := <-re.sampleChan:
 				for _, reporter := range re.reporters {
 					go reporter.OnSample(sample)
 				}
 			case responseChan := <-re.shutDownChan:
 				var overallErr error = nil
-				for _, reporter := range re.reporters {
-					err := reporter.ShutDown()
+				// reporters are shut down in reverse order, so that they can rely on reporters that have been registered before
+				for i := len(re.reporters) - 1; i >= 0; i-- {
+					err := re.reporters[i].ShutDown()
 					if err != nil && overallErr == nil {
 						overallErr = err
 					}
@@ -1573,15 +7966,24 @@ func NewKasperbrettReportingEngine() *KasperbrettReportingEngine {
 
 type KasperbrettReportingEngine struct {
 	reporters                []Reporter
-	reporterRegistrationChan chan Reporter
+	reporterRegistrationChan chan ReporterRegistrationRequest
 	sampleChan               chan *Sample
 	shutDownChan             chan chan error
 }
 
-func (re *KasperbrettReportingEngine) Register(reporters ...Reporter) {
+// Register prepares and registers the given reporters one after another.
+// It returns as soon as a reporter fails to prepare. Such a reporter won't receive any samples.
+func (re *KasperbrettReportingEngine) Register(reporters ...Reporter) error {
 	for _, reporter := range reporters {
-		re.reporterRegistrationChan <- reporter
+		errorChan := make(chan error)
+		re.reporterRegistrationChan <- ReporterRegistrationRequest{Reporter: reporter, ErrorChan: errorChan}
+		err := <-errorChan
+		if err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 func (re *KasperbrettReportingEngine) Distribute(sample *Sample) {
@@ -1614,8 +8016,16 @@ func (re *KasperbrettReportingEngine) ShutDown() error {
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
 type SchedulerJob struct {
-	ticker *time.Ticker
-	t      tomb.Tomb
+	// interval is the initial interval, the job function might have changed it in the meantime
+	interval time.Duration
+	t        tomb.Tomb
+}
+
+// stop blocks until a currently running job function has returned.
+// The context of the job function gets canceled, so that it doesn't need to finish its work.
+func (job *SchedulerJob) stop() error {
+	job.t.Kill(nil)
+	return job.t.Wait()
 }
 
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
@@ -1623,7 +8033,9 @@ type SchedulerJob struct {
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
 type Scheduler interface {
-	Schedule(dataSourceId string, interval time.Duration, jobFn func(reportingEngine ReportingEngine)) (chan string, chan error)
+	// Schedule calls jobFn every interval. The passed context is canceled as soon as the job gets canceled.
+	// jobFn returns the interval until its next call (0 keeps the current one).
+	Schedule(dataSourceId string, interval time.Duration, jobFn func(ctx context.Context, reportingEngine ReportingEngine) time.Duration) (chan string, chan error)
 	GetAll() (chan map[string]*SchedulerJob, chan error)
 	Cancel(dataSourceId string) (chan bool, chan error)
 	ShutDown() (chan bool, chan error)
@@ -1663,19 +8075,42 @@ type AddJobRequest struct {
 	AbstractSchedulerRequest
 	responseChan chan string
 	interval     time.Duration
-	jobFn        func(reportingEngine ReportingEngine)
+	jobFn        func(ctx context.Context, reportingEngine ReportingEngine) time.Duration
 	dataSourceId string
 }
 
+// Run replaces an existing job of the same data source, e.g. after the data source has been updated.
 func (req AddJobRequest) Run(registry map[string]*SchedulerJob, reportingEngine ReportingEngine) {
-	job := &SchedulerJob{ticker: time.NewTicker(req.interval)}
+	if existingJob, ok := registry[req.dataSourceId]; ok {
+		delete(registry, req.dataSourceId)
+		err := existingJob.stop()
+		if err != nil {
+			req.errorChan <- err
+			return
+		}
+	}
+
+	job := &SchedulerJob{interval: req.interval}
 	registry[req.dataSourceId] = job
 
 	job.t.Go(func() error {
+		ctx := job.t.Context(nil)
+		// the ticker is owned by the job goroutine because the job function may change its interval
+		interval := req.interval
+		ticker := time.NewTicker(interval)
+		defer func() {
+			ticker.Stop()
+		}()
+
 		for {
 			select {
-			case <-job.ticker.C:
-				req.jobFn(reportingEngine)
+			case <-ticker.C:
+				nextInterval := req.jobFn(ctx, reportingEngine)
+				if nextInterval > 0 && nextInterval != interval {
+					interval = nextInterval
+					ticker.Stop()
+					ticker = time.NewTicker(interval)
+				}
 			case <-job.t.Dying():
 				return nil
 			}
@@ -1702,8 +8137,8 @@ func (req GetAllJobsRequest) Run(registry map[string]*SchedulerJob, reportingEng
 	registrySnapshot := make(map[string]*SchedulerJob, len(registry))
 
 	for jobId, job := range registry {
-		jobCopy := *job
-		registrySnapshot[jobId] = &jobCopy
+		// the tomb must not be copied, the snapshot is meant for inspection only
+		registrySnapshot[jobId] = &SchedulerJob{interval: job.interval}
 	}
 
 	req.responseChan <- registrySnapshot
@@ -1717,6 +8152,15 @@ func (req GetAllJobsRequest) IsShutDownRoutine() bool {
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 /* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
 
+// JobNotAvailableError is returned by Cancel() if the scheduler doesn't know a job with the given id.
+type JobNotAvailableError struct {
+	JobId string
+}
+
+func (err *JobNotAvailableError) Error() string {
+	return "Job not available: " + err.JobId
+}
+
 type RemoveJobRequest struct {
 	AbstractSchedulerRequest
 	responseChan chan bool
@@ -1727,12 +8171,10 @@ func (req RemoveJobRequest) Run(registry map[string]*SchedulerJob, reportingEngi
 	job, ok := registry[req.jobId]
 	var err error = nil
 	if !ok {
-		err = errors.New("Job not available: " + req.jobId)
+		err = &JobNotAvailableError{JobId: req.jobId}
 	} else {
-		job.ticker.Stop()
 		delete(registry, req.jobId)
-		job.t.Kill(nil)
-		err = job.t.Wait()
+		err = job.stop()
 	}
 	req.errorChan <- err
 	req.responseChan <- true
@@ -1754,11 +8196,8 @@ type RemoveAllJobsRequest struct {
 func (req RemoveAllJobsRequest) Run(registry map[string]*SchedulerJob, reportingEngine ReportingEngine) {
 	var overallErr error = nil
 	for jobId, job := range registry {
-		// TODO: remove redundant code
-		job.ticker.Stop()
 		delete(registry, jobId)
-		job.t.Kill(nil)
-		err := job.t.Wait()
+		err := job.stop()
 
 		if err != nil && overallErr == nil {
 			overallErr = err
@@ -1808,7 +8247,7 @@ type KasperbrettScheduler struct {
 	isShutDown      bool
 }
 
-func (ks *KasperbrettScheduler) Schedule(dataSourceId string, interval time.Duration, jobFn func(reportingEngine ReportingEngine)) (chan string, chan error) {
+func (ks *KasperbrettScheduler) Schedule(dataSourceId string, interval time.Duration, jobFn func(ctx context.Context, reportingEngine ReportingEngine) time.Duration) (chan string, chan error) {
 	// we need a buffered chan in case the caller is not interested in the response value (jobId)
 	// an unbuffered chan would block our request processing goroutine forever
 	responseChan := make(chan string, 1)
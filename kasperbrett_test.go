@@ -0,0 +1,2504 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/boltdb/bolt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+var testBaseTime = time.Date(2015, 1, 21, 14, 30, 0, 0, time.UTC)
+
+// newTestSamples returns num samples of the data source, one second apart.
+func newTestSamples(dataSourceId string, num int) []*Sample {
+	samples := make([]*Sample, 0, num)
+	for i := 0; i < num; i++ {
+		samples = append(samples, NewSample("1", testBaseTime.Add(time.Duration(i)*time.Second), dataSourceId, nil))
+	}
+	return samples
+}
+
+func sampleTimestamps(samples []*Sample) []time.Time {
+	timestamps := make([]time.Time, 0, len(samples))
+	for _, sample := range samples {
+		timestamps = append(timestamps, sample.Timestamp)
+	}
+	return timestamps
+}
+
+func assertTimestamps(t *testing.T, samples []*Sample, expected ...time.Time) {
+	t.Helper()
+	actual := sampleTimestamps(samples)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d samples %v, got %d %v", len(expected), expected, len(actual), actual)
+	}
+	for i := range expected {
+		if !actual[i].Equal(expected[i]) {
+			t.Fatalf("expected samples %v, got %v", expected, actual)
+		}
+	}
+}
+
+func testTime(seconds int) time.Time {
+	return testBaseTime.Add(time.Duration(seconds) * time.Second)
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func openTestWriteAheadLog(t *testing.T, fileAbsPath string) (*SampleWriteAheadLog, []*Sample) {
+	t.Helper()
+	wal := NewSampleWriteAheadLog(fileAbsPath)
+	samples, err := wal.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wal, samples
+}
+
+func TestSampleWriteAheadLogReplaysIntactEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		tail []byte
+	}{
+		{"no tail", nil},
+		{"torn header", []byte{0, 0, 0}},
+		{"torn record", []byte{0, 0, 0, 50, 1, 2, 3, 4, 5}},
+		{"corrupt checksum", []byte{0, 0, 0, 2, 1, 2, 3, 4, 5, 6}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileAbsPath := filepath.Join(t.TempDir(), "kb.db.wal")
+			wal, _ := openTestWriteAheadLog(t, fileAbsPath)
+			for _, sample := range newTestSamples("ds-a", 3) {
+				if err := wal.Append(sample); err != nil {
+					t.Fatal(err)
+				}
+			}
+			wal.Close()
+
+			file, err := os.OpenFile(fileAbsPath, os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Write(test.tail)
+			file.Close()
+
+			wal, samples := openTestWriteAheadLog(t, fileAbsPath)
+			assertTimestamps(t, samples, testTime(0), testTime(1), testTime(2))
+
+			// the tail has been cut off, so that new entries follow the intact ones
+			if err := wal.Append(NewSample("1", testTime(3), "ds-a", nil)); err != nil {
+				t.Fatal(err)
+			}
+			wal.Close()
+
+			wal, samples = openTestWriteAheadLog(t, fileAbsPath)
+			defer wal.Close()
+			assertTimestamps(t, samples, testTime(0), testTime(1), testTime(2), testTime(3))
+		})
+	}
+}
+
+func TestSampleWriteAheadLogTombstones(t *testing.T) {
+	type entry struct {
+		seconds   int
+		tombstone bool
+	}
+
+	tests := []struct {
+		name      string
+		entries   []entry
+		remaining []time.Time
+	}{
+		{"no tombstones", []entry{{0, false}, {1, false}}, []time.Time{testTime(0), testTime(1)}},
+		{"oldest removed", []entry{{0, false}, {1, false}, {2, false}, {0, true}, {1, true}}, []time.Time{testTime(2)}},
+		{"earliest duplicate removed", []entry{{1, false}, {1, false}, {1, true}}, []time.Time{testTime(1)}},
+		{"tombstone before the sample", []entry{{1, true}, {1, false}}, []time.Time{testTime(1)}},
+		{"unknown key", []entry{{0, false}, {5, true}}, []time.Time{testTime(0)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileAbsPath := filepath.Join(t.TempDir(), "kb.db.wal")
+			wal, _ := openTestWriteAheadLog(t, fileAbsPath)
+			for _, entry := range test.entries {
+				sample := NewSample("1", testTime(entry.seconds), "ds-a", nil)
+				var err error
+				if entry.tombstone {
+					err = wal.AppendTombstone(sample)
+				} else {
+					err = wal.Append(sample)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			wal.Close()
+
+			wal, samples := openTestWriteAheadLog(t, fileAbsPath)
+			defer wal.Close()
+			assertTimestamps(t, samples, test.remaining...)
+		})
+	}
+}
+
+func TestSampleWriteAheadLogRewrite(t *testing.T) {
+	fileAbsPath := filepath.Join(t.TempDir(), "kb.db.wal")
+	wal, _ := openTestWriteAheadLog(t, fileAbsPath)
+	samples := newTestSamples("ds-a", 3)
+	for _, sample := range samples {
+		wal.Append(sample)
+	}
+	wal.AppendTombstone(samples[0])
+
+	if err := wal.Rewrite(samples[1:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Append(NewSample("1", testTime(9), "ds-a", nil)); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	wal, replayedSamples := openTestWriteAheadLog(t, fileAbsPath)
+	defer wal.Close()
+	assertTimestamps(t, replayedSamples, testTime(1), testTime(9))
+
+	if _, err := os.Stat(fileAbsPath + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temporary file hasn't been renamed:", err)
+	}
+}
+
+func TestSampleWriteAheadLogBatches(t *testing.T) {
+	tests := []struct {
+		samples    int
+		batchSize  int
+		batchSizes []int
+	}{
+		{0, 3, []int{}},
+		{3, 3, []int{3}},
+		{7, 3, []int{3, 3, 1}},
+		{2, 10, []int{2}},
+	}
+
+	for _, test := range tests {
+		fileAbsPath := filepath.Join(t.TempDir(), "kb.db.spill")
+		wal, _ := openTestWriteAheadLog(t, fileAbsPath)
+		for _, sample := range newTestSamples("ds-a", test.samples) {
+			wal.Append(sample)
+		}
+
+		for _, forEachBatch := range []func(fn func([]*Sample) error) error{
+			func(fn func([]*Sample) error) error {
+				return wal.ForEachBatch(test.batchSize, fn)
+			},
+			func(fn func([]*Sample) error) error {
+				wal.Close()
+				wal = NewSampleWriteAheadLog(fileAbsPath)
+				return wal.OpenStreaming(test.batchSize, fn)
+			},
+		} {
+			batchSizes := []int{}
+			total := 0
+			err := forEachBatch(func(samples []*Sample) error {
+				batchSizes = append(batchSizes, len(samples))
+				total += len(samples)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(batchSizes) != len(test.batchSizes) || total != test.samples {
+				t.Fatalf("%d samples in batches of %d: expected %v, got %v", test.samples, test.batchSize, test.batchSizes, batchSizes)
+			}
+			for i := range batchSizes {
+				if batchSizes[i] != test.batchSizes[i] {
+					t.Fatalf("%d samples in batches of %d: expected %v, got %v", test.samples, test.batchSize, test.batchSizes, batchSizes)
+				}
+			}
+		}
+
+		// the log is still appendable afterwards
+		if err := wal.Append(NewSample("1", testTime(100), "ds-a", nil)); err != nil {
+			t.Fatal(err)
+		}
+		wal.Close()
+	}
+
+	fileAbsPath := filepath.Join(t.TempDir(), "kb.db.spill")
+	wal, _ := openTestWriteAheadLog(t, fileAbsPath)
+	defer wal.Close()
+	for _, sample := range newTestSamples("ds-a", 5) {
+		wal.Append(sample)
+	}
+	batchErr := errors.New("batch failed")
+	calls := 0
+	err := wal.ForEachBatch(2, func(samples []*Sample) error {
+		calls++
+		return batchErr
+	})
+	if err != batchErr || calls != 1 {
+		t.Errorf("expected the first failing batch to abort, got %v after %d calls", err, calls)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// failingDataStore fails to persist samples as long as fail is set to 1, except for the next `succeed` calls.
+type failingDataStore struct {
+	*BoltDataStore
+	fail    int32
+	succeed int32
+}
+
+func (ds *failingDataStore) PersistSamples(samples []*Sample) error {
+	if atomic.LoadInt32(&ds.fail) == 1 && atomic.AddInt32(&ds.succeed, -1) < 0 {
+		return errors.New("The data store is unavailable.")
+	}
+	return ds.BoltDataStore.PersistSamples(samples)
+}
+
+func newTestPersistentDataStoreReporter(t *testing.T, dataFileAbsPath string, bufferSize int, overflowPolicy string) (*PersistentDataStoreReporter, *failingDataStore) {
+	t.Helper()
+	dataStore := &failingDataStore{BoltDataStore: NewBoltDataStore(dataFileAbsPath)}
+	r := NewPersistentDataStoreReporter(
+		dataStore,
+		NewSampleWriteAheadLog(dataFileAbsPath+SampleWriteAheadLogFileSuffix),
+		NewSampleWriteAheadLog(dataFileAbsPath+SampleSpillLogFileSuffix),
+		50*time.Millisecond,
+		bufferSize,
+		overflowPolicy,
+	)
+	if err := r.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	return r, dataStore
+}
+
+func TestPersistentDataStoreReporterOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		overflowPolicy string
+		buffered       int
+		spilled        int
+		dropped        int
+		persisted      []time.Time // after the data store has recovered
+	}{
+		{BufferOverflowSpill, 3, 3, 0, []time.Time{testTime(0), testTime(1), testTime(2), testTime(3), testTime(4), testTime(5)}},
+		{BufferOverflowDropOldest, 3, 0, 3, []time.Time{testTime(3), testTime(4), testTime(5)}},
+		{BufferOverflowBlock, 3, 0, 3, []time.Time{testTime(0), testTime(1), testTime(2)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.overflowPolicy, func(t *testing.T) {
+			dataFileAbsPath := filepath.Join(t.TempDir(), "kb.db")
+			r, dataStore := newTestPersistentDataStoreReporter(t, dataFileAbsPath, 3, test.overflowPolicy)
+			atomic.StoreInt32(&dataStore.fail, 1)
+
+			for _, sample := range newTestSamples("ds-a", 6) {
+				r.OnSample(sample)
+			}
+
+			stats := r.Stats()
+			if stats.Buffered != test.buffered || stats.Spilled != test.spilled || stats.TotalDropped != test.dropped {
+				t.Fatalf("expected %d buffered, %d spilled and %d dropped samples, got %+v", test.buffered, test.spilled, test.dropped, stats)
+			}
+
+			atomic.StoreInt32(&dataStore.fail, 0)
+			if err := r.ShutDown(); err != nil {
+				t.Fatal(err)
+			}
+
+			boltDataStore := NewBoltDataStore(dataFileAbsPath)
+			boltDataStore.Prepare()
+			defer boltDataStore.ShutDown()
+			samples, err := boltDataStore.GetSamples("ds-a", testTime(-1), testTime(10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertTimestamps(t, samples, test.persisted...)
+		})
+	}
+}
+
+func TestPersistentDataStoreReporterDoesNotReplayDroppedSamples(t *testing.T) {
+	dataFileAbsPath := filepath.Join(t.TempDir(), "kb.db")
+	r, dataStore := newTestPersistentDataStoreReporter(t, dataFileAbsPath, 3, BufferOverflowDropOldest)
+	atomic.StoreInt32(&dataStore.fail, 1)
+
+	// enough drops to rewrite the write-ahead log in between
+	for _, sample := range newTestSamples("ds-a", 12) {
+		r.OnSample(sample)
+	}
+	if stats := r.Stats(); stats.TotalDropped != 9 {
+		t.Fatalf("expected 9 dropped samples, got %+v", stats)
+	}
+
+	// the write-ahead log is what a crash would leave behind
+	wal, samples := openTestWriteAheadLog(t, dataFileAbsPath+SampleWriteAheadLogFileSuffix)
+	defer wal.Close()
+	assertTimestamps(t, samples, testTime(9), testTime(10), testTime(11))
+}
+
+func TestPersistentDataStoreReporterRetriesFailedFlushes(t *testing.T) {
+	dataFileAbsPath := filepath.Join(t.TempDir(), "kb.db")
+	r, dataStore := newTestPersistentDataStoreReporter(t, dataFileAbsPath, 10, BufferOverflowSpill)
+	atomic.StoreInt32(&dataStore.fail, 1)
+
+	for _, sample := range newTestSamples("ds-a", 2) {
+		r.OnSample(sample)
+	}
+	time.Sleep(120 * time.Millisecond)
+
+	stats := r.Stats()
+	if stats.Buffered != 2 || stats.FailedFlushes == 0 || stats.LastFlushError == nil {
+		t.Fatalf("expected failed flushes with 2 buffered samples, got %+v", stats)
+	}
+
+	atomic.StoreInt32(&dataStore.fail, 0)
+	time.Sleep(120 * time.Millisecond)
+
+	stats = r.Stats()
+	if stats.Buffered != 0 || stats.TotalRetried == 0 || stats.LastFlushError != nil {
+		t.Fatalf("expected the samples to be flushed by a retry, got %+v", stats)
+	}
+	r.ShutDown()
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestParseTimeExpression(t *testing.T) {
+	now := time.Date(2015, 1, 21, 14, 30, 0, 0, time.UTC) // a Wednesday
+
+	tests := []struct {
+		expr     string
+		roundUp  bool
+		expected time.Time
+	}{
+		{"2015-01-18T14:36:51Z", false, time.Date(2015, 1, 18, 14, 36, 51, 0, time.UTC)},
+		{"2015-01-18T14:36:51.5+01:00", false, time.Date(2015, 1, 18, 13, 36, 51, 500000000, time.UTC)},
+		{"1421591811000", false, time.Date(2015, 1, 18, 14, 36, 51, 0, time.UTC)},
+		{" 1421591811000 ", false, time.Date(2015, 1, 18, 14, 36, 51, 0, time.UTC)},
+		{"2015-01-18", false, time.Date(2015, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{"2015-01-18", true, time.Date(2015, 1, 18, 23, 59, 59, 999999999, time.UTC)},
+		{"now", false, now},
+		{"now", true, now},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseTimeExpression(test.expr, now, test.roundUp)
+		if err != nil {
+			t.Errorf("%q (roundUp: %t): unexpected error: %s", test.expr, test.roundUp, err)
+		} else if !actual.Equal(test.expected) {
+			t.Errorf("%q (roundUp: %t): expected %s, got %s", test.expr, test.roundUp, test.expected, actual)
+		}
+	}
+}
+
+func TestParseRelativeTimeExpression(t *testing.T) {
+	now := time.Date(2015, 1, 21, 14, 30, 0, 0, time.UTC) // a Wednesday
+
+	tests := []struct {
+		expr     string
+		roundUp  bool
+		expected time.Time
+	}{
+		{"now-7d", false, time.Date(2015, 1, 14, 14, 30, 0, 0, time.UTC)},
+		{"now+1d", false, time.Date(2015, 1, 22, 14, 30, 0, 0, time.UTC)},
+		{"now-2w", false, time.Date(2015, 1, 7, 14, 30, 0, 0, time.UTC)},
+		{"now-1M", false, time.Date(2014, 12, 21, 14, 30, 0, 0, time.UTC)},
+		{"now-1y", false, time.Date(2014, 1, 21, 14, 30, 0, 0, time.UTC)},
+		{"now-1h30m", false, time.Date(2015, 1, 21, 13, 0, 0, 0, time.UTC)},
+		{"now-90s", false, time.Date(2015, 1, 21, 14, 28, 30, 0, time.UTC)},
+		{"now-250ms", false, time.Date(2015, 1, 21, 14, 29, 59, 750000000, time.UTC)},
+		{"now-1d+2h", false, time.Date(2015, 1, 20, 16, 30, 0, 0, time.UTC)},
+		// offsets that used to be parsed by time.ParseDuration()
+		{"now-1.5h", false, time.Date(2015, 1, 21, 13, 0, 0, 0, time.UTC)},
+		{"now-1h30.5m", false, time.Date(2015, 1, 21, 12, 59, 30, 0, time.UTC)},
+		{"now-500us", false, now.Add(-500 * time.Microsecond)},
+		{"now-2µs", false, now.Add(-2 * time.Microsecond)},
+		{"now-100ns", false, now.Add(-100)},
+		{"now-1d+1.5h", false, time.Date(2015, 1, 20, 16, 0, 0, 0, time.UTC)},
+		// rounding
+		{"now/d", false, time.Date(2015, 1, 21, 0, 0, 0, 0, time.UTC)},
+		{"now/d", true, time.Date(2015, 1, 21, 23, 59, 59, 999999999, time.UTC)},
+		{"now-1d/d", false, time.Date(2015, 1, 20, 0, 0, 0, 0, time.UTC)},
+		{"now/w", false, time.Date(2015, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"now/w", true, time.Date(2015, 1, 25, 23, 59, 59, 999999999, time.UTC)},
+		{"now-1M/M", false, time.Date(2014, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{"now/y", false, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"now/h", true, time.Date(2015, 1, 21, 14, 59, 59, 999999999, time.UTC)},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseTimeExpression(test.expr, now, test.roundUp)
+		if err != nil {
+			t.Errorf("%q (roundUp: %t): unexpected error: %s", test.expr, test.roundUp, err)
+		} else if !actual.Equal(test.expected) {
+			t.Errorf("%q (roundUp: %t): expected %s, got %s", test.expr, test.roundUp, test.expected, actual)
+		}
+	}
+}
+
+func TestParseTimeExpressionRejectsInvalidExpressions(t *testing.T) {
+	now := time.Date(2015, 1, 21, 14, 30, 0, 0, time.UTC)
+
+	for _, expr := range []string{"", "yesterday", "2015-13-01", "now1d", "now-", "now+", "now-d", "now-1x", "now-1.5d", "now-1d/q", "now-1d/"} {
+		if actual, err := ParseTimeExpression(expr, now, false); err == nil {
+			t.Errorf("%q: expected an error, got %s", expr, actual)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.Date(2015, 1, 21, 14, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		from         string
+		to           string
+		expectedFrom time.Time
+		expectedTo   time.Time
+		valid        bool
+	}{
+		{"", "", now.Add(-time.Hour), now, true},
+		{"now-1d/d", "now-1d/d", time.Date(2015, 1, 20, 0, 0, 0, 0, time.UTC), time.Date(2015, 1, 20, 23, 59, 59, 999999999, time.UTC), true},
+		{"2015-01-18", "2015-01-18", time.Date(2015, 1, 18, 0, 0, 0, 0, time.UTC), time.Date(2015, 1, 18, 23, 59, 59, 999999999, time.UTC), true},
+		{"now", "now-1h", time.Time{}, time.Time{}, false},
+		{"x", "now", time.Time{}, time.Time{}, false},
+		{"now-1h", "x", time.Time{}, time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		from, to, err := ParseTimeRange(test.from, test.to, now)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%q - %q: expected an error", test.from, test.to)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q - %q: unexpected error: %s", test.from, test.to, err)
+		} else if !from.Equal(test.expectedFrom) || !to.Equal(test.expectedTo) {
+			t.Errorf("%q - %q: expected %s - %s, got %s - %s", test.from, test.to, test.expectedFrom, test.expectedTo, from, to)
+		}
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		rawValue    string
+		valueType   string
+		valueFormat ValueFormat
+		value       string
+		number      float64
+		valid       bool
+	}{
+		{"3.14", ValueTypeFloat, ValueFormat{}, "3.14", 3.14, true},
+		{" 42 ", ValueTypeFloat, ValueFormat{}, "42", 42, true},
+		{"-1e3", ValueTypeFloat, ValueFormat{}, "-1000", -1000, true},
+		{"1,234.5", ValueTypeFloat, ValueFormat{Locale: "en"}, "1234.5", 1234.5, true},
+		{"1.234,5", ValueTypeFloat, ValueFormat{Locale: "de"}, "1234.5", 1234.5, true},
+		{"1.234,5", ValueTypeFloat, ValueFormat{Locale: "de-AT"}, "1234.5", 1234.5, true},
+		{"1'234.5", ValueTypeFloat, ValueFormat{Locale: "de-CH"}, "1234.5", 1234.5, true},
+		{"1\u00a0234,5", ValueTypeFloat, ValueFormat{Locale: "fr"}, "1234.5", 1234.5, true},
+		{"1_234;5", ValueTypeFloat, ValueFormat{DecimalSeparator: ";", GroupSeparator: "_"}, "1234.5", 1234.5, true},
+		{"1,5", ValueTypeFloat, ValueFormat{}, "", 0, false},
+		{"NaN", ValueTypeFloat, ValueFormat{}, "", 0, false},
+		{"Inf", ValueTypeFloat, ValueFormat{}, "", 0, false},
+		{"", ValueTypeFloat, ValueFormat{}, "", 0, false},
+		{"12", ValueTypeInt, ValueFormat{}, "12", 12, true},
+		{"-7", ValueTypeInt, ValueFormat{}, "-7", -7, true},
+		{"1.000.000", ValueTypeInt, ValueFormat{Locale: "de"}, "1000000", 1000000, true},
+		{"1.5", ValueTypeInt, ValueFormat{}, "", 0, false},
+		{"twelve", ValueTypeInt, ValueFormat{}, "", 0, false},
+		{"true", ValueTypeBool, ValueFormat{}, "true", 1, true},
+		{"Yes", ValueTypeBool, ValueFormat{}, "true", 1, true},
+		{"ON", ValueTypeBool, ValueFormat{}, "true", 1, true},
+		{"1", ValueTypeBool, ValueFormat{}, "true", 1, true},
+		{"False", ValueTypeBool, ValueFormat{}, "false", 0, true},
+		{"off", ValueTypeBool, ValueFormat{}, "false", 0, true},
+		{"0", ValueTypeBool, ValueFormat{}, "false", 0, true},
+		{"maybe", ValueTypeBool, ValueFormat{}, "", 0, false},
+		{" up and running ", ValueTypeText, ValueFormat{}, "up and running", 0, true},
+		{"  ", ValueTypeText, ValueFormat{}, "", 0, false},
+		{"1", "complex", ValueFormat{}, "", 0, false},
+	}
+
+	for _, test := range tests {
+		value, number, err := ParseValue(test.rawValue, test.valueType, test.valueFormat)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%q as %s %+v: expected an error, got %q", test.rawValue, test.valueType, test.valueFormat, value)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q as %s %+v: unexpected error: %s", test.rawValue, test.valueType, test.valueFormat, err)
+		} else if value != test.value || number != test.number {
+			t.Errorf("%q as %s %+v: expected %q (%v), got %q (%v)", test.rawValue, test.valueType, test.valueFormat, test.value, test.number, value, number)
+		}
+	}
+}
+
+func TestNormalizeNumber(t *testing.T) {
+	tests := []struct {
+		value       string
+		valueFormat ValueFormat
+		expected    string
+	}{
+		{"1234.5", ValueFormat{}, "1234.5"},
+		{"1,234.5", ValueFormat{}, "1,234.5"},
+		{"1,234,567.5", ValueFormat{Locale: "en"}, "1234567.5"},
+		{"1.234.567,5", ValueFormat{Locale: "de"}, "1234567.5"},
+		{"1.234.567,5", ValueFormat{Locale: "nl-BE"}, "1234567.5"},
+		{"1 234 567,5", ValueFormat{Locale: "fr"}, "1234567.5"},
+		{"1\u202f234\u00a0567,5", ValueFormat{Locale: "fr"}, "1234567.5"}, // (narrow) no-break spaces
+		{"1.234,5", ValueFormat{Locale: "en", DecimalSeparator: ",", GroupSeparator: "."}, "1234.5"},
+	}
+
+	for _, test := range tests {
+		if actual := normalizeNumber(test.value, test.valueFormat); actual != test.expected {
+			t.Errorf("%q %+v: expected %q, got %q", test.value, test.valueFormat, test.expected, actual)
+		}
+	}
+}
+
+func TestValidateValueType(t *testing.T) {
+	tests := []struct {
+		valueType   string
+		valueFormat ValueFormat
+		valid       bool
+	}{
+		{"", ValueFormat{}, true},
+		{ValueTypeFloat, ValueFormat{Locale: "de"}, true},
+		{ValueTypeInt, ValueFormat{Locale: "de-DE"}, true},
+		{ValueTypeText, ValueFormat{}, true},
+		{"number", ValueFormat{}, false},
+		{ValueTypeFloat, ValueFormat{Locale: "xx"}, false},
+		{ValueTypeFloat, ValueFormat{DecimalSeparator: ","}, true},
+		{ValueTypeFloat, ValueFormat{GroupSeparator: "."}, false},
+		{ValueTypeFloat, ValueFormat{Locale: "de", GroupSeparator: ","}, false},
+	}
+
+	for _, test := range tests {
+		err := ValidateValueType(test.valueType, test.valueFormat)
+		if test.valid && err != nil {
+			t.Errorf("%q %+v: unexpected error: %s", test.valueType, test.valueFormat, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%q %+v: expected an error", test.valueType, test.valueFormat)
+		}
+	}
+}
+
+func TestSampleNumericValue(t *testing.T) {
+	typedSample := func(value string, valueType string) *Sample {
+		sample := NewSample(value, testBaseTime, "ds-a", nil)
+		if err := ParseSampleValue(sample, valueType, ValueFormat{Locale: "de"}); err != nil {
+			t.Fatal(err)
+		}
+		return sample
+	}
+
+	tests := []struct {
+		sample  *Sample
+		number  float64
+		numeric bool
+	}{
+		{typedSample("1.234,5", ValueTypeFloat), 1234.5, true},
+		{typedSample("yes", ValueTypeBool), 1, true},
+		{typedSample("42", ValueTypeText), 0, false},
+		// samples without value type are numeric if their value is a plain number
+		{typedSample(" 42 ", ""), 42, true},
+		{typedSample("1,5", ""), 0, false},
+		{NewSample("", testBaseTime, "ds-a", errors.New("Sample retrieval timed out.")), 0, false},
+	}
+
+	for _, test := range tests {
+		number, numeric := test.sample.NumericValue()
+		if number != test.number || numeric != test.numeric {
+			t.Errorf("%q (%s): expected %v (%t), got %v (%t)", test.sample.Value, test.sample.ValueType, test.number, test.numeric, number, numeric)
+		}
+	}
+
+	if actual := typedSample("1.234,5", ValueTypeFloat).JSON(); actual != `{"dataSourceId":"ds-a","timestamp":1421850600000,"value":"1234.5","valueType":"float","number":1234.5}` {
+		t.Errorf("unexpected JSON: %s", actual)
+	}
+	if actual := typedSample(`"up"`, ValueTypeText).JSON(); actual != `{"dataSourceId":"ds-a","timestamp":1421850600000,"value":"\"up\"","valueType":"text","number":null}` {
+		t.Errorf("unexpected JSON: %s", actual)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestParseTransformationPipeline(t *testing.T) {
+	tests := []struct {
+		definition string
+		steps      int
+		valid      bool
+	}{
+		{"", 0, true},
+		{"  ", 0, true},
+		{"[]", 0, true},
+		{`[{"type": "trim"}, {"type": "regex", "pattern": "([0-9.,]+) EUR"}, {"type": "number", "locale": "de"}]`, 3, true},
+		{`[{"type": "regex", "pattern": "(?P<amount>\\d+)", "group": "amount"}]`, 1, true},
+		{`[{"type": "regex", "pattern": "(\\d+)-(\\d+)", "group": "2"}]`, 1, true},
+		{`[{"type": "replace", "pattern": "\\s+", "replacement": ""}]`, 1, true},
+		{`[{"type": "multiply", "factor": 0.001}, {"type": "round", "decimals": 2}, {"type": "default", "value": "0"}]`, 3, true},
+		{`{"type": "trim"}`, 0, false},
+		{`[{"type": "trim"}`, 0, false},
+		{`[{"type": "upper"}]`, 0, false},
+		{`[{"type": "regex"}]`, 0, false},
+		{`[{"type": "regex", "pattern": "("}]`, 0, false},
+		{`[{"type": "regex", "pattern": "(\\d+)", "group": "2"}]`, 0, false},
+		{`[{"type": "regex", "pattern": "(\\d+)", "group": "amount"}]`, 0, false},
+		{`[{"type": "replace"}]`, 0, false},
+		{`[{"type": "number", "locale": "xx"}]`, 0, false},
+		{`[{"type": "number", "decimalSeparator": ".", "groupSeparator": "."}]`, 0, false},
+		{`[{"type": "multiply"}]`, 0, false},
+		{`[{"type": "round", "decimals": -1}]`, 0, false},
+		{`[{"type": "round", "decimals": 16}]`, 0, false},
+		{`[{"type": "default"}]`, 0, false},
+		{`[{"type": "trim"}, {"type": "nope"}]`, 0, false},
+	}
+
+	for _, test := range tests {
+		pipeline, err := ParseTransformationPipeline(test.definition)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.definition)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.definition, err)
+		} else if len(pipeline) != test.steps {
+			t.Errorf("%s: expected %d steps, got %d", test.definition, test.steps, len(pipeline))
+		}
+	}
+}
+
+func TestTransformationStepApply(t *testing.T) {
+	tests := []struct {
+		step     string
+		value    string
+		expected string
+		valid    bool
+	}{
+		{`{"type": "trim"}`, " \t42 \n", "42", true},
+		{`{"type": "regex", "pattern": "([0-9.,]+) EUR"}`, "Total: 1.234,50 EUR", "1.234,50", true},
+		{`{"type": "regex", "pattern": "[0-9]+"}`, "abc 123 def", "123", true},
+		{`{"type": "regex", "pattern": "(\\d+)-(\\d+)", "group": "2"}`, "10-20", "20", true},
+		{`{"type": "regex", "pattern": "(\\d+)-(\\d+)", "group": "0"}`, "x 10-20 y", "10-20", true},
+		{`{"type": "regex", "pattern": "(?P<amount>\\d+) items", "group": "amount"}`, "7 items", "7", true},
+		{`{"type": "regex", "pattern": "(\\d+)"}`, "none", "", true},
+		{`{"type": "replace", "pattern": "\\s+", "replacement": ""}`, "1 2 3", "123", true},
+		{`{"type": "replace", "pattern": "(\\d+)/(\\d+)", "replacement": "$2.$1"}`, "5/10", "10.5", true},
+		{`{"type": "number"}`, "1234.5", "1234.5", true},
+		{`{"type": "number", "locale": "de"}`, "1.234,5", "1234.5", true},
+		{`{"type": "number", "decimalSeparator": ",", "groupSeparator": " "}`, "1 234,5", "1234.5", true},
+		{`{"type": "number"}`, "n/a", "", false},
+		{`{"type": "multiply", "factor": 1000}`, "1.5", "1500", true},
+		{`{"type": "multiply", "factor": -0.5}`, " 3 ", "-1.5", true},
+		{`{"type": "multiply", "factor": 2}`, "1,5", "", false},
+		{`{"type": "round"}`, "2.5001", "3", true},
+		{`{"type": "round", "decimals": 2}`, "3.14159", "3.14", true},
+		{`{"type": "round", "decimals": 1}`, "2", "2.0", true},
+		{`{"type": "round", "decimals": 1}`, "abc", "", false},
+		{`{"type": "default", "value": "0"}`, "", "0", true},
+		{`{"type": "default", "value": "0"}`, "  ", "0", true},
+		{`{"type": "default", "value": "0"}`, "5", "5", true},
+	}
+
+	for _, test := range tests {
+		pipeline, err := ParseTransformationPipeline("[" + test.step + "]")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.step, err)
+			continue
+		}
+
+		actual, err := pipeline[0].apply(test.value)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s applied to %q: expected an error, got %q", test.step, test.value, actual)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s applied to %q: unexpected error: %s", test.step, test.value, err)
+		} else if actual != test.expected {
+			t.Errorf("%s applied to %q: expected %q, got %q", test.step, test.value, test.expected, actual)
+		}
+	}
+}
+
+func TestTransformationPipelineApply(t *testing.T) {
+	pipeline, err := ParseTransformationPipeline(`[
+		{"type": "regex", "pattern": "([0-9.,]+) EUR"},
+		{"type": "number", "locale": "de"},
+		{"type": "multiply", "factor": 100},
+		{"type": "round"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, trace := WithRetrievalTrace(context.Background())
+	value, err := pipeline.Apply(ctx, "Total: 1.234,567 EUR")
+	if err != nil || value != "123457" {
+		t.Fatalf("expected '123457', got %q (%v)", value, err)
+	}
+
+	steps := trace.Dto().Transformations
+	expectedSteps := []TransformationTraceStep{
+		{Step: "1: regex", Value: "1.234,567"},
+		{Step: "2: number", Value: "1234.567"},
+		{Step: "3: multiply", Value: "123456.7"},
+		{Step: "4: round", Value: "123457"},
+	}
+	if len(steps) != len(expectedSteps) {
+		t.Fatalf("expected the trace steps %+v, got %+v", expectedSteps, steps)
+	}
+	for i := range expectedSteps {
+		if steps[i] != expectedSteps[i] {
+			t.Errorf("expected the trace step %+v, got %+v", expectedSteps[i], steps[i])
+		}
+	}
+
+	// a failing step stops the pipeline and is traced with its input
+	ctx, trace = WithRetrievalTrace(context.Background())
+	if _, err = pipeline.Apply(ctx, "Total: n/a"); err == nil {
+		t.Fatal("expected an error")
+	}
+	steps = trace.Dto().Transformations
+	if len(steps) != 2 || steps[1].Step != "2: number" || steps[1].Value != "" || len(steps[1].Error) == 0 {
+		t.Errorf("expected the failed number step to be traced, got %+v", steps)
+	}
+
+	// the pipeline must not produce an empty value unless a script follows
+	if _, err = ApplyTransformations(context.Background(), "none", TransformationPipeline{{Type: TransformTrim}}, ""); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if _, err = ApplyTransformations(context.Background(), "  ", TransformationPipeline{{Type: TransformTrim}}, ""); err == nil {
+		t.Error("expected an error for an empty result")
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func newTestBoltDataStore(t *testing.T) *BoltDataStore {
+	t.Helper()
+	dataStore := NewBoltDataStore(filepath.Join(t.TempDir(), "kb.db"))
+	if err := dataStore.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dataStore.ShutDown() })
+	return dataStore
+}
+
+func newTestUrlScraper(t *testing.T, dataSourceId string) *UrlScraper {
+	t.Helper()
+	urlScraper, err := NewUrlScraper(NewAbstractDataSourceWithId(dataSourceId, dataSourceId, time.Minute, 10*time.Second), "http://localhost/", "h1", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return urlScraper
+}
+
+func TestRetentionJanitorPrunesSamplesOfDeletedDataSources(t *testing.T) {
+	dataStore := newTestBoltDataStore(t)
+
+	// ds-a keeps its samples for an hour, ds-b and the deleted ds-c fall back to the global retention of a day
+	dsA := newTestUrlScraper(t, "ds-a")
+	dsA.SetRetention(time.Hour)
+	for _, ds := range []DataSource{dsA, newTestUrlScraper(t, "ds-b"), newTestUrlScraper(t, "ds-c")} {
+		if err := dataStore.PersistDataSource(ds); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := testTime(0)
+	for _, dataSourceId := range []string{"ds-a", "ds-b", "ds-c"} {
+		err := dataStore.PersistSamples([]*Sample{
+			NewSample("1", now.Add(-48*time.Hour), dataSourceId, nil),
+			NewSample("2", now.Add(-2*time.Hour), dataSourceId, nil),
+			NewSample("3", now.Add(-1*time.Minute), dataSourceId, nil),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dataStore.DeleteDataSource("ds-c"); err != nil {
+		t.Fatal(err)
+	}
+
+	j := &RetentionJanitor{dataStore: dataStore, globalRetention: 24 * time.Hour, rollupRetentions: map[string]time.Duration{"1m": 24 * time.Hour}}
+	j.prune(now)
+
+	expected := map[string][]time.Time{
+		"ds-a": {now.Add(-1 * time.Minute)},
+		"ds-b": {now.Add(-2 * time.Hour), now.Add(-1 * time.Minute)},
+		"ds-c": {now.Add(-2 * time.Hour), now.Add(-1 * time.Minute)},
+	}
+	for dataSourceId, timestamps := range expected {
+		samples, err := dataStore.GetSamples(dataSourceId, now.Add(-72*time.Hour), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertTimestamps(t, samples, timestamps...)
+
+		rollups, _, err := dataStore.GetRollups(dataSourceId, RollupResolutions[0], now.Add(-72*time.Hour), now, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rollups) != 2 {
+			t.Errorf("expected the 1m rollup of %s older than a day to be pruned, got %d rollups", dataSourceId, len(rollups))
+		}
+	}
+
+	if j.stats.LastRunPrunedSamples != 4 || j.stats.LastRunPrunedRollups != 3 || j.stats.LastRunPrunedBytes == 0 {
+		t.Errorf("expected 4 pruned samples and 3 pruned rollups, got %+v", j.stats)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestRollupRange(t *testing.T) {
+	minute := RollupResolutions[0]
+	tests := []struct {
+		from         time.Time
+		to           time.Time
+		expectedFrom time.Time
+		expectedTo   time.Time
+	}{
+		{testTime(0), testTime(120), testTime(0), testTime(120)},
+		{testTime(0), testTime(119), testTime(0), testTime(60)},
+		{testTime(1), testTime(180), testTime(60), testTime(180)},
+		{testTime(0), testTime(60).Add(-1 * time.Nanosecond), testTime(0), testTime(60)},
+		// no complete rollup at all
+		{testTime(10), testTime(50), testTime(60), testTime(60)},
+	}
+
+	for _, test := range tests {
+		from, to := RollupRange(minute, test.from, test.to)
+		if !from.Equal(test.expectedFrom) || !to.Equal(test.expectedTo) {
+			t.Errorf("[%s, %s]: expected [%s, %s), got [%s, %s)", test.from, test.to, test.expectedFrom, test.expectedTo, from, to)
+		}
+	}
+}
+
+func TestAggregateStoredRollupsMatchesRawSamples(t *testing.T) {
+	dataStore := newTestBoltDataStore(t)
+
+	// a sample every 7 seconds for 3 hours, with a few errors in between
+	samples := []*Sample{}
+	for i := 0; i < 3*60*60/7; i++ {
+		var err error
+		if i%50 == 0 {
+			err = errors.New("The value couldn't be retrieved.")
+		}
+		samples = append(samples, NewSample(strconv.Itoa(i%17), testTime(7*i), "ds-a", err))
+	}
+	if err := dataStore.PersistSamples(samples[:len(samples)-20]); err != nil {
+		t.Fatal(err)
+	}
+	unpersistedSamples := samples[len(samples)-20:]
+
+	tests := []struct {
+		resolution RollupResolution
+		step       time.Duration
+		from       time.Time
+		to         time.Time
+	}{
+		{RollupResolutions[0], 5 * time.Minute, testTime(13*60 + 27), testTime(2*60*60 + 41*60 + 13)},
+		{RollupResolutions[0], time.Minute, testTime(0), testTime(3 * 60 * 60)},
+		{RollupResolutions[1], time.Hour, testTime(-1), testTime(2*60*60 + 59*60)},
+		{RollupResolutions[1], time.Hour, testTime(10 * 60), testTime(50 * 60)},
+	}
+
+	for _, test := range tests {
+		for _, aggregation := range []string{AggAvg, AggMin, AggMax, AggSum, AggCount} {
+			storedSamples, err := dataStore.GetSamples("ds-a", test.from, test.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rawSamples := []*Sample{}
+			for _, sample := range unpersistedSamples {
+				if !sample.Timestamp.Before(test.from) && !sample.Timestamp.After(test.to) {
+					rawSamples = append(rawSamples, sample)
+				}
+			}
+			expected := AggregateSamples(MergeSamples(storedSamples, rawSamples), test.step, aggregation)
+
+			actual, err := AggregateStoredRollups(dataStore, "ds-a", test.from, test.to, rawSamples, test.resolution, test.step, aggregation)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(actual.Points) != len(expected.Points) {
+				t.Fatalf("%s/%s [%s, %s]: expected %d points, got %d", test.resolution.Name, aggregation, test.from, test.to, len(expected.Points), len(actual.Points))
+			}
+			for i := range expected.Points {
+				if actual.Points[i] != expected.Points[i] {
+					t.Errorf("%s/%s [%s, %s]: expected %+v, got %+v", test.resolution.Name, aggregation, test.from, test.to, expected.Points[i], actual.Points[i])
+				}
+			}
+		}
+	}
+}
+
+func TestPersistentDataStoreReporterReturnsSpilledSamples(t *testing.T) {
+	r, dataStore := newTestPersistentDataStoreReporter(t, filepath.Join(t.TempDir(), "kb.db"), 3, BufferOverflowSpill)
+	atomic.StoreInt32(&dataStore.fail, 1)
+
+	for _, sample := range newTestSamples("ds-a", 6) {
+		r.OnSample(sample)
+	}
+	r.OnSample(NewSample("1", testTime(4), "ds-b", nil))
+
+	assertTimestamps(t, MergeSamples(r.GetSamples("ds-a", testTime(1), testTime(4))), testTime(1), testTime(2), testTime(3), testTime(4))
+
+	atomic.StoreInt32(&dataStore.fail, 0)
+	r.ShutDown()
+}
+
+func TestAggregateStoredRollupsSkipsPersistedSpilledSamples(t *testing.T) {
+	r, dataStore := newTestPersistentDataStoreReporter(t, filepath.Join(t.TempDir(), "kb.db"), 3, BufferOverflowSpill)
+	atomic.StoreInt32(&dataStore.fail, 1)
+
+	// 3 buffered and 6 spilled samples
+	for _, sample := range newTestSamples("ds-a", 9) {
+		r.OnSample(sample)
+	}
+	for r.Stats().Spilled != 6 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the buffer and the first batch of the spill log are persisted, the second batch fails
+	atomic.StoreInt32(&dataStore.succeed, 2)
+	var storedSamples []*Sample
+	for len(storedSamples) != 6 {
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		storedSamples, err = dataStore.GetSamples("ds-a", testTime(0), testTime(60))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for r.Stats().FailedFlushes == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	unpersistedSamples := r.GetSamples("ds-a", testTime(0), testTime(60))
+	if len(unpersistedSamples) != 6 {
+		t.Fatalf("expected the whole spill log to be kept after the failed flush, got %d samples", len(unpersistedSamples))
+	}
+
+	for _, aggregation := range []string{AggCount, AggSum, AggAvg} {
+		expected := AggregateSamples(MergeSamples(storedSamples, unpersistedSamples), time.Minute, aggregation)
+		actual, err := AggregateStoredRollups(dataStore, "ds-a", testTime(0), testTime(60), unpersistedSamples, RollupResolutions[0], time.Minute, aggregation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual.Points, expected.Points) {
+			t.Errorf("%s: expected %+v, got %+v", aggregation, expected.Points, actual.Points)
+		}
+	}
+
+	atomic.StoreInt32(&dataStore.fail, 0)
+	r.ShutDown()
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestTimeKeys(t *testing.T) {
+	timestamps := []time.Time{time.Unix(0, 0), testTime(-1), testTime(0), testTime(0).Add(time.Nanosecond), testTime(3600)}
+	for i, timestamp := range timestamps {
+		if decoded := DecodeTimeKey(EncodeTimeKey(timestamp)); !decoded.Equal(timestamp) {
+			t.Errorf("expected %s, got %s", timestamp, decoded)
+		}
+		if i > 0 && bytes.Compare(EncodeTimeKey(timestamps[i-1]), EncodeTimeKey(timestamp)) >= 0 {
+			t.Errorf("expected the key of %s to sort before the one of %s", timestamps[i-1], timestamp)
+		}
+	}
+
+	// out of range timestamps are clamped
+	if decoded := DecodeTimeKey(EncodeTimeKey(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC))); !decoded.Equal(time.Unix(0, 0)) {
+		t.Errorf("expected a timestamp before 1970 to be clamped to the Unix Epoch, got %s", decoded)
+	}
+	if decoded := DecodeTimeKey(EncodeTimeKey(time.Date(2300, 1, 1, 0, 0, 0, 0, time.UTC))); !decoded.Equal(time.Unix(0, math.MaxInt64)) {
+		t.Errorf("expected a timestamp after 2262 to be clamped, got %s", decoded)
+	}
+}
+
+func TestSplitFlatKey(t *testing.T) {
+	timestamp := testTime(0).Add(123 * time.Nanosecond)
+	formatted := timestamp.Format(time.RFC3339Nano)
+
+	tests := []struct {
+		key          string
+		bucketDepth  int
+		expectedPath []string
+	}{
+		{"ds-a#" + formatted, 1, []string{"ds-a"}},
+		{"ds-a#1m#" + formatted, 2, []string{"ds-a", "1m"}},
+		// only the trailing separators count
+		{"ds#a#1h#" + formatted, 2, []string{"ds#a", "1h"}},
+	}
+
+	for _, test := range tests {
+		bucketPath, actualTimestamp, err := splitFlatKey(test.key, test.bucketDepth)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.key, err)
+			continue
+		}
+		if !reflect.DeepEqual(bucketPath, test.expectedPath) || !actualTimestamp.Equal(timestamp) {
+			t.Errorf("%s: expected %v and %s, got %v and %s", test.key, test.expectedPath, timestamp, bucketPath, actualTimestamp)
+		}
+	}
+
+	for _, key := range []string{"ds-a", "#" + formatted, "ds-a#" + formatted, "ds-a#1m#yesterday"} {
+		if _, _, err := splitFlatKey(key, 2); err == nil {
+			t.Errorf("%s: expected an error", key)
+		}
+	}
+}
+
+func TestBoltDataStoreMigratesFlatKeys(t *testing.T) {
+	dataFileAbsPath := filepath.Join(t.TempDir(), "kb.db")
+
+	// schema version 1: no meta bucket, flat keys
+	db, err := bolt.Open(dataFileAbsPath, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		samplesBucket, err := tx.CreateBucket([]byte(BoltSamplesBucket))
+		if err != nil {
+			return err
+		}
+		for _, sample := range append(newTestSamples("ds-a", 3), newTestSamples("ds-b", 2)...) {
+			sampleBytes, err := sample.GobEncode()
+			if err != nil {
+				return err
+			}
+			if err = samplesBucket.Put([]byte(GenerateKey(sample.DataSourceId, BoltSampleKeySeparator, sample.Timestamp)), sampleBytes); err != nil {
+				return err
+			}
+		}
+		if err = samplesBucket.Put([]byte("unreadable"), []byte("value")); err != nil {
+			return err
+		}
+
+		rollupsBucket, err := tx.CreateBucket([]byte(BoltRollupsBucket))
+		if err != nil {
+			return err
+		}
+		rollupBytes, err := (&Rollup{Timestamp: testTime(0), Min: 1, Max: 1, Sum: 3, Count: 3}).GobEncode()
+		if err != nil {
+			return err
+		}
+		return rollupsBucket.Put([]byte("ds-a#1m#"+testTime(0).Format(time.RFC3339Nano)), rollupBytes)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataStore := NewBoltDataStore(dataFileAbsPath)
+	if err = dataStore.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	defer dataStore.ShutDown()
+
+	for dataSourceId, num := range map[string]int{"ds-a": 3, "ds-b": 2} {
+		samples, err := dataStore.GetSamples(dataSourceId, testTime(-1), testTime(10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertTimestamps(t, samples, sampleTimestamps(newTestSamples(dataSourceId, num))...)
+	}
+
+	rollups, _, err := dataStore.GetRollups("ds-a", RollupResolutions[0], testTime(0), testTime(60), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 1 || rollups[0].Count != 3 {
+		t.Errorf("expected the migrated rollup, got %+v", rollups)
+	}
+
+	err = dataStore.db.View(func(tx *bolt.Tx) error {
+		if version := tx.Bucket([]byte(BoltMetaBucket)).Get([]byte(BoltSchemaVersionKey)); binary.BigEndian.Uint16(version) != BoltSchemaVersion {
+			t.Errorf("expected schema version %d, got %v", BoltSchemaVersion, version)
+		}
+
+		// nothing but per-source buckets is left at the top level
+		for _, bucketName := range []string{BoltSamplesBucket, BoltRollupsBucket} {
+			tx.Bucket([]byte(bucketName)).ForEach(func(k, v []byte) error {
+				if v != nil {
+					t.Errorf("expected only nested buckets within %s, got the key %s", bucketName, k)
+				}
+				return nil
+			})
+		}
+
+		if v := getNestedBucket(tx.Bucket([]byte(BoltQuarantineBucket)), BoltSamplesBucket).Get([]byte("unreadable")); string(v) != "value" {
+			t.Errorf("expected the unreadable key to be quarantined, got %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPersistentDataStoreReporterDiscardsSamples(t *testing.T) {
+	dataFileAbsPath := filepath.Join(t.TempDir(), "kb.db")
+	r, dataStore := newTestPersistentDataStoreReporter(t, dataFileAbsPath, 2, BufferOverflowSpill)
+	atomic.StoreInt32(&dataStore.fail, 1)
+
+	for i, dataSourceId := range []string{"ds-a", "ds-b", "ds-a", "ds-b"} {
+		r.OnSample(NewSample("1", testTime(i), dataSourceId, nil))
+	}
+	if err := r.DiscardSamples("ds-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := r.Stats(); stats.Buffered != 1 || stats.Spilled != 1 {
+		t.Fatalf("expected a buffered and a spilled sample, got %+v", stats)
+	}
+
+	// neither log must bring the discarded samples back after a crash
+	wal, samples := openTestWriteAheadLog(t, dataFileAbsPath+SampleWriteAheadLogFileSuffix)
+	wal.Close()
+	assertTimestamps(t, samples, testTime(1))
+	spillLog, samples := openTestWriteAheadLog(t, dataFileAbsPath+SampleSpillLogFileSuffix)
+	spillLog.Close()
+	assertTimestamps(t, samples, testTime(3))
+
+	atomic.StoreInt32(&dataStore.fail, 0)
+	r.ShutDown()
+}
+
+func TestPersistentDataStoreReporterDiscardKeepsRetryCount(t *testing.T) {
+	dataFileAbsPath := filepath.Join(t.TempDir(), "kb.db")
+	dataStore := &failingDataStore{BoltDataStore: NewBoltDataStore(dataFileAbsPath), fail: 1}
+	// the flush ticker never fires, flushes are triggered by the test
+	r := NewPersistentDataStoreReporter(
+		dataStore,
+		NewSampleWriteAheadLog(dataFileAbsPath+SampleWriteAheadLogFileSuffix),
+		NewSampleWriteAheadLog(dataFileAbsPath+SampleSpillLogFileSuffix),
+		time.Hour,
+		10,
+		BufferOverflowSpill,
+	)
+	if err := r.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, dataSourceId := range []string{"ds-b", "ds-b", "ds-a", "ds-a"} {
+		r.OnSample(NewSample("1", testTime(i), dataSourceId, nil))
+	}
+	r.Stats()
+	if err := r.flush(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+
+	if err := r.DiscardSamples("ds-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&dataStore.fail, 0)
+	r.Stats()
+	if err := r.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := r.Stats(); stats.TotalRetried != 2 {
+		t.Errorf("expected 2 retried samples, got %+v", stats)
+	}
+	r.ShutDown()
+}
+
+func TestBoltDataStoreSkipsSamplesOfPurgedDataSources(t *testing.T) {
+	dataStore := newTestBoltDataStore(t)
+
+	if err := dataStore.PersistSamples(newTestSamples("ds-a", 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.PurgeSamples("ds-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// e.g. a retrieval that has still been in flight
+	if err := dataStore.PersistSamples(append(newTestSamples("ds-a", 3), newTestSamples("ds-b", 1)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	dataSourceIds, err := dataStore.GetStoredDataSourceIds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dataSourceIds, []string{"ds-b"}) {
+		t.Errorf("expected only the samples of ds-b to be stored, got the ones of %v", dataSourceIds)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+type testSocketIOApi struct{}
+
+func (io testSocketIOApi) Handler() http.Handler {
+	return http.NotFoundHandler()
+}
+
+func (io testSocketIOApi) Broadcast(sample *Sample) {
+}
+
+type testRestApi struct {
+	*KasperbrettRestApi
+	dataStore                   *BoltDataStore
+	persistentDataStoreReporter *PersistentDataStoreReporter
+	scheduler                   Scheduler
+	dataFileAbsPath             string
+}
+
+// newTestRestApi wires up the REST API like Kasperbrett.Prepare() does, without listening on a port.
+func newTestRestApi(t *testing.T) *testRestApi {
+	t.Helper()
+	dataFileAbsPath := filepath.Join(t.TempDir(), "kb.db")
+	dataStore := NewBoltDataStore(dataFileAbsPath)
+	persistentDataStoreReporter := NewPersistentDataStoreReporter(
+		dataStore,
+		NewSampleWriteAheadLog(dataFileAbsPath+SampleWriteAheadLogFileSuffix),
+		NewSampleWriteAheadLog(dataFileAbsPath+SampleSpillLogFileSuffix),
+		time.Hour,
+		100,
+		BufferOverflowSpill,
+	)
+	dataSourceStatusReporter := NewDataSourceStatusReporter(dataStore, time.Hour)
+
+	reportingEngine := NewKasperbrettReportingEngine()
+	if err := reportingEngine.Register(persistentDataStoreReporter, dataSourceStatusReporter); err != nil {
+		t.Fatal(err)
+	}
+	scheduler := NewKasperbrettScheduler(reportingEngine)
+	retentionJanitor := NewRetentionJanitor(dataStore, 0, map[string]time.Duration{}, time.Hour)
+
+	t.Cleanup(func() {
+		_, errorChan := scheduler.ShutDown()
+		<-errorChan
+		retentionJanitor.ShutDown()
+		reportingEngine.ShutDown()
+	})
+
+	return &testRestApi{
+		KasperbrettRestApi:          NewKasperbrettRestApi(":0", "/realtime/", testSocketIOApi{}, dataStore, persistentDataStoreReporter, dataSourceStatusReporter, scheduler, retentionJanitor),
+		dataStore:                   dataStore,
+		persistentDataStoreReporter: persistentDataStoreReporter,
+		scheduler:                   scheduler,
+		dataFileAbsPath:             dataFileAbsPath,
+	}
+}
+
+// do sends the request to the REST API and decodes the JSON response into res (unless it's nil).
+func (rest *testRestApi) do(t *testing.T, method string, path string, body interface{}, res interface{}) int {
+	t.Helper()
+	var reqBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewReader(bodyBytes)
+	}
+
+	req := httptest.NewRequest(method, path, reqBody)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	rest.macaron.ServeHTTP(rec, req)
+
+	if res != nil && rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+			t.Fatalf("%s %s: couldn't decode response %q: %s", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func (rest *testRestApi) isScheduled(t *testing.T, dataSourceId string) bool {
+	t.Helper()
+	jobsChan, errorChan := rest.scheduler.GetAll()
+	select {
+	case jobs := <-jobsChan:
+		_, ok := jobs[dataSourceId]
+		return ok
+	case err := <-errorChan:
+		t.Fatal(err)
+		return false
+	}
+}
+
+// newTestHtmlServer serves an HTML page whose h1 contains the given value.
+func newTestHtmlServer(t *testing.T, value string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><body><h1>%s</h1><h2>%s0</h2></body></html>", value, value)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestUrlScraperDto(url string, cssPath string) DataSourceDto {
+	return DataSourceDto{
+		Type:         DsUrlScraper,
+		Name:         "Answer",
+		Interval:     60000,
+		Timeout:      5000,
+		TypeSettings: map[string]string{"url": url, "cssPath": cssPath},
+	}
+}
+
+func (rest *testRestApi) createDataSource(t *testing.T, dto DataSourceDto) string {
+	t.Helper()
+	var res DataSourceResponse
+	if status := rest.do(t, "POST", "/api/datasources", dto, &res); status != 200 {
+		t.Fatalf("expected the data source to be created, got status %d", status)
+	}
+	return res.DataSourceId
+}
+
+func TestRestApiUpdatesDataSources(t *testing.T) {
+	rest := newTestRestApi(t)
+	server := newTestHtmlServer(t, "42")
+	dataSourceId := rest.createDataSource(t, newTestUrlScraperDto(server.URL, "h1"))
+
+	dto := newTestUrlScraperDto(server.URL, "h2")
+	dto.Name = "Answer x 10"
+	var res DataSourceResponse
+	if status := rest.do(t, "PUT", "/api/datasources/"+dataSourceId, dto, &res); status != 200 || res.DataSourceId != dataSourceId || res.Value != "420" {
+		t.Fatalf("expected the data source to be updated, got status %d and %+v", status, res)
+	}
+
+	dataSource, err := rest.dataStore.GetDataSource(dataSourceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataSource.Name() != "Answer x 10" || dataSource.(*UrlScraper).cssPath != "h2" {
+		t.Errorf("expected the updated definition to be stored, got %+v", dataSource)
+	}
+	if !rest.isScheduled(t, dataSourceId) {
+		t.Error("expected the updated data source to be scheduled")
+	}
+
+	// a definition that fails the retrieval test isn't stored
+	if status := rest.do(t, "PUT", "/api/datasources/"+dataSourceId, newTestUrlScraperDto(server.URL, "h3"), nil); status != 400 {
+		t.Errorf("expected status 400 for a failing retrieval, got %d", status)
+	}
+	if dataSource, _ = rest.dataStore.GetDataSource(dataSourceId); dataSource.(*UrlScraper).cssPath != "h2" {
+		t.Errorf("expected the previous definition to be kept, got %+v", dataSource)
+	}
+
+	dto.Type = DsJsonApi
+	if status := rest.do(t, "PUT", "/api/datasources/"+dataSourceId, dto, nil); status != 400 {
+		t.Errorf("expected status 400 for a type change, got %d", status)
+	}
+	if status := rest.do(t, "PUT", "/api/datasources/ds-unknown", newTestUrlScraperDto(server.URL, "h1"), nil); status != 404 {
+		t.Errorf("expected status 404 for an unknown data source, got %d", status)
+	}
+}
+
+func TestRestApiPausesAndResumesDataSources(t *testing.T) {
+	rest := newTestRestApi(t)
+	server := newTestHtmlServer(t, "42")
+	dataSourceId := rest.createDataSource(t, newTestUrlScraperDto(server.URL, "h1"))
+
+	for _, paused := range []bool{true, true, false, false} {
+		action := "resume"
+		if paused {
+			action = "pause"
+		}
+
+		var res DataSourcePauseResponse
+		if status := rest.do(t, "POST", "/api/datasources/"+dataSourceId+"/"+action, nil, &res); status != 200 || res.Paused != paused {
+			t.Fatalf("%s: expected paused to be %t, got status %d and %+v", action, paused, status, res)
+		}
+
+		dataSource, err := rest.dataStore.GetDataSource(dataSourceId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dataSource.Paused() != paused {
+			t.Errorf("%s: expected the stored paused flag to be %t", action, paused)
+		}
+		if rest.isScheduled(t, dataSourceId) == paused {
+			t.Errorf("%s: expected the data source to be scheduled: %t", action, !paused)
+		}
+	}
+
+	if status := rest.do(t, "POST", "/api/datasources/ds-unknown/pause", nil, nil); status != 404 {
+		t.Errorf("expected status 404 for an unknown data source, got %d", status)
+	}
+}
+
+func TestRestApiDeletesDataSources(t *testing.T) {
+	rest := newTestRestApi(t)
+	server := newTestHtmlServer(t, "42")
+	keptDataSourceId := rest.createDataSource(t, newTestUrlScraperDto(server.URL, "h1"))
+	purgedDataSourceId := rest.createDataSource(t, newTestUrlScraperDto(server.URL, "h1"))
+
+	for _, dataSourceId := range []string{keptDataSourceId, purgedDataSourceId} {
+		if err := rest.dataStore.PersistSamples(newTestSamples(dataSourceId, 2)); err != nil {
+			t.Fatal(err)
+		}
+		rest.persistentDataStoreReporter.OnSample(NewSample("1", testTime(10), dataSourceId, nil))
+	}
+
+	if status := rest.do(t, "DELETE", "/api/datasources/"+keptDataSourceId, nil, nil); status != 204 {
+		t.Fatalf("expected status 204, got %d", status)
+	}
+	if status := rest.do(t, "DELETE", "/api/datasources/"+purgedDataSourceId+"?purge-samples=1", nil, nil); status != 204 {
+		t.Fatalf("expected status 204, got %d", status)
+	}
+
+	for _, dataSourceId := range []string{keptDataSourceId, purgedDataSourceId} {
+		if _, err := rest.dataStore.GetDataSource(dataSourceId); err != ErrDataSourceNotFound {
+			t.Errorf("expected %s to be deleted, got %v", dataSourceId, err)
+		}
+		if rest.isScheduled(t, dataSourceId) {
+			t.Errorf("expected the job of %s to be canceled", dataSourceId)
+		}
+		if status := rest.do(t, "DELETE", "/api/datasources/"+dataSourceId, nil, nil); status != 404 {
+			t.Errorf("expected status 404 for a deleted data source, got %d", status)
+		}
+	}
+
+	// a retrieval that has still been in flight must not bring back the purged samples
+	rest.persistentDataStoreReporter.OnSample(NewSample("1", testTime(11), purgedDataSourceId, nil))
+	rest.persistentDataStoreReporter.Stats()
+
+	wal, samples := openTestWriteAheadLog(t, rest.dataFileAbsPath+SampleWriteAheadLogFileSuffix)
+	wal.Close()
+	assertTimestamps(t, samples, testTime(10), testTime(11))
+
+	rest.persistentDataStoreReporter.flush()
+	rest.persistentDataStoreReporter.Stats()
+
+	keptSamples, err := rest.dataStore.GetSamples(keptDataSourceId, testTime(-1), testTime(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTimestamps(t, keptSamples, testTime(0), testTime(1), testTime(10))
+
+	purgedSamples, err := rest.dataStore.GetSamples(purgedDataSourceId, testTime(-1), testTime(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTimestamps(t, purgedSamples)
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	tests := []struct {
+		retry    int
+		random   float64
+		jitter   float64
+		expected time.Duration
+	}{
+		{1, 0.5, 0, time.Second},
+		{2, 0.5, 0, 2 * time.Second},
+		{3, 0.5, 0, 4 * time.Second},
+		{4, 0.5, 0, 5 * time.Second},
+		{10, 0.5, 0, 5 * time.Second},
+		// the jitter randomizes the backoff in both directions
+		{2, 0, 0.5, time.Second},
+		{2, 0.5, 0.5, 2 * time.Second},
+		{2, 0.75, 0.5, 2500 * time.Millisecond},
+		{4, 0.75, 0.5, 6250 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		p.Jitter = test.jitter
+		if actual := p.Backoff(test.retry, test.random); actual != test.expected {
+			t.Errorf("retry %d (random %g, jitter %g): expected %s, got %s", test.retry, test.random, test.jitter, test.expected, actual)
+		}
+	}
+}
+
+func TestRetryPolicyMaxDuration(t *testing.T) {
+	tests := []struct {
+		policy   RetryPolicy
+		expected time.Duration
+	}{
+		{RetryPolicy{MaxAttempts: 1, InitialBackoff: time.Second, MaxBackoff: 30 * time.Second}, 10 * time.Second},
+		{RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: 30 * time.Second}, 33 * time.Second},
+		{RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: 30 * time.Second, Jitter: 0.5}, 34500 * time.Millisecond},
+		{RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Second, MaxBackoff: 30 * time.Second}, 100*time.Second + 151*time.Second},
+	}
+
+	for _, test := range tests {
+		if actual := test.policy.MaxDuration(10 * time.Second); actual != test.expected {
+			t.Errorf("%+v: expected %s, got %s", test.policy, test.expected, actual)
+		}
+	}
+}
+
+func TestNewDataSourceFromDtoValidatesRetries(t *testing.T) {
+	tests := []struct {
+		retry RetryPolicyDto
+		valid bool
+	}{
+		{RetryPolicyDto{}, true},
+		{RetryPolicyDto{MaxAttempts: 3}, true},
+		// 10 x 10s + 1s + 2s + 4s + 8s + 16s + 4 x 30s
+		{RetryPolicyDto{MaxAttempts: 10}, false},
+		{RetryPolicyDto{MaxAttempts: 5, InitialBackoff: 5000}, false},
+		{RetryPolicyDto{MaxAttempts: 2, InitialBackoff: 10000, MaxBackoff: 5000}, false},
+		{RetryPolicyDto{MaxAttempts: 2, InitialBackoff: 40000}, false},
+		{RetryPolicyDto{MaxAttempts: MaxRetrievalAttempts + 1}, false},
+	}
+
+	for _, test := range tests {
+		dto := newTestUrlScraperDto("http://localhost/", "h1")
+		dto.Interval = 60000
+		dto.Timeout = 10000
+		dto.Retry = test.retry
+		_, err := NewDataSourceFromDto(dto, "ds-a")
+		if test.valid && err != nil {
+			t.Errorf("%+v: unexpected error: %s", test.retry, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%+v: expected an error", test.retry)
+		}
+	}
+}
+
+func TestCircuitBreakerRecord(t *testing.T) {
+	cb := &CircuitBreaker{settings: CircuitBreakerSettings{Threshold: 3, Interval: 10 * time.Minute}, interval: time.Minute}
+
+	for i, test := range []struct {
+		success  bool
+		expected time.Duration
+		open     bool
+	}{
+		{false, time.Minute, false},
+		{false, time.Minute, false},
+		{false, 10 * time.Minute, true},
+		{false, 10 * time.Minute, true},
+		{true, time.Minute, false},
+		{false, time.Minute, false},
+	} {
+		if actual := cb.Record(test.success); actual != test.expected || cb.IsOpen() != test.open {
+			t.Errorf("record %d (success %t): expected %s (open %t), got %s (open %t)", i, test.success, test.expected, test.open, actual, cb.IsOpen())
+		}
+	}
+
+	// a threshold of 0 disables the circuit breaker
+	cb = &CircuitBreaker{settings: CircuitBreakerSettings{Interval: 10 * time.Minute}, interval: time.Minute}
+	for i := 0; i < 5; i++ {
+		if actual := cb.Record(false); actual != time.Minute || cb.IsOpen() {
+			t.Fatalf("expected a disabled circuit breaker, got %s (open %t)", actual, cb.IsOpen())
+		}
+	}
+}
+
+// testDataSource retrieves its samples with a function of the test.
+type testDataSource struct {
+	AbstractDataSource
+	retrieve func(ctx context.Context, sampleChan chan *Sample)
+}
+
+func (ds *testDataSource) Retrieve(ctx context.Context, sampleChan chan *Sample) {
+	ds.retrieve(ctx, sampleChan)
+}
+
+func (ds *testDataSource) Type() string {
+	return "test"
+}
+
+func (ds *testDataSource) GobEncode() ([]byte, error) {
+	return nil, errors.New("Test data sources can't be encoded.")
+}
+
+func (ds *testDataSource) GobDecode(dataSourceBytes []byte) error {
+	return errors.New("Test data sources can't be decoded.")
+}
+
+func TestRetrieveWithRetriesIsLimitedToTheInterval(t *testing.T) {
+	ds := &testDataSource{
+		AbstractDataSource: NewAbstractDataSourceWithId("ds-a", "Hanging", 200*time.Millisecond, 80*time.Millisecond),
+		retrieve: func(ctx context.Context, sampleChan chan *Sample) {
+			<-ctx.Done()
+			sampleChan <- NewSample("", time.Now(), "ds-a", ctx.Err())
+		},
+	}
+	ds.SetRetryPolicy(RetryPolicy{MaxAttempts: MaxRetrievalAttempts, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	start := time.Now()
+	sample := RetrieveWithRetries(context.Background(), ds)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the attempts to be aborted after the interval, took %s", elapsed)
+	}
+	if sample.Err == nil || sample.Retries == 0 || sample.Retries >= MaxRetrievalAttempts-1 {
+		t.Errorf("expected a failed sample after a few retries, got %+v", sample)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// newTestServer responds to every request with the given content type and body.
+func newTestServer(t *testing.T, contentType string, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRetrievalTraceOfUrlScrapers(t *testing.T) {
+	page := "<html><body><ul><li> 4,2 </li><li>7</li></ul></body></html>"
+	server := newTestServer(t, "text/html", page)
+	urlScraper, err := NewUrlScraper(
+		NewAbstractDataSourceWithId("ds-a", "Items", time.Minute, 5*time.Second),
+		server.URL, "li:first-child", `[{"type": "trim"}, {"type": "number", "locale": "de"}]`, "",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, trace := WithRetrievalTrace(context.Background())
+	sample := Retrieve(ctx, urlScraper, urlScraper.Timeout())
+	if sample.Err != nil || sample.Value != "4.2" {
+		t.Fatalf("expected '4.2', got %+v", sample)
+	}
+
+	dto := trace.Dto()
+	if dto.Http == nil || dto.Http.StatusCode != 200 || dto.Http.BodySize != len(page) || dto.Http.Truncated {
+		t.Errorf("expected the HTTP exchange to be traced, got %+v", dto.Http)
+	}
+	if dto.Dom == nil || dto.Dom.CssPath != "li:first-child" || dto.Dom.Matches != 1 {
+		t.Errorf("expected the DOM selection to be traced, got %+v", dto.Dom)
+	}
+	if dto.RawValue != " 4,2 " || len(dto.Transformations) != 2 {
+		t.Errorf("expected the raw value and 2 transformations to be traced, got %+v", dto)
+	}
+}
+
+func TestDoTracedRequestRejectsTooLargeBodies(t *testing.T) {
+	page := "<html><body><h1>42</h1></body></html>"
+	server := newTestServer(t, "text/html", page)
+	urlScraper := newTestUrlScraper(t, "ds-a")
+	urlScraper.url = server.URL
+
+	defer func(maxResponseBodySize int64) { MaxResponseBodySize = maxResponseBodySize }(MaxResponseBodySize)
+
+	MaxResponseBodySize = int64(len(page))
+	if sample := Retrieve(context.Background(), urlScraper, urlScraper.Timeout()); sample.Err != nil || sample.Value != "42" {
+		t.Errorf("expected a body of exactly the maximum size to be parsed, got %+v", sample)
+	}
+
+	MaxResponseBodySize = int64(len(page) - 1)
+	ctx, trace := WithRetrievalTrace(context.Background())
+	sample := Retrieve(ctx, urlScraper, urlScraper.Timeout())
+	if _, ok := sample.Err.(*ResponseBodyTooLargeError); !ok {
+		t.Errorf("expected a too large body to be rejected instead of being parsed, got %+v", sample)
+	}
+	if httpTrace := trace.Dto().Http; httpTrace == nil || !httpTrace.Truncated || len(httpTrace.Error) == 0 {
+		t.Errorf("expected the truncation to be traced, got %+v", httpTrace)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestResolveRegexGroup(t *testing.T) {
+	tests := []struct {
+		pattern string
+		group   string
+		index   int
+		valid   bool
+	}{
+		{`Total: \d+`, "", 0, true},
+		{`Total: (\d+)`, "", 1, true},
+		{`(\w+): (\d+)`, "2", 2, true},
+		{`(\w+): (\d+)`, "0", 0, true},
+		{`(\w+): (?P<count>\d+)`, "count", 2, true},
+		{`(\w+): (\d+)`, "3", 0, false},
+		{`(\w+): (\d+)`, "-1", 0, false},
+		{`(\w+): (?P<count>\d+)`, "total", 0, false},
+	}
+
+	for _, test := range tests {
+		index, err := ResolveRegexGroup(regexp.MustCompile(test.pattern), test.group)
+		if test.valid && (err != nil || index != test.index) {
+			t.Errorf("expected group '%s' of %s to resolve to %d, got %d (%v)", test.group, test.pattern, test.index, index, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected group '%s' of %s to be rejected, got %d", test.group, test.pattern, index)
+		}
+	}
+}
+
+func TestHttpRegexExtract(t *testing.T) {
+	body := "Total Accesses: 42\nTotal kBytes: 1337\nBusyWorkers: \nIdleWorkers: 8\n"
+	tests := []struct {
+		pattern string
+		group   string
+		mode    string
+		value   string
+		valid   bool
+	}{
+		{`(?m)^Total Accesses: (\d+)$`, "", HttpRegexModeCapture, "42", true},
+		{`(?m)^Total kBytes: (?P<kb>\d+)$`, "kb", HttpRegexModeCapture, "1337", true},
+		{`(?m)^Total (\w+)`, "0", "", "Total Accesses", true},
+		{`(?m)^Total `, "", HttpRegexModeCount, "2", true},
+		{`Uptime: \d+`, "", HttpRegexModeCount, "0", true},
+		{`Uptime: (\d+)`, "", HttpRegexModeCapture, "", false},
+		{`(?m)^BusyWorkers: (\d*)$`, "", HttpRegexModeCapture, "", false},
+	}
+
+	for _, test := range tests {
+		httpRegex, err := NewHttpRegex(NewAbstractDataSourceWithId("ds-a", "ds-a", time.Minute, 10*time.Second), "http://localhost/", test.pattern, test.group, test.mode, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := httpRegex.extract(body)
+		if test.valid && (err != nil || value != test.value) {
+			t.Errorf("expected %s (%s) to extract '%s', got '%s' (%v)", test.pattern, test.mode, test.value, value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected %s (%s) to fail, got '%s'", test.pattern, test.mode, value)
+		}
+	}
+}
+
+func TestNewHttpRegexRejectsInvalidGroups(t *testing.T) {
+	abstractDataSource := NewAbstractDataSourceWithId("ds-a", "ds-a", time.Minute, 10*time.Second)
+	if _, err := NewHttpRegex(abstractDataSource, "http://localhost/", `Total: (\d+)`, "2", HttpRegexModeCapture, "", ""); err == nil {
+		t.Error("expected a missing group to be rejected in capture mode")
+	}
+	if _, err := NewHttpRegex(abstractDataSource, "http://localhost/", `Total: (\d+)`, "2", HttpRegexModeCount, "", ""); err != nil {
+		t.Errorf("expected the group to be ignored in count mode, got %v", err)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestParseXPathNamespaces(t *testing.T) {
+	namespaces, err := ParseXPathNamespaces(" ")
+	if err != nil || len(namespaces) != 0 {
+		t.Errorf("expected no namespaces, got %v (%v)", namespaces, err)
+	}
+
+	namespaces, err = ParseXPathNamespaces(`{"atom": "http://www.w3.org/2005/Atom"}`)
+	if err != nil || !reflect.DeepEqual(namespaces, map[string]string{"atom": "http://www.w3.org/2005/Atom"}) {
+		t.Errorf("expected the atom namespace, got %v (%v)", namespaces, err)
+	}
+
+	for _, definition := range []string{`["atom"]`, `{"atom": 1}`, `{"": "http://www.w3.org/2005/Atom"}`, `{"atom": ""}`} {
+		if namespaces, err := ParseXPathNamespaces(definition); err == nil {
+			t.Errorf("expected %s to be rejected, got %v", definition, namespaces)
+		}
+	}
+}
+
+func TestEvaluateXPath(t *testing.T) {
+	document, err := xmlquery.Parse(strings.NewReader(`<feed xmlns="http://www.w3.org/2005/Atom">` +
+		`<entry><title>First</title><votes>3</votes></entry>` +
+		`<entry><title> </title><votes>4.5</votes></entry>` +
+		`</feed>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces := map[string]string{"atom": "http://www.w3.org/2005/Atom"}
+
+	tests := []struct {
+		xPath string
+		value string
+		valid bool
+	}{
+		{"//atom:entry/atom:title", "First", true},
+		{"count(//atom:entry)", "2", true},
+		{"sum(//atom:votes)", "7.5", true},
+		{"count(//atom:entry) > 1", "true", true},
+		{"concat(//atom:title, '!')", "First!", true},
+		{"//atom:entry[2]/atom:title", "", false},
+		{"//atom:author", "", false},
+		{"number(//atom:title)", "", false},
+		{"substring(//atom:title, 10)", "", false},
+	}
+
+	for _, test := range tests {
+		expr, err := xpath.CompileWithNS(test.xPath, namespaces)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := EvaluateXPath(document, expr)
+		if test.valid && (err != nil || value != test.value) {
+			t.Errorf("expected %s to evaluate to '%s', got '%s' (%v)", test.xPath, test.value, value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected %s to fail, got '%s'", test.xPath, value)
+		}
+	}
+}
+
+func TestXmlApiRetrievesConcurrently(t *testing.T) {
+	server := newTestServer(t, "text/xml", "<items><item>1</item><item>2</item><item>3</item></items>")
+	xmlApi, err := NewXmlApi(NewAbstractDataSourceWithId("ds-a", "ds-a", time.Minute, 10*time.Second), server.URL, "", "", "", "//item", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := make(chan *Sample, 8)
+	for i := 0; i < cap(samples); i++ {
+		go func() {
+			samples <- Retrieve(context.Background(), xmlApi, xmlApi.Timeout())
+		}()
+	}
+	for i := 0; i < cap(samples); i++ {
+		if sample := <-samples; sample.Err != nil || sample.Value != "1" {
+			t.Errorf("expected '1', got %+v", sample)
+		}
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestLimitedBuffer(t *testing.T) {
+	buffer := &LimitedBuffer{Limit: 5}
+	for _, p := range []string{"abc", "def", "ghi"} {
+		if n, err := buffer.Write([]byte(p)); n != len(p) || err != nil {
+			t.Errorf("expected all %d bytes of '%s' to be accepted, got %d (%v)", len(p), p, n, err)
+		}
+	}
+	if buffer.String() != "abcde" || !buffer.Truncated {
+		t.Errorf("expected 'abcde' to be kept and the buffer to be truncated, got '%s' (%v)", buffer.String(), buffer.Truncated)
+	}
+
+	buffer = &LimitedBuffer{Limit: 5}
+	if n, err := io.Copy(buffer, strings.NewReader("abcde")); n != 5 || err != nil {
+		t.Errorf("expected 5 bytes to be copied, got %d (%v)", n, err)
+	}
+	if buffer.String() != "abcde" || buffer.Truncated {
+		t.Errorf("expected 'abcde' to be kept without truncation, got '%s' (%v)", buffer.String(), buffer.Truncated)
+	}
+}
+
+func TestParseCommandArgs(t *testing.T) {
+	args, err := ParseCommandArgs(" ")
+	if err != nil || len(args) != 0 {
+		t.Errorf("expected no arguments, got %v (%v)", args, err)
+	}
+
+	args, err = ParseCommandArgs(`["-c", "ls | wc -l"]`)
+	if err != nil || !reflect.DeepEqual(args, []string{"-c", "ls | wc -l"}) {
+		t.Errorf("expected 2 arguments, got %v (%v)", args, err)
+	}
+
+	for _, definition := range []string{`-c`, `{"a": "b"}`, `[1, 2]`} {
+		if args, err := ParseCommandArgs(definition); err == nil {
+			t.Errorf("expected %s to be rejected, got %v", definition, args)
+		}
+	}
+}
+
+func TestParseCommandEnv(t *testing.T) {
+	env, err := ParseCommandEnv("")
+	if err != nil || len(env) != 0 {
+		t.Errorf("expected no variables, got %v (%v)", env, err)
+	}
+
+	env, err = ParseCommandEnv(`{"LANG": "C", "EMPTY": ""}`)
+	if err != nil || !reflect.DeepEqual(env, map[string]string{"LANG": "C", "EMPTY": ""}) {
+		t.Errorf("expected 2 variables, got %v (%v)", env, err)
+	}
+
+	for _, definition := range []string{`["LANG=C"]`, `{"LANG": 1}`, `{"": "C"}`, `{"A=B": "C"}`, "{\"A\\u0000\": \"C\"}"} {
+		if env, err := ParseCommandEnv(definition); err == nil {
+			t.Errorf("expected %s to be rejected, got %v", definition, env)
+		}
+	}
+}
+
+func TestCommandExtract(t *testing.T) {
+	output := "  up 42 days\nusers: \n"
+	tests := []struct {
+		pattern string
+		group   string
+		value   string
+		valid   bool
+	}{
+		{"", "", "up 42 days\nusers:", true},
+		{`up (\d+) days`, "", "42", true},
+		{`up (?P<days>\d+) (days)`, "days", "42", true},
+		{`up \d+`, "", "up 42", true},
+		{`down (\d+)`, "", "", false},
+		{`users: (\w*)`, "", "", false},
+	}
+
+	for _, test := range tests {
+		command, err := NewCommand(NewAbstractDataSourceWithId("ds-a", "ds-a", time.Minute, 10*time.Second), "uptime", "", "", "", test.pattern, test.group, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := command.extract(output)
+		if test.valid && (err != nil || value != test.value) {
+			t.Errorf("expected '%s' to extract '%s', got '%s' (%v)", test.pattern, test.value, value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected '%s' to fail, got '%s'", test.pattern, value)
+		}
+	}
+
+	command, err := NewCommand(NewAbstractDataSourceWithId("ds-a", "ds-a", time.Minute, 10*time.Second), "true", "", "", "", "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := command.extract(" \n"); err == nil {
+		t.Errorf("expected an empty output to fail, got '%s'", value)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// newTestProcPath creates a fixture of a proc filesystem with the given files (relative path -> content).
+func newTestProcPath(t *testing.T, files map[string]string) string {
+	t.Helper()
+	procPath := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(procPath, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return procPath
+}
+
+const testProcStat = `cpu  100 20 30 400 50 6 7 8 9 10
+cpu0 50 10 15 200 25 3 3 4 4 5
+intr 12345 0 0
+procs_running 3
+procs_blocked 1
+`
+
+func TestReadCpuTimes(t *testing.T) {
+	procPath := newTestProcPath(t, map[string]string{"stat": testProcStat})
+
+	// guest times (the 9th and 10th field) are part of the user times
+	idle, total, err := ReadCpuTimes(procPath)
+	if err != nil || idle != 450 || total != 621 {
+		t.Errorf("expected 450 idle of 621 ticks, got %d of %d (%v)", idle, total, err)
+	}
+
+	procPath = newTestProcPath(t, map[string]string{"stat": "cpu0 50 10 15 200\n"})
+	if _, _, err := ReadCpuTimes(procPath); err == nil {
+		t.Error("expected a missing cpu line to fail")
+	}
+
+	procPath = newTestProcPath(t, map[string]string{"stat": "cpu 100 20 secret 400\n"})
+	if _, _, err := ReadCpuTimes(procPath); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("expected an invalid counter to fail without echoing it, got %v", err)
+	}
+}
+
+func TestReadMemory(t *testing.T) {
+	procPath := newTestProcPath(t, map[string]string{"meminfo": "MemTotal:       1000 kB\nMemFree:         100 kB\nMemAvailable:    600 kB\nBuffers:          50 kB\nCached:          200 kB\nHugePages_Total:       0\n"})
+	total, available, err := ReadMemory(procPath)
+	if err != nil || total != 1000*1024 || available != 600*1024 {
+		t.Errorf("expected 600 of 1000 KiB to be available, got %d of %d (%v)", available, total, err)
+	}
+
+	// kernels before 3.14 don't provide MemAvailable
+	procPath = newTestProcPath(t, map[string]string{"meminfo": "MemTotal:       1000 kB\nMemFree:         100 kB\nBuffers:          50 kB\nCached:          200 kB\n"})
+	total, available, err = ReadMemory(procPath)
+	if err != nil || total != 1000*1024 || available != 350*1024 {
+		t.Errorf("expected free, buffers, and cached memory (350 of 1000 KiB) to be available, got %d of %d (%v)", available, total, err)
+	}
+
+	procPath = newTestProcPath(t, map[string]string{"meminfo": "MemTotal:       1000 kB\nMemFree:         900 kB\nCached:          200 kB\n"})
+	total, available, err = ReadMemory(procPath)
+	if err != nil || available != total {
+		t.Errorf("expected the available memory to be capped at the total memory, got %d of %d (%v)", available, total, err)
+	}
+
+	procPath = newTestProcPath(t, map[string]string{"meminfo": "MemFree:         100 kB\n"})
+	if _, _, err := ReadMemory(procPath); err == nil {
+		t.Error("expected a missing total memory to fail")
+	}
+}
+
+func TestReadNetworkBytes(t *testing.T) {
+	netDev := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1234      10    0    0    0     0          0         0     1234      10    0    0    0     0       0          0
+  eth0:123456789012 1000    0    0    0     0          0         0 987654321     900    0    0    0     0       0          0
+ wlan0: 42 1 0 0 0 0 0 0 7 1 0 0 0 0 0 0
+`
+	procPath := newTestProcPath(t, map[string]string{"net/dev": netDev})
+
+	tests := []struct {
+		networkInterface string
+		rx, tx           uint64
+	}{
+		{"lo", 1234, 1234},
+		{"eth0", 123456789012, 987654321},
+		{"wlan0", 42, 7},
+	}
+	for _, test := range tests {
+		rx, tx, err := ReadNetworkBytes(procPath, test.networkInterface)
+		if err != nil || rx != test.rx || tx != test.tx {
+			t.Errorf("expected %s to have received %d and transmitted %d bytes, got %d and %d (%v)", test.networkInterface, test.rx, test.tx, rx, tx, err)
+		}
+	}
+
+	for _, networkInterface := range []string{"eth1", "eth", "face"} {
+		if _, _, err := ReadNetworkBytes(procPath, networkInterface); err == nil {
+			t.Errorf("expected the missing interface %s to fail", networkInterface)
+		}
+	}
+}
+
+func TestSystemMetricsDataSourceReadsProcPath(t *testing.T) {
+	defer func(procPath string) { ProcPath = procPath }(ProcPath)
+	ProcPath = newTestProcPath(t, map[string]string{
+		"stat":    testProcStat,
+		"loadavg": "0.42 0.50 0.60 2/345 6789\n",
+		"1/stat":  "",
+		"42/stat": "",
+		"self":    "",
+	})
+
+	tests := map[string]string{
+		"load.5":            "0.50",
+		"processes.running": "3",
+		"processes.blocked": "1",
+		"processes.total":   "2",
+	}
+	for metric, value := range tests {
+		systemMetricsDs, err := NewSystemMetricsDataSource(NewAbstractDataSourceWithId("ds-a", "ds-a", time.Minute, 10*time.Second), metric, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sample := Retrieve(context.Background(), systemMetricsDs, systemMetricsDs.Timeout()); sample.Err != nil || sample.Value != value {
+			t.Errorf("expected %s to be '%s', got %+v", metric, value, sample)
+		}
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestParseExpectedStatus(t *testing.T) {
+	tests := map[string][]string{
+		"":           {"2xx"},
+		"200":        {"200"},
+		"200, 3XX ":  {"200", "3xx"},
+		"1xx,404,5x": nil,
+		"600":        nil,
+		"20":         nil,
+		"2x0":        nil,
+		"abc":        nil,
+		"200,":       nil,
+	}
+
+	for definition, expected := range tests {
+		expectedStatus, err := ParseExpectedStatus(definition)
+		if expected != nil && (err != nil || !reflect.DeepEqual(expectedStatus, expected)) {
+			t.Errorf("expected '%s' to be parsed as %v, got %v (%v)", definition, expected, expectedStatus, err)
+		}
+		if expected == nil && err == nil {
+			t.Errorf("expected '%s' to be rejected, got %v", definition, expectedStatus)
+		}
+	}
+}
+
+func TestStatusMatches(t *testing.T) {
+	expectedStatus := []string{"200", "3xx"}
+	for _, statusCode := range []int{200, 301, 302, 399} {
+		if !StatusMatches(expectedStatus, statusCode) {
+			t.Errorf("expected %d to match %v", statusCode, expectedStatus)
+		}
+	}
+	for _, statusCode := range []int{201, 204, 404, 500} {
+		if StatusMatches(expectedStatus, statusCode) {
+			t.Errorf("expected %d not to match %v", statusCode, expectedStatus)
+		}
+	}
+}
+
+func TestScanResponseBody(t *testing.T) {
+	body := strings.Repeat("x", 100000) + "<title>Welcome</title>" + strings.Repeat("y", 100)
+
+	// the substring spans several reads of the one byte reader
+	size, contains, err := ScanResponseBody(iotest.OneByteReader(strings.NewReader(body)), "<title>Welcome")
+	if err != nil || size != int64(len(body)) || !contains {
+		t.Errorf("expected %d bytes containing the title, got %d bytes (%v, %v)", len(body), size, contains, err)
+	}
+
+	size, contains, err = ScanResponseBody(strings.NewReader(body), "Goodbye")
+	if err != nil || size != int64(len(body)) || contains {
+		t.Errorf("expected %d bytes without 'Goodbye', got %d bytes (%v, %v)", len(body), size, contains, err)
+	}
+
+	size, contains, err = ScanResponseBody(strings.NewReader(""), "")
+	if err != nil || size != 0 || !contains {
+		t.Errorf("expected an empty substring to be contained in an empty body, got %d bytes (%v, %v)", size, contains, err)
+	}
+
+	if _, _, err := ScanResponseBody(iotest.TimeoutReader(strings.NewReader(body)), "Welcome"); err == nil {
+		t.Error("expected a read error to be returned")
+	}
+}
+
+func newTestHttpProbe(t *testing.T, url string, expectedStatus string, bodyContains string, headerName string, headerPattern string) *HttpProbe {
+	t.Helper()
+	httpProbe, err := NewHttpProbe(NewAbstractDataSourceWithId("ds-a", "ds-a", time.Minute, 10*time.Second), url, "", "", expectedStatus, bodyContains, headerName, headerPattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httpProbe
+}
+
+func TestHttpProbeAssert(t *testing.T) {
+	res := &http.Response{
+		Status:     "301 Moved Permanently",
+		StatusCode: 301,
+		Header:     http.Header{"Location": {"https://example.com/"}, "Cache-Control": {"no-cache", "max-age=60"}},
+	}
+
+	tests := []struct {
+		expectedStatus string
+		bodyContains   string
+		contains       bool
+		headerName     string
+		headerPattern  string
+		valid          bool
+	}{
+		{"3xx", "", false, "", "", true},
+		{"", "", false, "", "", false},
+		{"200, 301", "Moved", true, "", "", true},
+		{"301", "Moved", false, "", "", false},
+		{"301", "", false, "location", "", true},
+		{"301", "", false, "Set-Cookie", "", false},
+		{"301", "", false, "Cache-Control", `max-age=\d+`, true},
+		{"301", "", false, "Cache-Control", `no-store`, false},
+	}
+
+	for _, test := range tests {
+		httpProbe := newTestHttpProbe(t, "http://localhost/", test.expectedStatus, test.bodyContains, test.headerName, test.headerPattern)
+		err := httpProbe.assert(res, test.contains)
+		if test.valid && err != nil {
+			t.Errorf("expected %+v to pass, got %v", test, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected %+v to fail", test)
+		}
+	}
+}
+
+func TestHttpProbeRecordsRedirectsAndLargeBodies(t *testing.T) {
+	body := strings.Repeat("x", 1000) + "Welcome"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/old" {
+			http.Redirect(w, req, "/new", http.StatusMovedPermanently)
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	defer func(maxResponseBodySize int64) { MaxResponseBodySize = maxResponseBodySize }(MaxResponseBodySize)
+	MaxResponseBodySize = 100
+
+	httpProbe := newTestHttpProbe(t, server.URL+"/old", "3xx", "", "Location", "/new$")
+	sample := Retrieve(context.Background(), httpProbe, httpProbe.Timeout())
+	if sample.Err != nil || sample.Meta[HttpProbeStatusCode] != "301" || sample.Meta[HttpProbeAssertionPassed] != "true" {
+		t.Errorf("expected the redirect itself to be probed, got %+v", sample)
+	}
+
+	httpProbe = newTestHttpProbe(t, server.URL+"/new", "", "Welcome", "", "")
+	ctx, trace := WithRetrievalTrace(context.Background())
+	sample = Retrieve(ctx, httpProbe, httpProbe.Timeout())
+	if sample.Err != nil || sample.Meta[HttpProbeBodySize] != strconv.Itoa(len(body)) || sample.Meta[HttpProbeAssertionPassed] != "true" {
+		t.Errorf("expected the whole body to be scanned regardless of the maximum response body size, got %+v", sample)
+	}
+	if httpTrace := trace.Dto().Http; httpTrace == nil || httpTrace.StatusCode != 200 || httpTrace.BodySize != len(body) || httpTrace.Truncated {
+		t.Errorf("expected the probe to be traced, got %+v", httpTrace)
+	}
+
+	httpProbe = newTestHttpProbe(t, server.URL+"/new", "", "Goodbye", "", "")
+	sample = Retrieve(context.Background(), httpProbe, httpProbe.Timeout())
+	if sample.Err == nil || sample.Meta[HttpProbeStatusCode] != "200" || sample.Meta[HttpProbeAssertionPassed] != "false" {
+		t.Errorf("expected a failed assertion, got %+v", sample)
+	}
+}
+
+func TestHttpProbeRecordsTimeouts(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-unblock:
+		case <-req.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(unblock) })
+
+	httpProbe := newTestHttpProbe(t, server.URL, "", "", "", "")
+	sample := Retrieve(context.Background(), httpProbe, 300*time.Millisecond)
+	if sample.Err == nil || sample.Err.Error() != "Sample retrieval timed out." {
+		t.Errorf("expected the probe to time out, got %+v", sample)
+	}
+	if sample.Meta[HttpProbeAssertionPassed] != "false" || len(sample.Meta[HttpProbeResponseTime]) == 0 {
+		t.Errorf("expected the response time and the failed assertion to be recorded, got %+v", sample.Meta)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestReconcileDataSources(t *testing.T) {
+	rest := newTestRestApi(t)
+
+	scheduled := newTestUrlScraper(t, "ds-scheduled")
+	paused := newTestUrlScraper(t, "ds-paused")
+	paused.SetPaused(true)
+	invalid, err := NewUrlScraper(NewAbstractDataSourceWithId("ds-invalid", "ds-invalid", time.Minute, 0), "http://localhost/", "h1", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dataSource := range []DataSource{scheduled, paused, invalid} {
+		if err := rest.dataStore.PersistDataSource(dataSource); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unknownType, err := EncodeRecord("DsUnknown", 1, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corruptPayload, err := EncodeRecord(DsUrlScraper, UrlScraperRecordVersion, []byte("garbage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	undecodable := map[string][]byte{
+		"ds-unknown-type":     unknownType,
+		"ds-truncated-header": []byte(RecordMagic + "\x00"),
+		"ds-corrupt-payload":  corruptPayload,
+	}
+	err = rest.dataStore.db.Update(func(tx *bolt.Tx) error {
+		for dataSourceId, recordBytes := range undecodable {
+			if err := tx.Bucket([]byte(BoltDataSourcesBucket)).Put([]byte(dataSourceId), recordBytes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ReconcileDataSources(rest.dataStore, rest.scheduler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(report.Scheduled, []string{"ds-scheduled"}) || !rest.isScheduled(t, "ds-scheduled") {
+		t.Errorf("expected ds-scheduled to be scheduled, got %v", report.Scheduled)
+	}
+	if !reflect.DeepEqual(report.Paused, []string{"ds-paused"}) || rest.isScheduled(t, "ds-paused") {
+		t.Errorf("expected ds-paused not to be scheduled, got %v", report.Paused)
+	}
+	if len(report.Failures) != 4 || report.Failures["ds-invalid"] == nil {
+		t.Errorf("expected ds-invalid and the undecodable data sources to fail, got %v", report.Failures)
+	}
+	for dataSourceId := range undecodable {
+		if report.Failures[dataSourceId] == nil {
+			t.Errorf("expected the undecodable data source %s to be reported", dataSourceId)
+		}
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+func TestApplyTransformationScript(t *testing.T) {
+	tests := []struct {
+		value  string
+		script string
+		result string
+	}{
+		{"4.2", "", "4.2"},
+		{"4.2", "Math.round(value * 10)", "42"},
+		{"1,234", "parseInt(value.replace(',', ''), 10) + 1", "1235"},
+		// the value is passed as variable, so it can't break out of the script
+		{"\"; throw 'injected'; \"\nline 2 ' \\ </script>", "value", "\"; throw 'injected'; \"\nline 2 ' \\ </script>"},
+		{"a\"b\nc", "value.split('\\n').length", "2"},
+		{"42", "typeof eval + ',' + typeof console + ',' + typeof Function + ',' + typeof require", "undefined,undefined,undefined,undefined"},
+	}
+
+	for _, test := range tests {
+		result, err := ApplyTransformationScript(context.Background(), test.value, test.script)
+		if err != nil || result != test.result {
+			t.Errorf("expected %s to transform %q into %q, got %q (%v)", test.script, test.value, test.result, result, err)
+		}
+	}
+}
+
+func TestApplyTransformationScriptSandbox(t *testing.T) {
+	scripts := []string{
+		"eval('1 + 1')",
+		"console.log(value)",
+		"Function('return 1 + 1')()",
+		"(function(){}).constructor('return 1 + 1')()",
+		"Object.getPrototypeOf(function(){}).constructor('return 1 + 1')()",
+		"Math.max.constructor('return 1 + 1')()",
+	}
+
+	for _, script := range scripts {
+		if result, err := ApplyTransformationScript(context.Background(), "42", script); err == nil {
+			t.Errorf("expected %s to fail, got %q", script, result)
+		}
+	}
+}
+
+func TestApplyTransformationScriptTimeLimit(t *testing.T) {
+	start := time.Now()
+	result, err := ApplyTransformationScript(context.Background(), "42", "(function(){ while (true) {} })()")
+	if err == nil || !strings.Contains(err.Error(), "time limit") {
+		t.Errorf("expected the endless loop to exceed the time limit, got %q (%v)", result, err)
+	}
+	if elapsed := time.Since(start); elapsed > TransformationScriptTimeout+time.Second {
+		t.Errorf("expected the script to be interrupted after %s, took %s", TransformationScriptTimeout, elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := ApplyTransformationScript(ctx, "42", "(function(){ while (true) {} })()"); err != context.DeadlineExceeded {
+		t.Errorf("expected the script to be interrupted as soon as ctx is done, got %v", err)
+	}
+}
+
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+/* ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** ***** */
+
+// baselineAbstractDataSource encodes an AbstractDataSource the way it has been stored before the introduction of records.
+type baselineAbstractDataSource struct {
+	dataSourceId string
+	name         string
+	interval     time.Duration
+	timeout      time.Duration
+}
+
+func (ds baselineAbstractDataSource) GobEncode() ([]byte, error) {
+	buff := new(bytes.Buffer)
+	encoder := gob.NewEncoder(buff)
+	for _, field := range []interface{}{ds.dataSourceId, ds.name, ds.interval, ds.timeout} {
+		if err := encoder.Encode(field); err != nil {
+			return nil, err
+		}
+	}
+	return buff.Bytes(), nil
+}
+
+func TestDecodeDataSourceRecordReadsBaselineUrlScrapers(t *testing.T) {
+	buff := new(bytes.Buffer)
+	encoder := gob.NewEncoder(buff)
+	for _, field := range []interface{}{baselineAbstractDataSource{"ds-a", "Answer", time.Minute, 10 * time.Second}, "http://localhost/", "h1", "value * 2"} {
+		if err := encoder.Encode(field); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataSource, err := DecodeDataSourceRecord(buff.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	urlScraper, ok := dataSource.(*UrlScraper)
+	if !ok {
+		t.Fatalf("expected a URL scraper, got %T", dataSource)
+	}
+	if urlScraper.Id() != "ds-a" || urlScraper.Name() != "Answer" || urlScraper.Interval() != time.Minute || urlScraper.Timeout() != 10*time.Second {
+		t.Errorf("expected the abstract data source to be restored, got %+v", urlScraper.AbstractDataSource)
+	}
+	if urlScraper.url != "http://localhost/" || urlScraper.cssPath != "h1" || urlScraper.transformationScript != "value * 2" {
+		t.Errorf("expected the URL scraper settings to be restored, got %+v", urlScraper)
+	}
+
+	// a current record survives the round trip
+	recordBytes, err := EncodeDataSourceRecord(urlScraper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataSource, err := DecodeDataSourceRecord(recordBytes); err != nil || !reflect.DeepEqual(dataSource, urlScraper) {
+		t.Errorf("expected %+v, got %+v (%v)", urlScraper, dataSource, err)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+// +build !unix
+
+package main
+
+import (
+	"os/exec"
+)
+
+// PrepareProcessGroup isn't supported on platforms other than Unix (yet). Only the command itself gets killed on cancellation.
+func PrepareProcessGroup(cmd *exec.Cmd) {
+}
@@ -0,0 +1,18 @@
+//go:build unix
+// +build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// PrepareProcessGroup starts the command in a process group of its own and kills the whole group on cancellation,
+// so that e.g. the children of a shell don't outlive the command.
+func PrepareProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -18,12 +18,14 @@
                     );
                 })
 
+                // numbers are parsed by the server according to the value type (null if the value isn't numeric)
                 dataSource.chartData = {
                     labels: labels,
-                    series: [dataSource.series]
+                    series: [dataSource.numbers]
                 }
                 delete dataSource.labels;
                 delete dataSource.series
+                delete dataSource.numbers
 
                 this.dataSources.push(dataSource);
             }.bind(this))
@@ -58,7 +60,7 @@
                             10
                         );
 
-                        pushLimit(dataSource.chartData.series[0], sample.value, 10);
+                        pushLimit(dataSource.chartData.series[0], sample.number, 10);
                     }
                 });
 
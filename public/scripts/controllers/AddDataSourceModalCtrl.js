@@ -28,7 +28,7 @@ angular.module('kasperbrettApp').controller('AddDataSourceModalCtrl', function($
   			dataSource.id = res.data.dataSourceId;
   			dataSource.chartData = {
   				labels: [formatDateComponent(date.getHours()) + ':' + formatDateComponent(date.getMinutes()) + ':' + formatDateComponent(date.getSeconds())],
-  				series: [[res.data.value]]
+  				series: [[res.data.number]]
   			};
   			$modalInstance.close(dataSource);
   		}, function(err) {
@@ -0,0 +1,24 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"syscall"
+)
+
+// StatFs returns the usage of the file system that contains the given path.
+func StatFs(path string) (DiskUsage, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return DiskUsage{}, err
+	}
+
+	blockSize := uint64(stat.Bsize)
+	return DiskUsage{
+		Total:     stat.Blocks * blockSize,
+		Free:      stat.Bfree * blockSize,
+		Available: stat.Bavail * blockSize,
+	}, nil
+}
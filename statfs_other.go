@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// StatFs isn't supported on platforms other than Linux (yet).
+func StatFs(path string) (DiskUsage, error) {
+	return DiskUsage{}, ErrSystemMetricsNotSupported
+}